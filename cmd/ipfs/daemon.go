@@ -11,6 +11,7 @@ import (
 	"os"
 	"runtime"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -25,6 +26,7 @@ import (
 	commands "github.com/ipfs/go-ipfs/core/commands"
 	"github.com/ipfs/go-ipfs/core/coreapi"
 	corehttp "github.com/ipfs/go-ipfs/core/corehttp"
+	"github.com/ipfs/go-ipfs/core/corepins"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	libp2p "github.com/ipfs/go-ipfs/core/node/libp2p"
 	nodeMount "github.com/ipfs/go-ipfs/fuse/node"
@@ -36,6 +38,7 @@ import (
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	mprome "github.com/ipfs/go-metrics-prometheus"
 	options "github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
 	goprocess "github.com/jbenet/goprocess"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -72,6 +75,10 @@ const (
 	// swarmAddrKwd  = "address-swarm"
 )
 
+// defaultUnixSocketMode only lets the user running the daemon connect to the
+// unix sockets of the API and gateway
+const defaultUnixSocketMode = "0600"
+
 var daemonCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Run a network-connected IPFS node.",
@@ -105,6 +112,14 @@ control your node remotely. If you need to control the node remotely,
 make sure to protect the port as you would other services or database
 (firewall, authenticated proxy, etc).
 
+The API and gateway can also listen on unix domain sockets, so that file
+permissions decide who can use them:
+
+  ipfs config --json Addresses.API '["/unix/run/ipfs/api.sock"]'
+  ipfs config API.UnixSocketMode 0660
+
+The ipfs command line finds the socket in the 'api' file of the repo.
+
 HTTP Headers
 
 ipfs supports passing arbitrary headers to the API and Gateway. You can
@@ -462,8 +477,16 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	}
 	node.Process.AddChild(goprocess.WithTeardown(cctx.Plugins.Close))
 
+	// the denylist and the cache shared by the gateways of the API and of
+	// Addresses.Gateway
+	gwState, err := corehttp.NewGatewayState(node)
+	if err != nil {
+		return err
+	}
+	node.Process.AddChild(goprocess.WithTeardown(gwState.Close))
+
 	// construct api endpoint - every time
-	apiErrc, err := serveHTTPApi(req, cctx)
+	apiErrc, err := serveHTTPApi(req, cctx, gwState)
 	if err != nil {
 		return err
 	}
@@ -485,6 +508,14 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	// remove the pins added with --expires-in once they expire
+	go node.PinStore.RemoveExpiredPeriodically(req.Context, node.Pinning, node.Blockstore)
+
+	// resume the recursive pins interrupted before their DAG was fetched
+	if !offline {
+		resumePins(node)
+	}
+
 	// Add any files downloaded by migration.
 	if cacheMigrations || pinMigrations {
 		err = addMigrations(cctx.Context(), node, fetcher, pinMigrations)
@@ -506,7 +537,19 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	}
 
 	// construct http gateway
-	gwErrc, err := serveHTTPGateway(req, cctx)
+	gwErrc, err := serveHTTPGateway(req, cctx, gwState)
+	if err != nil {
+		return err
+	}
+
+	// construct webdav server
+	webdavErrc, err := serveWebDAV(cctx)
+	if err != nil {
+		return err
+	}
+
+	// construct s3 server
+	s3Errc, err := serveS3(cctx)
 	if err != nil {
 		return err
 	}
@@ -577,7 +620,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesn't follow this pattern for graceful shutdown
 	var errs error
-	for err := range merge(apiErrc, gwErrc, gcErrc) {
+	for err := range merge(apiErrc, gwErrc, webdavErrc, s3Errc, gcErrc) {
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
@@ -587,7 +630,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 }
 
 // serveHTTPApi collects options, creates listener, prints status message and starts serving requests
-func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error) {
+func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context, gwState *corehttp.GatewayState) (<-chan error, error) {
 	cfg, err := cctx.GetConfig()
 	if err != nil {
 		return nil, fmt.Errorf("serveHTTPApi: GetConfig() failed: %s", err)
@@ -611,6 +654,11 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 		listenerAddrs[string(listener.Multiaddr().Bytes())] = true
 	}
 
+	socketMode, err := parseUnixSocketMode(cfg.API.UnixSocketMode)
+	if err != nil {
+		return nil, fmt.Errorf("serveHTTPApi: invalid API.UnixSocketMode: %s", err)
+	}
+
 	for _, addr := range apiAddrs {
 		apiMaddr, err := ma.NewMultiaddr(addr)
 		if err != nil {
@@ -620,7 +668,7 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 			continue
 		}
 
-		apiLis, err := manet.Listen(apiMaddr)
+		apiLis, err := listen(apiMaddr, socketMode)
 		if err != nil {
 			return nil, fmt.Errorf("serveHTTPApi: manet.Listen(%s) failed: %s", apiMaddr, err)
 		}
@@ -644,13 +692,15 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 	// only the webui objects are allowed.
 	// if you know what you're doing, go ahead and pass --unrestricted-api.
 	unrestricted, _ := req.Options[unrestrictedApiAccessKwd].(bool)
-	gatewayOpt := corehttp.GatewayOption(false, corehttp.WebUIPaths...)
+	gatewayOpt := gwState.GatewayOption(false, corehttp.WebUIPaths...)
 	if unrestricted {
-		gatewayOpt = corehttp.GatewayOption(true, "/ipfs", "/ipns")
+		gatewayOpt = gwState.GatewayOption(true, "/ipfs", "/ipns")
 	}
 
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("api"),
+		// before any option registering routes, so that they all require tokens
+		corehttp.APITokensOption(),
 		corehttp.MetricsOpenCensusCollectionOption(),
 		corehttp.CheckVersionOption(),
 		corehttp.CommandsOption(*cctx),
@@ -697,6 +747,48 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 	return errc, nil
 }
 
+// listen listens on the given multiaddr. The file of a unix socket is given
+// the mode, and replaces the stale socket of a previous daemon which didn't
+// shut down cleanly.
+func listen(maddr ma.Multiaddr, mode os.FileMode) (manet.Listener, error) {
+	network, path, err := manet.DialArgs(maddr)
+	if err != nil || network != "unix" {
+		return manet.Listen(maddr)
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is used by another process", path)
+		}
+		log.Warnf("removing the stale unix socket %s", path)
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	lis, err := manet.Listen(maddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return lis, nil
+}
+
+// parseUnixSocketMode parses an octal file mode like "0660".
+func parseUnixSocketMode(mode *config.OptionalString) (os.FileMode, error) {
+	s := mode.WithDefault(defaultUnixSocketMode)
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m&^uint64(os.ModePerm) != 0 {
+		return 0, fmt.Errorf("%q is not an octal file mode", s)
+	}
+	return os.FileMode(m), nil
+}
+
 // printSwarmAddrs prints the addresses of the host
 func printSwarmAddrs(node *core.IpfsNode) {
 	if !node.IsOnline {
@@ -729,7 +821,7 @@ func printSwarmAddrs(node *core.IpfsNode) {
 }
 
 // serveHTTPGateway collects options, creates listener, prints status message and starts serving requests
-func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error) {
+func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context, gwState *corehttp.GatewayState) (<-chan error, error) {
 	cfg, err := cctx.GetConfig()
 	if err != nil {
 		return nil, fmt.Errorf("serveHTTPGateway: GetConfig() failed: %s", err)
@@ -750,6 +842,11 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 		listenerAddrs[string(listener.Multiaddr().Bytes())] = true
 	}
 
+	socketMode, err := parseUnixSocketMode(cfg.Gateway.UnixSocketMode)
+	if err != nil {
+		return nil, fmt.Errorf("serveHTTPGateway: invalid Gateway.UnixSocketMode: %s", err)
+	}
+
 	gatewayAddrs := cfg.Addresses.Gateway
 	for _, addr := range gatewayAddrs {
 		gatewayMaddr, err := ma.NewMultiaddr(addr)
@@ -761,7 +858,7 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 			continue
 		}
 
-		gwLis, err := manet.Listen(gatewayMaddr)
+		gwLis, err := listen(gatewayMaddr, socketMode)
 		if err != nil {
 			return nil, fmt.Errorf("serveHTTPGateway: manet.Listen(%s) failed: %s", gatewayMaddr, err)
 		}
@@ -784,8 +881,8 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("gateway"),
-		corehttp.HostnameOption(),
-		corehttp.GatewayOption(writable, "/ipfs", "/ipns"),
+		gwState.HostnameOption(),
+		gwState.GatewayOption(writable, "/ipfs", "/ipns"),
 		corehttp.VersionOption(),
 		corehttp.CheckVersionOption(),
 		corehttp.CommandsROOption(cmdctx),
@@ -826,6 +923,113 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 	return errc, nil
 }
 
+// serveWebDAV serves MFS over WebDAV on Addresses.WebDAV, if any
+func serveWebDAV(cctx *oldcmds.Context) (<-chan error, error) {
+	cfg, err := cctx.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("serveWebDAV: GetConfig() failed: %s", err)
+	}
+
+	return serveMFS(cctx, mfsServer{
+		name:        "WebDAV",
+		label:       "WebDAV",
+		addrs:       cfg.Addresses.WebDAV,
+		credentials: "WebDAV.Users",
+		anonymous:   len(cfg.WebDAV.Users) == 0,
+		readOnly:    cfg.WebDAV.ReadOnly.WithDefault(false),
+		opts: []corehttp.ServeOption{
+			corehttp.MetricsCollectionOption("webdav"),
+			corehttp.WebDAVOption(),
+		},
+	})
+}
+
+// mfsServer is a server exposing MFS, like WebDAV or the S3 API.
+type mfsServer struct {
+	name  string // in the errors, like "WebDAV"
+	label string // in the messages, like "S3 API"
+	addrs []string
+
+	// credentials is the config key of the credentials of the clients, and
+	// anonymous whether it is empty.
+	credentials string
+	anonymous   bool
+	readOnly    bool
+
+	opts []corehttp.ServeOption
+}
+
+// serveMFS listens on the addresses of the server and serves it. Without
+// credentials, the server is read-only on the addresses which are not local,
+// as its serve option enforces, and a warning says so.
+func serveMFS(cctx *oldcmds.Context, srv mfsServer) (<-chan error, error) {
+	var listeners []manet.Listener
+	for _, addr := range srv.addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("serve%s: invalid %s address: %q (err: %s)", srv.name, srv.name, addr, err)
+		}
+		lis, err := manet.Listen(maddr)
+		if err != nil {
+			return nil, fmt.Errorf("serve%s: manet.Listen(%s) failed: %s", srv.name, maddr, err)
+		}
+		listeners = append(listeners, lis)
+	}
+
+	for _, listener := range listeners {
+		srvType := "writable"
+		if srv.readOnly {
+			srvType = "readonly"
+		} else if srv.anonymous && !corehttp.IsLocalListener(manet.NetListener(listener)) {
+			srvType = "readonly"
+			fmt.Printf("WARNING: %s is empty: the %s server on %s is read-only and open to anyone who can reach it\n", srv.credentials, srv.label, listener.Multiaddr())
+		}
+		fmt.Printf("%s (%s) server listening on %s\n", srv.label, srvType, listener.Multiaddr())
+	}
+
+	node, err := cctx.ConstructNode()
+	if err != nil {
+		return nil, fmt.Errorf("serve%s: ConstructNode() failed: %s", srv.name, err)
+	}
+
+	errc := make(chan error)
+	var wg sync.WaitGroup
+	for _, lis := range listeners {
+		wg.Add(1)
+		go func(lis manet.Listener) {
+			defer wg.Done()
+			errc <- corehttp.Serve(node, manet.NetListener(lis), srv.opts...)
+		}(lis)
+	}
+
+	go func() {
+		wg.Wait()
+		close(errc)
+	}()
+
+	return errc, nil
+}
+
+// serveS3 serves the S3-compatible API over MFS on Addresses.S3, if any
+func serveS3(cctx *oldcmds.Context) (<-chan error, error) {
+	cfg, err := cctx.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("serveS3: GetConfig() failed: %s", err)
+	}
+
+	return serveMFS(cctx, mfsServer{
+		name:        "S3",
+		label:       "S3 API",
+		addrs:       cfg.Addresses.S3,
+		credentials: "S3.AccessKeys",
+		anonymous:   len(cfg.S3.AccessKeys) == 0,
+		opts: []corehttp.ServeOption{
+			corehttp.MetricsCollectionOption("s3"),
+			corehttp.S3Option(),
+		},
+	})
+}
+
 //collects options and opens the fuse mountpoint
 func mountFuse(req *cmds.Request, cctx *oldcmds.Context) error {
 	cfg, err := cctx.GetConfig()
@@ -871,6 +1075,55 @@ func maybeRunGC(req *cmds.Request, node *core.IpfsNode) (<-chan error, error) {
 	return errc, nil
 }
 
+// pinResumeConcurrency is how many of the pins interrupted are resumed at the
+// same time, each fetching corepins.FetchConcurrency blocks at a time.
+const pinResumeConcurrency = 4
+
+// resumePins adds again the recursive pins whose fetch was interrupted, which
+// resumes their fetch from the frontier saved, pinResumeConcurrency at a time.
+// The fetches stop with the node.
+func resumePins(node *core.IpfsNode) {
+	ctx := node.Context()
+	fetches, err := node.PinFetches.Pending(ctx)
+	if err != nil {
+		log.Errorf("listing the pins to resume: %s", err)
+		return
+	}
+	if len(fetches) == 0 {
+		return
+	}
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		log.Errorf("failed to access CoreAPI: %v", err)
+		return
+	}
+
+	todo := make(chan corepins.FetchState)
+	for i := 0; i < pinResumeConcurrency && i < len(fetches); i++ {
+		go func() {
+			for f := range todo {
+				ctx := corepins.WithAddSettings(ctx, corepins.AddSettings{Info: f.Info})
+				log.Infof("resuming the pin of %s", f.Cid)
+				if err := api.Pin().Add(ctx, ipath.IpfsPath(f.Cid), options.Pin.Recursive(true)); err != nil {
+					log.Errorf("resuming the pin of %s: %s", f.Cid, err)
+					continue
+				}
+				log.Infof("resumed the pin of %s", f.Cid)
+			}
+		}()
+	}
+	go func() {
+		defer close(todo)
+		for _, f := range fetches {
+			select {
+			case todo <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
 // merge does fan-in of multiple read-only error channels
 // taken from http://blog.golang.org/pipelines
 func merge(cs ...<-chan error) <-chan error {
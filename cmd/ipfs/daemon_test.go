@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	config "github.com/ipfs/go-ipfs/config"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestParseUnixSocketMode(t *testing.T) {
+	for _, test := range []struct {
+		config string
+		mode   os.FileMode
+		ok     bool
+	}{
+		{"", 0600, true},
+		{"0660", 0660, true},
+		{"777", 0777, true},
+		{"0999", 0, false},
+		{"01777", 0, false},
+		{"rw-rw----", 0, false},
+	} {
+		var mode *config.OptionalString
+		if test.config != "" {
+			mode = new(config.OptionalString)
+			if err := mode.UnmarshalJSON([]byte(`"` + test.config + `"`)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m, err := parseUnixSocketMode(mode)
+		if (err == nil) != test.ok || m != test.mode {
+			t.Errorf("%q: expected %o (ok=%t), got %o (%v)", test.config, test.mode, test.ok, m, err)
+		}
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket file modes are not supported on windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "api.sock")
+	maddr, err := ma.NewMultiaddr("/unix" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the socket of a daemon which didn't shut down cleanly
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	lis, err := listen(maddr, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0660 {
+		t.Errorf("expected the mode 0660, got %o", fi.Mode().Perm())
+	}
+
+	// the socket of a running daemon is left alone
+	if _, err := listen(maddr, 0660); err == nil {
+		t.Error("expected the socket in use not to be replaced")
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("expected the socket to still accept connections: %s", err)
+	}
+	conn.Close()
+}
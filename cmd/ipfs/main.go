@@ -42,6 +42,7 @@ var dnsResolver = madns.DefaultResolver
 
 const (
 	EnvEnableProfiling = "IPFS_PROF"
+	EnvAPIToken        = "IPFS_API_TOKEN"
 	cpuProfile         = "ipfs.cpuprof"
 	heapProfile        = "ipfs.memprof"
 )
@@ -277,6 +278,14 @@ func makeExecutor(req *cmds.Request, env interface{}) (cmds.Executor, error) {
 		cmdhttp.ClientWithAPIPrefix(corehttp.APIPath),
 	}
 
+	apiToken, ok := req.Options[corecmds.ApiTokenOption].(string)
+	if !ok {
+		apiToken = os.Getenv(EnvAPIToken)
+	}
+	if apiToken != "" {
+		opts = append(opts, cmdhttp.ClientWithHeader("Authorization", "Bearer "+apiToken))
+	}
+
 	// Fallback on a local executor if we (a) have a repo and (b) aren't
 	// forcing a daemon.
 	if !daemonRequested && fsrepo.IsInitialized(cctx.ConfigRoot) {
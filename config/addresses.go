@@ -8,4 +8,6 @@ type Addresses struct {
 	NoAnnounce     []string // swarm addresses not to announce to the network
 	API            Strings  // address for the local API (RPC)
 	Gateway        Strings  // address to listen on for IPFS HTTP object gateway
+	WebDAV         Strings  // addresses to listen on for the WebDAV server over MFS, disabled when empty
+	S3             Strings  // addresses to listen on for the S3-compatible API over MFS, disabled when empty
 }
@@ -1,5 +1,88 @@
 package config
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
 type API struct {
 	HTTPHeaders map[string][]string // HTTP headers to return with the API.
+
+	// Tokens maps names to the bearer tokens accepted by the API. When set,
+	// every request has to be authenticated with one of them.
+	Tokens map[string]*APIToken `json:",omitempty"`
+
+	// UnixSocketMode is the file mode of the unix sockets the API listens
+	// on, in octal like "0660". Defaults to "0600".
+	UnixSocketMode *OptionalString `json:",omitempty"`
+
+	// AuditLog configures the log of the calls to the API changing the node.
+	AuditLog APIAuditLog
+}
+
+// APIAuditLog configures the append-only log of the API calls, read with
+// `ipfs log audit`.
+type APIAuditLog struct {
+	// Enabled turns the audit log on. Defaults to false.
+	Enabled Flag `json:",omitempty"`
+
+	// Path is the path of the log, relative to the repo. Defaults to
+	// "audit.log".
+	Path *OptionalString `json:",omitempty"`
+
+	// MaxSize is the size at which the log is rotated, like "100MB".
+	// Defaults to "100MB".
+	MaxSize *OptionalString `json:",omitempty"`
+
+	// MaxFiles is the number of rotated logs kept. Defaults to 5.
+	MaxFiles *OptionalInteger `json:",omitempty"`
+
+	// Commands lists the command paths logged, with the patterns of
+	// APIToken.Allow. Defaults to the commands changing the node, and
+	// "key/export".
+	Commands []string `json:",omitempty"`
+}
+
+// APIToken is a bearer token accepted by the API, managed with
+// `ipfs api token`.
+type APIToken struct {
+	// Hash is the hex encoded SHA-256 of the token, which is not stored.
+	Hash string
+
+	// Allow lists the command paths the token may call, like "files/read".
+	// "pin/*" allows pin and all its subcommands, and "*" allows everything.
+	Allow []string
+}
+
+// HashAPIToken returns the hash of a token stored in APIToken.Hash.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Allows returns whether the token may call the command at the given path,
+// like "pin/add".
+func (t *APIToken) Allows(cmdPath string) bool {
+	return MatchCommand(t.Allow, cmdPath)
+}
+
+// MatchCommand returns whether the command at the given path, like
+// "pin/add", matches one of the patterns: "pin/*" matches pin and all its
+// subcommands, and "*" matches everything.
+func MatchCommand(patterns []string, cmdPath string) bool {
+	for _, pattern := range patterns {
+		switch {
+		case pattern == "*":
+			return true
+		case strings.HasSuffix(pattern, "/*"):
+			prefix := strings.TrimSuffix(pattern, "*")
+			if cmdPath+"/" == prefix || strings.HasPrefix(cmdPath, prefix) {
+				return true
+			}
+		case pattern == cmdPath:
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestAPITokenAllows(t *testing.T) {
+	token := &APIToken{Allow: []string{"pin/*", "files/read", "version"}}
+	for path, allowed := range map[string]bool{
+		"pin":                true,
+		"pin/add":            true,
+		"pin/remote/service": true,
+		"pinfoo":             false,
+		"files/read":         true,
+		"files/write":        false,
+		"files":              false,
+		"version":            true,
+		"version/deps":       false,
+		"config/replace":     false,
+		"key/export":         false,
+		"":                   false,
+	} {
+		if token.Allows(path) != allowed {
+			t.Errorf("%q: expected allowed=%t", path, allowed)
+		}
+	}
+
+	if !(&APIToken{Allow: []string{"*"}}).Allows("config/replace") {
+		t.Error("expected * to allow everything")
+	}
+	if (&APIToken{}).Allows("version") {
+		t.Error("expected a token without Allow to allow nothing")
+	}
+}
@@ -24,6 +24,8 @@ type Config struct {
 	Bootstrap []string  // local nodes's bootstrap peer addresses
 	Gateway   Gateway   // local node's gateway server options
 	API       API       // local node's API settings
+	WebDAV    WebDAV    // local node's WebDAV server options
+	S3        S3        // local node's S3-compatible API options
 	Swarm     SwarmConfig
 	AutoNAT   AutoNATConfig
 	Pubsub    PubsubConfig
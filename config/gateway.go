@@ -35,6 +35,11 @@ type Gateway struct {
 	// writing is done through the API, not the gateway.
 	Writable bool
 
+	// TrustlessOnly configures the gateway to only serve verifiable
+	// responses: raw blocks and CARs. Deserialized responses are rejected,
+	// and subdomain redirects and writable methods are disabled.
+	TrustlessOnly bool
+
 	// PathPrefixes  is an array of acceptable url paths that a client can
 	// specify in X-Ipfs-Path-Prefix header.
 	//
@@ -68,4 +73,88 @@ type Gateway struct {
 	// PublicGateways configures behavior of known public gateways.
 	// Each key is a fully qualified domain name (FQDN).
 	PublicGateways map[string]*GatewaySpec
+
+	// Denylists is a list of files with CIDs, CID+path prefixes and IPNS
+	// names that the gateway refuses to serve. Relative paths are relative
+	// to the repo directory. The files are reloaded when they change.
+	Denylists []string
+
+	// DenylistResponse is the body of the 410 Gone response returned for
+	// content on one of the denylists.
+	DenylistResponse *OptionalString `json:",omitempty"`
+
+	// RateLimit configures per-client limits on gateway requests.
+	RateLimit GatewayRateLimit
+
+	// Timeouts configures the deadlines of gateway requests.
+	Timeouts GatewayTimeouts
+
+	// Cache configures the in-process cache of resolved paths and directory
+	// listings.
+	Cache GatewayCache
+
+	// UnixSocketMode is the file mode of the unix sockets the gateway
+	// listens on, in octal like "0666". Defaults to "0600".
+	UnixSocketMode *OptionalString `json:",omitempty"`
+}
+
+// GatewayCache configures the response cache of the gateway. The cache is
+// disabled unless MaxMemory is set.
+type GatewayCache struct {
+	// MaxMemory is the size of the in-memory cache of resolved paths and
+	// directory listings, like "64MiB".
+	MaxMemory *OptionalString `json:",omitempty"`
+
+	// MaxDisk is the size of the on-disk cache of directory listings, like
+	// "1GiB". Disabled by default.
+	MaxDisk *OptionalString `json:",omitempty"`
+
+	// Dir is the directory of the on-disk cache. Relative paths are relative
+	// to the repo directory.
+	Dir *OptionalString `json:",omitempty"`
+
+	// IPNSTTL is how long /ipns/ paths are cached once resolved.
+	IPNSTTL *OptionalDuration `json:",omitempty"`
+}
+
+// GatewayTimeouts limits the time spent on each gateway request. Requests
+// hitting one of the deadlines fail with 504 Gateway Timeout. Setting a
+// timeout to 0 disables it.
+type GatewayTimeouts struct {
+	// Resolution is the time allowed to resolve the content path, including
+	// IPNS and DNSLink names and the blocks along the path.
+	Resolution *OptionalDuration `json:",omitempty"`
+
+	// FirstBlock is the time allowed to fetch the root block of the
+	// resolved content, once the path is resolved.
+	FirstBlock *OptionalDuration `json:",omitempty"`
+
+	// Total is the time allowed for the whole request, including sending
+	// the response.
+	Total *OptionalDuration `json:",omitempty"`
+}
+
+// GatewayRateLimit limits the gateway requests of each client, identified by
+// its IP address. Limits which are not set, or set to 0, are disabled.
+type GatewayRateLimit struct {
+	// RequestsPerSecond is the rate at which the token bucket of each client
+	// is refilled.
+	RequestsPerSecond *OptionalInteger `json:",omitempty"`
+
+	// Burst is the size of the token bucket of each client, i.e. the number
+	// of requests a client can make at once. Defaults to RequestsPerSecond.
+	Burst *OptionalInteger `json:",omitempty"`
+
+	// MaxConcurrentRequests is the number of requests a client can have in
+	// flight at the same time.
+	MaxConcurrentRequests *OptionalInteger `json:",omitempty"`
+
+	// MaxBytesPerMinute is the number of response bytes a client can receive
+	// per minute. Once reached, the response in progress is cut off and new
+	// requests are rejected until the end of the minute.
+	MaxBytesPerMinute *OptionalInteger `json:",omitempty"`
+
+	// TrustedProxies is a list of IP addresses or CIDR ranges of reverse
+	// proxies whose X-Forwarded-For header is trusted to identify clients.
+	TrustedProxies []string `json:",omitempty"`
 }
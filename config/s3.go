@@ -0,0 +1,11 @@
+package config
+
+// S3 configures the S3-compatible API listening on Addresses.S3, which stores
+// objects in MFS (`ipfs files`): buckets are its top-level directories.
+type S3 struct {
+	// AccessKeys maps access key IDs to their secret key. When set, every
+	// request has to be signed with one of them (AWS Signature Version 4).
+	// When empty, the API is read-only on the addresses other than loopback
+	// ones and unix sockets.
+	AccessKeys map[string]string `json:",omitempty"`
+}
@@ -0,0 +1,17 @@
+package config
+
+// WebDAV configures the WebDAV server listening on Addresses.WebDAV, which
+// exposes the MFS root (`ipfs files`) to WebDAV clients.
+type WebDAV struct {
+	// Root is the MFS directory served at the root of the WebDAV server.
+	// Defaults to "/".
+	Root *OptionalString `json:",omitempty"`
+
+	// ReadOnly rejects all the requests that would modify MFS.
+	ReadOnly Flag `json:",omitempty"`
+
+	// Users maps user names to their password. When set, every request has
+	// to be authenticated with HTTP basic auth. When empty, the server is
+	// read-only on the addresses other than loopback ones and unix sockets.
+	Users map[string]string `json:",omitempty"`
+}
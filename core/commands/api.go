@@ -0,0 +1,221 @@
+package commands
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	config "github.com/ipfs/go-ipfs/config"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+)
+
+var APICmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the access to the RPC API.",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"token": apiTokenCmd,
+	},
+}
+
+var apiTokenCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the bearer tokens of the RPC API.",
+		ShortDescription: `
+Once a token exists, every request to the RPC API has to carry one in an
+'Authorization: Bearer <token>' header, and may only call the commands
+allowed for the token. Requests without a valid token are rejected with
+'401 Unauthorized', and calls to other commands with '403 Forbidden'.
+`,
+		LongDescription: `
+Once a token exists, every request to the RPC API has to carry one in an
+'Authorization: Bearer <token>' header, and may only call the commands
+allowed for the token. Requests without a valid token are rejected with
+'401 Unauthorized', and calls to other commands with '403 Forbidden'.
+
+The ipfs command line sends the token given with --api-token, or in the
+IPFS_API_TOKEN environment variable. Create a token allowing all the
+commands for your own use first:
+
+  > ipfs api token create admin --allow='*'
+  > export IPFS_API_TOKEN=<token>
+  > ipfs api token create ci --allow='pin/*' --allow=files/read
+
+The tokens are stored hashed in the API.Tokens config, and changes apply
+to the running daemon immediately.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"create": apiTokenCreateCmd,
+		"ls":     apiTokenLsCmd,
+		"rm":     apiTokenRmCmd,
+	},
+}
+
+// APITokenOutput is a token of the RPC API. The token itself is only known
+// when it's created.
+type APITokenOutput struct {
+	Name  string
+	Token string `json:",omitempty"`
+	Allow []string
+}
+
+type APITokenList struct {
+	Tokens []APITokenOutput
+}
+
+const apiTokenAllowOptionName = "allow"
+
+var apiTokenCreateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create a bearer token for the RPC API.",
+		ShortDescription: `
+Creates a token allowed to call the given command paths, and prints it. The
+token can't be retrieved later. A path ending with '/*' allows the command
+and all its subcommands, and '*' allows all the commands.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, false, "Name of the token."),
+	},
+	Options: []cmds.Option{
+		cmds.StringsOption(apiTokenAllowOptionName, "Command path the token may call, like 'files/read' or 'pin/*'. Can be repeated."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		name := req.Arguments[0]
+		allow, _ := req.Options[apiTokenAllowOptionName].([]string)
+		if len(allow) == 0 {
+			return fmt.Errorf("no command allowed: pass at least one --%s", apiTokenAllowOptionName)
+		}
+		for _, p := range allow {
+			if p == "*" {
+				continue
+			}
+			if _, err := Root.Resolve(strings.Split(strings.TrimSuffix(p, "/*"), "/")); err != nil {
+				return fmt.Errorf("invalid command path %q: %s", p, err)
+			}
+		}
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		token := base64.RawURLEncoding.EncodeToString(secret)
+
+		err := updateAPITokens(env, func(tokens map[string]*config.APIToken) error {
+			if _, ok := tokens[name]; ok {
+				return fmt.Errorf("token %q already exists", name)
+			}
+			tokens[name] = &config.APIToken{Hash: config.HashAPIToken(token), Allow: allow}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &APITokenOutput{Name: name, Token: token, Allow: allow})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *APITokenOutput) error {
+			_, err := fmt.Fprintln(w, out.Token)
+			return err
+		}),
+	},
+	Type: APITokenOutput{},
+}
+
+var apiTokenLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the bearer tokens of the RPC API.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+		repo, err := fsrepo.Open(cfgRoot)
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+		cfg, err := repo.Config()
+		if err != nil {
+			return err
+		}
+
+		list := APITokenList{Tokens: make([]APITokenOutput, 0, len(cfg.API.Tokens))}
+		for name, token := range cfg.API.Tokens {
+			if token == nil {
+				continue
+			}
+			list.Tokens = append(list.Tokens, APITokenOutput{Name: name, Allow: token.Allow})
+		}
+		sort.Slice(list.Tokens, func(i, j int) bool {
+			return list.Tokens[i].Name < list.Tokens[j].Name
+		})
+		return cmds.EmitOnce(res, &list)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, list *APITokenList) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			for _, token := range list.Tokens {
+				fmt.Fprintf(tw, "%s\t%s\n", token.Name, strings.Join(token.Allow, " "))
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: APITokenList{},
+}
+
+var apiTokenRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Revoke a bearer token of the RPC API.",
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, true, "Names of the tokens to revoke."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		return updateAPITokens(env, func(tokens map[string]*config.APIToken) error {
+			for _, name := range req.Arguments {
+				if _, ok := tokens[name]; !ok {
+					return fmt.Errorf("no token named %q", name)
+				}
+				delete(tokens, name)
+			}
+			return nil
+		})
+	},
+}
+
+// updateAPITokens stores the API.Tokens modified by the update function.
+func updateAPITokens(env cmds.Environment, update func(map[string]*config.APIToken) error) error {
+	cfgRoot, err := cmdenv.GetConfigRoot(env)
+	if err != nil {
+		return err
+	}
+	repo, err := fsrepo.Open(cfgRoot)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	tokens := make(map[string]*config.APIToken, len(cfg.API.Tokens)+1)
+	for name, token := range cfg.API.Tokens {
+		tokens[name] = token
+	}
+	if err := update(tokens); err != nil {
+		return err
+	}
+	// the whole map is replaced, SetConfig would keep the revoked tokens
+	return repo.SetConfigKey("API.Tokens", tokens)
+}
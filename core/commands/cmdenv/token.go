@@ -0,0 +1,28 @@
+package cmdenv
+
+import (
+	"context"
+
+	config "github.com/ipfs/go-ipfs/config"
+)
+
+type apiTokenKey struct{}
+
+// APIToken is the API token a request was authenticated with.
+type APIToken struct {
+	Name string
+	*config.APIToken
+}
+
+// ContextWithAPIToken returns a context carrying the API token a request was
+// authenticated with.
+func ContextWithAPIToken(ctx context.Context, token APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey{}, token)
+}
+
+// GetAPIToken returns the API token the request of the context was
+// authenticated with, if any.
+func GetAPIToken(ctx context.Context) (APIToken, bool) {
+	token, ok := ctx.Value(apiTokenKey{}).(APIToken)
+	return token, ok
+}
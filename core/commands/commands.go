@@ -66,6 +66,7 @@ func CommandsCmd(root *cmds.Command) *cmds.Command {
 		},
 		Subcommands: map[string]*cmds.Command{
 			"completion": CompletionCmd(root),
+			"openapi":    OpenAPICmd(root),
 		},
 		Options: []cmds.Option{
 			cmds.BoolOption(flagsOptionName, "f", "Show command flags"),
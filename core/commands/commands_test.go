@@ -24,6 +24,7 @@ func TestROCommands(t *testing.T) {
 		"/commands",
 		"/commands/completion",
 		"/commands/completion/bash",
+		"/commands/openapi",
 		"/dag",
 		"/dag/get",
 		"/dag/resolve",
@@ -69,10 +70,16 @@ func TestROCommands(t *testing.T) {
 			t.Errorf("subcommand %q is nil even though there was no error", path)
 		}
 	}
+	checkOpenAPI(t, RootRO, list)
 }
 func TestCommands(t *testing.T) {
 	list := []string{
 		"/add",
+		"/api",
+		"/api/token",
+		"/api/token/create",
+		"/api/token/ls",
+		"/api/token/rm",
 		"/bitswap",
 		"/bitswap/ledger",
 		"/bitswap/reprovide",
@@ -99,6 +106,7 @@ func TestCommands(t *testing.T) {
 		"/commands",
 		"/commands/completion",
 		"/commands/completion/bash",
+		"/commands/openapi",
 		"/config",
 		"/config/edit",
 		"/config/profile",
@@ -126,6 +134,8 @@ func TestCommands(t *testing.T) {
 		"/diag/profile",
 		"/diag/sys",
 		"/dns",
+		"/events",
+		"/events/subscribe",
 		"/file",
 		"/file/ls",
 		"/files",
@@ -145,6 +155,12 @@ func TestCommands(t *testing.T) {
 		"/filestore/verify",
 		"/get",
 		"/id",
+		"/jobs",
+		"/jobs/cancel",
+		"/jobs/logs",
+		"/jobs/ls",
+		"/jobs/status",
+		"/jobs/submit",
 		"/key",
 		"/key/export",
 		"/key/gen",
@@ -154,6 +170,7 @@ func TestCommands(t *testing.T) {
 		"/key/rm",
 		"/key/rotate",
 		"/log",
+		"/log/audit",
 		"/log/level",
 		"/log/ls",
 		"/log/tail",
@@ -205,6 +222,9 @@ func TestCommands(t *testing.T) {
 		"/pin/remote/service/rm",
 		"/pin/rm",
 		"/pin/update",
+		"/pin/status",
+		"/pin/export",
+		"/pin/import",
 		"/pin/verify",
 		"/ping",
 		"/pubsub",
@@ -279,4 +299,5 @@ func TestCommands(t *testing.T) {
 			t.Errorf("subcommand %q is nil even though there was no error", path)
 		}
 	}
+	checkOpenAPI(t, Root, list)
 }
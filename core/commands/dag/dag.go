@@ -17,10 +17,11 @@ import (
 )
 
 const (
-	pinRootsOptionName = "pin-roots"
-	progressOptionName = "progress"
-	silentOptionName   = "silent"
-	statsOptionName    = "stats"
+	pinRootsOptionName   = "pin-roots"
+	progressOptionName   = "progress"
+	silentOptionName     = "silent"
+	statsOptionName      = "stats"
+	carVersionOptionName = "car-version"
 )
 
 // DagCmd provides a subset of commands for interacting with ipld dag objects
@@ -265,6 +266,12 @@ var DagExportCmd = &cmds.Command{
 'ipfs dag export' fetches a DAG and streams it out as a well-formed .car file.
 Note that at present only single root selections / .car files are supported.
 The output of blocks happens in strict DAG-traversal, first-seen, order.
+
+By default a CARv1 is written. With --car-version=2 the output is a CARv2
+with an index of all blocks appended, allowing random access to the blocks
+without re-indexing. As the CARv2 header records the size of the data, the
+DAG is traversed twice, and nothing is written until the whole DAG has been
+fetched.
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -272,6 +279,7 @@ The output of blocks happens in strict DAG-traversal, first-seen, order.
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(progressOptionName, "p", "Display progress on CLI. Defaults to true when STDERR is a TTY."),
+		cmds.IntOption(carVersionOptionName, "CAR format version to write: 1 or 2 (with index).").WithDefault(1),
 	},
 	Run: dagExport,
 	PostRun: cmds.PostRunMap{
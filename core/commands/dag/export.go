@@ -17,7 +17,11 @@ import (
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	gocar "github.com/ipld/go-car"
+	gocarv2 "github.com/ipld/go-car/v2"
+	carindex "github.com/ipld/go-car/v2/index"
 	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 )
 
 func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -29,6 +33,11 @@ func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 		)
 	}
 
+	carVersion, _ := req.Options[carVersionOptionName].(int)
+	if carVersion != 1 && carVersion != 2 {
+		return fmt.Errorf("unsupported CAR version %d, only 1 and 2 are supported", carVersion)
+	}
+
 	api, err := cmdenv.GetApi(env, req)
 	if err != nil {
 		return err
@@ -50,7 +59,13 @@ func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 		// TraverseLinksOnlyOnce is safe for an exhaustive selector but won't be when we allow
 		// arbitrary selectors here
 		car := gocar.NewSelectiveCar(req.Context, store, []gocar.Dag{dag}, gocar.TraverseLinksOnlyOnce())
-		if err := car.Write(pipeW); err != nil {
+		var err error
+		if carVersion == 2 {
+			err = writeCarV2(car, pipeW)
+		} else {
+			err = car.Write(pipeW)
+		}
+		if err != nil {
 			errCh <- err
 		}
 	}()
@@ -78,6 +93,43 @@ func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 	return err
 }
 
+// writeCarV2 writes the CARv1 stream of car wrapped in a CARv2, with an index
+// of the blocks appended. The CARv2 header includes the size of the CARv1
+// data, so the DAG is traversed once to prepare the car before writing.
+func writeCarV2(car gocar.SelectiveCar, w io.Writer) error {
+	var records []carindex.Record
+	prepared, err := car.Prepare(func(blk gocar.Block) error {
+		// same as go-car, identity CIDs carry their data and are not indexed
+		if blk.BlockCID.Prefix().MhType != multihash.IDENTITY {
+			records = append(records, carindex.Record{Cid: blk.BlockCID, Offset: blk.Offset})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(gocarv2.Pragma); err != nil {
+		return err
+	}
+	if _, err := gocarv2.NewHeader(prepared.Size()).WriteTo(w); err != nil {
+		return err
+	}
+	if err := prepared.Dump(w); err != nil {
+		return err
+	}
+
+	idx, err := carindex.New(multicodec.CarMultihashIndexSorted)
+	if err != nil {
+		return err
+	}
+	if err := idx.Load(records); err != nil {
+		return err
+	}
+	_, err = carindex.WriteTo(idx, w)
+	return err
+}
+
 func finishCLIExport(res cmds.Response, re cmds.ResponseEmitter) error {
 
 	var showProgress bool
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coreevents"
+)
+
+const eventsTypeOptionName = "type"
+
+var EventsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Watch the events of the node.",
+		ShortDescription: `
+'ipfs events' streams the events of a running daemon: peers connecting and
+disconnecting, pins added and removed, garbage collections, IPNS names
+published, changes of the root of the files API, and provides.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"subscribe": eventsSubscribeCmd,
+	},
+}
+
+var eventsSubscribeCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Stream the events of the node.",
+		ShortDescription: `
+Streams the events of the node as they happen, until the command is
+interrupted. Each event has a sequence number, a type, a time, and data
+depending on its type.
+`,
+		LongDescription: `
+Streams the events of the node as they happen, until the command is
+interrupted. Each event has a sequence number, a type, a time, and data
+depending on its type.
+
+The types of events are:
+
+` + eventsTypesHelp() + `
+--type selects the types of events streamed, either by name or by category,
+like 'pin' for both 'pin/added' and 'pin/removed'. It can be repeated.
+
+  > ipfs events subscribe --type=pin --type=gc/finished
+
+The events are also streamed as server-sent events by a GET request to
+/api/v0/events/subscribe on the RPC API, with the same 'type' parameter.
+
+The events are buffered for each subscriber: the subscribers which don't
+keep up miss events, noticed by gaps in the sequence numbers.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringsOption(eventsTypeOptionName, "t", "Types or categories of the events to stream, all if unset."),
+	},
+	NoLocal: true,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		filter, _ := req.Options[eventsTypeOptionName].([]string)
+		sub, err := n.Events.Subscribe(filter)
+		if err != nil {
+			return err
+		}
+		defer sub.Close()
+
+		for {
+			select {
+			case e := <-sub.Events():
+				if err := res.Emit(&e); err != nil {
+					return err
+				}
+			case <-req.Context.Done():
+				return nil
+			}
+		}
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, e *coreevents.Event) error {
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s %s %s\n", e.Time.Format(time.RFC3339), e.Type, data)
+			return err
+		}),
+	},
+	Type: coreevents.Event{},
+}
+
+func eventsTypesHelp() string {
+	var b strings.Builder
+	for _, t := range coreevents.Types {
+		fmt.Fprintf(&b, "  %s\n", t)
+	}
+	return b.String()
+}
@@ -0,0 +1,397 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	files "github.com/ipfs/go-ipfs-files"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/corejobs"
+)
+
+// jobCommands are the commands which can run as jobs
+var jobCommands = map[string]bool{
+	"dag/import":  true,
+	"dht/provide": true,
+	"pin/add":     true,
+	"pin/update":  true,
+	"pin/verify":  true,
+	"repo/gc":     true,
+	"repo/verify": true,
+}
+
+var JobsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Run long commands detached from the requests.",
+		ShortDescription: `
+Jobs run commands inside the daemon, detached from the request which
+submitted them: closing the connection doesn't cancel them, and their
+status and outputs can be checked later.
+`,
+		LongDescription: `
+Jobs run commands inside the daemon, detached from the request which
+submitted them: closing the connection doesn't cancel them, and their
+status and outputs can be checked later.
+
+  > ipfs jobs submit pin/add --argument=QmFoo --option=progress=true
+  8e5b2d1c7a9f0e34
+  > ipfs jobs status 8e5b2d1c7a9f0e34
+  > ipfs jobs logs --follow 8e5b2d1c7a9f0e34
+
+The jobs are stored in the datastore: the jobs which are running when the
+daemon stops are marked as interrupted, and are not restarted. Finished
+jobs are removed after a week.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"submit": jobsSubmitCmd,
+		"ls":     jobsLsCmd,
+		"status": jobsStatusCmd,
+		"cancel": jobsCancelCmd,
+		"logs":   jobsLogsCmd,
+	},
+}
+
+type JobList struct {
+	Jobs []corejobs.Job
+}
+
+const (
+	jobsArgOptionName    = "argument"
+	jobsOptionOptionName = "option"
+	jobsFollowOptionName = "follow"
+)
+
+var jobsSubmitCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Run a command as a job.",
+		ShortDescription: `
+Starts a job running the command, and prints its ID. The commands which can
+run as jobs are:
+
+  dag/import, dht/provide, pin/add, pin/update, pin/verify, repo/gc and
+  repo/verify
+
+The input files of the command, like the CARs of 'dag/import', are read
+before the job ID is returned:
+
+  > ipfs jobs submit dag/import ./big.car --option=pin-roots=false
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("command", true, false, "Command to run, like 'pin/add'."),
+		cmds.FileArg("input", false, true, "Input files of the command."),
+	},
+	Options: []cmds.Option{
+		cmds.StringsOption(jobsArgOptionName, "Argument of the command. Can be repeated."),
+		cmds.StringsOption(jobsOptionOptionName, "Option of the command, like 'recursive=false'. Can be repeated."),
+	},
+	NoLocal: true,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		cmdPath := strings.Trim(strings.ReplaceAll(req.Arguments[0], " ", "/"), "/")
+		if !jobCommands[cmdPath] {
+			allowed := make([]string, 0, len(jobCommands))
+			for p := range jobCommands {
+				allowed = append(allowed, p)
+			}
+			sort.Strings(allowed)
+			return fmt.Errorf("%q can't run as a job, only %s can", cmdPath, strings.Join(allowed, ", "))
+		}
+		if token, ok := cmdenv.GetAPIToken(req.Context); ok && !token.Allows(cmdPath) {
+			return fmt.Errorf("token %q is not allowed to call %q", token.Name, cmdPath)
+		}
+		path := strings.Split(cmdPath, "/")
+
+		args, _ := req.Options[jobsArgOptionName].([]string)
+		opts, err := parseJobOptions(path, req.Options[jobsOptionOptionName])
+		if err != nil {
+			return err
+		}
+
+		cmd, err := Root.Get(path)
+		if err != nil {
+			return err
+		}
+		var input *jobInput
+		var jobFiles files.Directory
+		for _, arg := range cmd.Arguments {
+			if arg.Type == cmds.ArgFile && req.Files != nil {
+				input = &jobInput{Directory: req.Files, done: make(chan struct{})}
+				jobFiles = input
+				break
+			}
+		}
+
+		optMap := make(cmds.OptMap, len(opts))
+		optDefs, err := Root.GetOptions(path)
+		if err != nil {
+			return err
+		}
+		for name, values := range opts {
+			if optDefs[name].Type() == cmds.Strings {
+				optMap[name] = values
+			} else {
+				optMap[name] = values[len(values)-1]
+			}
+		}
+		jobReq, err := cmds.NewRequest(context.Background(), path, optMap, args, jobFiles, Root)
+		if err != nil {
+			return err
+		}
+		if err := jobReq.FillDefaults(); err != nil {
+			return err
+		}
+		if err := cmd.CheckArguments(jobReq); err != nil {
+			return err
+		}
+
+		finished := make(chan struct{})
+		job, err := n.Jobs.Submit(cmdPath, args, opts, func(ctx context.Context, emit func(interface{}) error) error {
+			defer close(finished)
+			jobReq.Context = ctx
+			re, jobRes := cmds.NewChanResponsePair(jobReq)
+			go Root.Call(jobReq, re, env)
+			for {
+				v, err := jobRes.Next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err := emit(v); err != nil {
+					return err
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		// the input is read from this request
+		if input != nil {
+			select {
+			case <-input.done:
+			case <-finished:
+			case <-req.Context.Done():
+				return req.Context.Err()
+			}
+		}
+
+		return cmds.EmitOnce(res, &job)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, job *corejobs.Job) error {
+			_, err := fmt.Fprintln(w, job.ID)
+			return err
+		}),
+	},
+	Type: corejobs.Job{},
+}
+
+// parseJobOptions parses the "name=value" options of a job command. Options
+// without a value are set to true.
+func parseJobOptions(path []string, v interface{}) (map[string][]string, error) {
+	optDefs, err := Root.GetOptions(path)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := v.([]string)
+	opts := make(map[string][]string, len(values))
+	for _, opt := range values {
+		name, value := opt, "true"
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			name, value = opt[:i], opt[i+1:]
+		}
+		optDef, ok := optDefs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown option %q for %s", name, strings.Join(path, "/"))
+		}
+		name = optDef.Name()
+		opts[name] = append(opts[name], value)
+	}
+	return opts, nil
+}
+
+// jobInput signals when a job has read all the input files, which are read
+// from the request submitting the job.
+type jobInput struct {
+	files.Directory
+	done chan struct{}
+	once sync.Once
+}
+
+func (d *jobInput) Entries() files.DirIterator {
+	return &jobInputIterator{DirIterator: d.Directory.Entries(), input: d}
+}
+
+type jobInputIterator struct {
+	files.DirIterator
+	input *jobInput
+}
+
+func (it *jobInputIterator) Next() bool {
+	if it.DirIterator.Next() {
+		return true
+	}
+	it.input.once.Do(func() { close(it.input.done) })
+	return false
+}
+
+var jobsLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the jobs.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		jobs, err := n.Jobs.List()
+		if err != nil {
+			return err
+		}
+		if jobs == nil {
+			jobs = []corejobs.Job{}
+		}
+		return cmds.EmitOnce(res, &JobList{Jobs: jobs})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, list *JobList) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			for _, job := range list.Jobs {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", job.ID, job.Status, job.Created.Format(time.RFC3339), jobCommandLine(job))
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: JobList{},
+}
+
+// jobCommandLine formats the command of a job like the ipfs command line.
+func jobCommandLine(job corejobs.Job) string {
+	line := []string{strings.ReplaceAll(job.Command, "/", " ")}
+	names := make([]string, 0, len(job.Options))
+	for name := range job.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range job.Options[name] {
+			line = append(line, "--"+name+"="+value)
+		}
+	}
+	return strings.Join(append(line, job.Arguments...), " ")
+}
+
+var jobsStatusCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the status of a job.",
+		ShortDescription: `
+Shows the status of a job, and the last value output by its command, which
+reports its progress.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", true, false, "ID of the job."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		job, err := n.Jobs.Get(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &job)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, job *corejobs.Job) error {
+			fmt.Fprintf(w, "ID:       %s\n", job.ID)
+			fmt.Fprintf(w, "Command:  %s\n", jobCommandLine(*job))
+			fmt.Fprintf(w, "Status:   %s\n", job.Status)
+			fmt.Fprintf(w, "Created:  %s\n", job.Created.Format(time.RFC3339))
+			if job.Finished != nil {
+				fmt.Fprintf(w, "Finished: %s\n", job.Finished.Format(time.RFC3339))
+			}
+			fmt.Fprintf(w, "Outputs:  %d\n", job.Outputs)
+			if job.Progress != nil {
+				fmt.Fprintf(w, "Progress: %s\n", job.Progress)
+			}
+			if job.Error != "" {
+				fmt.Fprintf(w, "Error:    %s\n", job.Error)
+			}
+			return nil
+		}),
+	},
+	Type: corejobs.Job{},
+}
+
+var jobsCancelCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Cancel running jobs.",
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", true, true, "IDs of the jobs."),
+	},
+	NoLocal: true,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		for _, id := range req.Arguments {
+			if err := n.Jobs.Cancel(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var jobsLogsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the outputs of a job.",
+		ShortDescription: `
+Shows the values output by the command of a job, encoded to JSON. The last
+1000 outputs of each job are kept. With --follow, the outputs of a running
+job are streamed until it finishes.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", true, false, "ID of the job."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(jobsFollowOptionName, "f", "Stream the outputs until the job finishes."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		follow, _ := req.Options[jobsFollowOptionName].(bool)
+		return n.Jobs.Logs(req.Context, req.Arguments[0], follow, func(entry corejobs.LogEntry) error {
+			return res.Emit(&entry)
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, entry *corejobs.LogEntry) error {
+			_, err := fmt.Fprintf(w, "%s %s\n", entry.Time.Format(time.RFC3339), entry.Value)
+			return err
+		}),
+	},
+	Type: corejobs.LogEntry{},
+}
@@ -3,8 +3,15 @@ package commands
 import (
 	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"time"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	oldcmds "github.com/ipfs/go-ipfs/commands"
+	config "github.com/ipfs/go-ipfs/config"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coreaudit"
 	logging "github.com/ipfs/go-log"
 	lwriter "github.com/ipfs/go-log/writer"
 )
@@ -35,6 +42,7 @@ system (not just for the daemon logs, but all commands):
 		"level": logLevelCmd,
 		"ls":    logLsCmd,
 		"tail":  logTailCmd,
+		"audit": logAuditCmd,
 	},
 }
 
@@ -123,3 +131,104 @@ Outputs event log messages (not other log messages) as they are generated.
 		return res.Emit(r)
 	},
 }
+
+const (
+	logAuditCommandOptionName = "command"
+	logAuditTokenOptionName   = "token"
+	logAuditSinceOptionName   = "since"
+)
+
+var logAuditCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Read the audit log of the API.",
+		ShortDescription: `
+Outputs the calls to the API changing the node, logged when
+API.AuditLog.Enabled is true, oldest first.
+
+  > ipfs log audit --command='pin/*' --since=24h
+`,
+		LongDescription: `
+Outputs the calls to the API changing the node, logged when
+API.AuditLog.Enabled is true, oldest first. The rotated logs are read too.
+
+Each entry has the time of the call, the address of the client, the name of
+its API token, the command with its arguments and options, and the HTTP
+status of the response with the error of the command. Secrets in the
+arguments, like the values of the 'Pinning.RemoteServices.*.API.Key' keys
+of 'ipfs config', are redacted.
+
+  > ipfs log audit --command='pin/*' --since=24h
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringsOption(logAuditCommandOptionName, "Only output the calls to the commands, like 'pin/add' or 'pin/*'."),
+		cmds.StringOption(logAuditTokenOptionName, "Only output the calls with the named API token."),
+		cmds.StringOption(logAuditSinceOptionName, "Only output the calls made in the given duration, like '24h'."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := env.(*oldcmds.Context).GetConfig()
+		if err != nil {
+			return err
+		}
+		auditLog := cfg.API.AuditLog
+		auditLog.Enabled = config.True
+		audit, err := coreaudit.Open(cfgRoot, auditLog)
+		if err != nil {
+			return err
+		}
+
+		commands, _ := req.Options[logAuditCommandOptionName].([]string)
+		token, _ := req.Options[logAuditTokenOptionName].(string)
+		var since time.Time
+		if s, _ := req.Options[logAuditSinceOptionName].(string); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			since = time.Now().Add(-d)
+		}
+
+		return coreaudit.Read(audit.Path, func(entry coreaudit.Entry) error {
+			if len(commands) > 0 && !config.MatchCommand(commands, entry.Command) ||
+				token != "" && entry.Token != token ||
+				entry.Time.Before(since) {
+				return nil
+			}
+			return res.Emit(&entry)
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, entry *coreaudit.Entry) error {
+			remote, token := entry.RemoteAddr, entry.Token
+			if remote == "" {
+				remote = "-"
+			}
+			if token == "" {
+				token = "-"
+			}
+			line := []string{strings.ReplaceAll(entry.Command, "/", " ")}
+			names := make([]string, 0, len(entry.Options))
+			for name := range entry.Options {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				for _, value := range entry.Options[name] {
+					line = append(line, "--"+name+"="+value)
+				}
+			}
+			line = append(line, entry.Arguments...)
+			fmt.Fprintf(w, "%s %s %s %d %s", entry.Time.Format(time.RFC3339), remote, token, entry.Status, strings.Join(line, " "))
+			if entry.Error != "" {
+				fmt.Fprintf(w, ": %s", entry.Error)
+			}
+			_, err := fmt.Fprintln(w)
+			return err
+		}),
+	},
+	Type: coreaudit.Entry{},
+}
@@ -0,0 +1,519 @@
+package commands
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	cid "github.com/ipfs/go-cid"
+	version "github.com/ipfs/go-ipfs"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	cmdsHttp "github.com/ipfs/go-ipfs-cmds/http"
+)
+
+// OpenAPIDocument is an OpenAPI 3 description of the HTTP RPC API, generated
+// from the command tree by OpenAPI.
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Servers    []OpenAPIServer                         `json:"servers"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+
+	// Streams is set for the commands outputting bytes, if anything, rather
+	// than JSON values.
+	Streams bool `json:"x-ipfs-stream,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema"`
+	Explode     *bool          `json:"explode,omitempty"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Headers     map[string]*OpenAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIHeader struct {
+	Description string         `json:"description,omitempty"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPISchema is the subset of the JSON schemas of OpenAPI used to
+// describe the options and the outputs of the commands.
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Default              interface{}               `json:"default,omitempty"`
+}
+
+const openAPIErrorSchema = "Error"
+
+// OpenAPI returns the OpenAPI description of the commands of the tree which
+// can be called over HTTP.
+func OpenAPI(root *cmds.Command) *OpenAPIDocument {
+	g := &openAPIGenerator{
+		root:    root,
+		schemas: make(map[string]*OpenAPISchema),
+		names:   make(map[reflect.Type]string),
+	}
+	g.schemas[openAPIErrorSchema] = &OpenAPISchema{
+		Type: "object",
+		Properties: map[string]*OpenAPISchema{
+			"Message": {Type: "string"},
+			"Code":    {Type: "integer"},
+			"Type":    {Type: "string"},
+		},
+	}
+
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title: "IPFS RPC API",
+			Description: "The commands of the ipfs daemon. Every command is called with a POST " +
+				"request; the positional arguments are passed in the arg query parameter, " +
+				"in order, and the options in the query parameters of their names.",
+			Version: version.CurrentVersionNumber,
+		},
+		Servers:    []OpenAPIServer{{URL: "/api/v0"}},
+		Paths:      make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{Schemas: g.schemas},
+	}
+	g.walk(doc, nil, root)
+	return doc
+}
+
+type openAPIGenerator struct {
+	root    *cmds.Command
+	schemas map[string]*OpenAPISchema
+	names   map[reflect.Type]string
+}
+
+func (g *openAPIGenerator) walk(doc *OpenAPIDocument, path []string, cmd *cmds.Command) {
+	if cmd.NoRemote {
+		return
+	}
+	if cmd.Run != nil && len(path) > 0 {
+		doc.Paths["/"+strings.Join(path, "/")] = map[string]*OpenAPIOperation{
+			"post": g.operation(path, cmd),
+		}
+	}
+
+	names := make([]string, 0, len(cmd.Subcommands))
+	for name := range cmd.Subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g.walk(doc, append(path[:len(path):len(path)], name), cmd.Subcommands[name])
+	}
+}
+
+func (g *openAPIGenerator) operation(path []string, cmd *cmds.Command) *OpenAPIOperation {
+	op := &OpenAPIOperation{
+		OperationID: openAPIOperationID(path),
+		Summary:     cmd.Helptext.Tagline,
+		Description: strings.TrimSpace(cmd.Helptext.ShortDescription),
+		Tags:        []string{path[0]},
+		Deprecated:  cmd.Status == cmds.Deprecated || cmd.Status == cmds.Removed,
+		Responses: map[string]*OpenAPIResponse{
+			"500": {
+				Description: "The command failed.",
+				Content: map[string]*OpenAPIMediaType{
+					"application/json": {Schema: &OpenAPISchema{Ref: "#/components/schemas/" + openAPIErrorSchema}},
+				},
+			},
+		},
+	}
+
+	// the string arguments are passed in order in the arg parameters, the
+	// files in a multipart body
+	var (
+		argNames []string
+		required bool
+		files    bool
+	)
+	for i, arg := range cmd.Arguments {
+		if arg.Type == cmds.ArgFile {
+			files = true
+			continue
+		}
+		name := arg.Name
+		if arg.Variadic {
+			name += "..."
+		}
+		argNames = append(argNames, name)
+		required = required || (i == 0 && arg.Required && !arg.SupportsStdin)
+		if arg.SupportsStdin {
+			files = true
+		}
+	}
+	if len(argNames) > 0 {
+		explode := true
+		var desc []string
+		for _, arg := range cmd.Arguments {
+			if arg.Type == cmds.ArgString {
+				desc = append(desc, fmt.Sprintf("%s: %s", arg.Name, arg.Description))
+			}
+		}
+		op.Parameters = append(op.Parameters, &OpenAPIParameter{
+			Name:        "arg",
+			In:          "query",
+			Description: fmt.Sprintf("The arguments <%s>, in order. %s", strings.Join(argNames, "> <"), strings.Join(desc, " ")),
+			Required:    required,
+			Schema:      &OpenAPISchema{Type: "array", Items: &OpenAPISchema{Type: "string"}},
+			Explode:     &explode,
+		})
+	}
+	if files {
+		fileRequired := false
+		for _, arg := range cmd.Arguments {
+			fileRequired = fileRequired || (arg.Type == cmds.ArgFile && arg.Required)
+		}
+		op.RequestBody = &OpenAPIRequestBody{
+			Required: fileRequired,
+			Content: map[string]*OpenAPIMediaType{
+				"multipart/form-data": {Schema: &OpenAPISchema{
+					Type: "object",
+					Properties: map[string]*OpenAPISchema{
+						"file": {Type: "string", Format: "binary"},
+					},
+				}},
+			},
+		}
+	}
+
+	if optDefs, err := g.root.GetOptions(path); err == nil {
+		names := make([]string, 0, len(optDefs))
+		for name, opt := range optDefs {
+			// the options are mapped by all their names
+			if name == opt.Name() && !g.clientOption(opt) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			op.Parameters = append(op.Parameters, openAPIOption(optDefs[name]))
+		}
+	}
+
+	op.Responses["200"] = g.response(cmd, op)
+	return op
+}
+
+// openAPIClientOptions are the options of Root read by the ipfs command
+// line, rather than the daemon.
+var openAPIClientOptions = map[string]bool{
+	ConfigOption:      true,
+	DebugOption:       true,
+	cmds.OptLongHelp:  true,
+	cmds.OptShortHelp: true,
+	LocalOption:       true,
+	OfflineOption:     true,
+	ApiOption:         true,
+	ApiTokenOption:    true,
+}
+
+func (g *openAPIGenerator) clientOption(opt cmds.Option) bool {
+	for _, rootOpt := range g.root.Options {
+		if rootOpt == opt {
+			return openAPIClientOptions[opt.Name()]
+		}
+	}
+	return false
+}
+
+// openAPIOperationID returns the camel cased path, like "pinRemoteAdd".
+func openAPIOperationID(path []string) string {
+	var id strings.Builder
+	for i, name := range path {
+		for j, word := range strings.Split(name, "-") {
+			if i == 0 && j == 0 || word == "" {
+				id.WriteString(word)
+				continue
+			}
+			r := []rune(word)
+			r[0] = unicode.ToUpper(r[0])
+			id.WriteString(string(r))
+		}
+	}
+	return id.String()
+}
+
+func openAPIOption(opt cmds.Option) *OpenAPIParameter {
+	var schema *OpenAPISchema
+	switch opt.Type() {
+	case cmds.Bool:
+		schema = &OpenAPISchema{Type: "boolean"}
+	case cmds.Int, cmds.Int64:
+		schema = &OpenAPISchema{Type: "integer", Format: "int64"}
+	case cmds.Uint, cmds.Uint64:
+		schema = &OpenAPISchema{Type: "integer", Format: "uint64"}
+	case cmds.Float:
+		schema = &OpenAPISchema{Type: "number"}
+	case cmds.Strings:
+		schema = &OpenAPISchema{Type: "array", Items: &OpenAPISchema{Type: "string"}}
+	default:
+		schema = &OpenAPISchema{Type: "string"}
+	}
+	schema.Default = opt.Default()
+
+	param := &OpenAPIParameter{
+		Name:        opt.Name(),
+		In:          "query",
+		Description: opt.Description(),
+		Schema:      schema,
+	}
+	if opt.Type() == cmds.Strings {
+		explode := true
+		param.Explode = &explode
+	}
+	return param
+}
+
+var (
+	openAPIReaderType        = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	openAPIJSONMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	openAPITextMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	openAPITimeType          = reflect.TypeOf(time.Time{})
+	openAPICidType           = reflect.TypeOf(cid.Cid{})
+)
+
+// response describes the output of the command: the JSON values of its
+// Type, or a stream of bytes.
+func (g *openAPIGenerator) response(cmd *cmds.Command, op *OpenAPIOperation) *OpenAPIResponse {
+	if cmd.Type == nil {
+		op.Streams = true
+		return &OpenAPIResponse{
+			Description: "The output of the command, if any.",
+			Headers: map[string]*OpenAPIHeader{
+				"X-Stream-Output":        {Description: "Set to 1 when the output is a stream of bytes.", Schema: &OpenAPISchema{Type: "string"}},
+				cmdsHttp.StreamErrHeader: {Description: "Trailer set to the error which interrupted the stream.", Schema: &OpenAPISchema{Type: "string"}},
+			},
+			Content: map[string]*OpenAPIMediaType{
+				"text/plain": {Schema: &OpenAPISchema{Type: "string", Format: "binary"}},
+			},
+		}
+	}
+
+	t := reflect.TypeOf(cmd.Type)
+	if t.Implements(openAPIReaderType) {
+		op.Streams = true
+		return &OpenAPIResponse{
+			Description: "The output of the command.",
+			Content: map[string]*OpenAPIMediaType{
+				"text/plain": {Schema: &OpenAPISchema{Type: "string", Format: "binary"}},
+			},
+		}
+	}
+	return &OpenAPIResponse{
+		Description: "The output of the command: a single value, or a stream of newline-delimited values when X-Chunked-Output is set.",
+		Headers: map[string]*OpenAPIHeader{
+			"X-Chunked-Output":       {Description: "Set to 1 when the output is a stream of values.", Schema: &OpenAPISchema{Type: "string"}},
+			cmdsHttp.StreamErrHeader: {Description: "Trailer set to the error which interrupted the stream.", Schema: &OpenAPISchema{Type: "string"}},
+		},
+		Content: map[string]*OpenAPIMediaType{
+			"application/json": {Schema: g.schema(t)},
+		},
+	}
+}
+
+// schema returns the JSON schema of the values of the type, as encoded by
+// encoding/json. Named structs are described in the components.
+func (g *openAPIGenerator) schema(t reflect.Type) *OpenAPISchema {
+	switch {
+	case t == openAPITimeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case t == openAPICidType:
+		return &OpenAPISchema{
+			Type:       "object",
+			Properties: map[string]*OpenAPISchema{"/": {Type: "string"}},
+		}
+	case t.Implements(openAPIJSONMarshalerType) || reflect.PtrTo(t).Implements(openAPIJSONMarshalerType):
+		// peer IDs, multiaddrs and the like marshal to strings, the others
+		// can't be told
+		if t.Kind() == reflect.String {
+			return &OpenAPISchema{Type: "string"}
+		}
+		return &OpenAPISchema{}
+	case t.Implements(openAPITextMarshalerType) || reflect.PtrTo(t).Implements(openAPITextMarshalerType):
+		return &OpenAPISchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if s.Ref != "" {
+			return s
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &OpenAPISchema{Type: "integer", Format: "uint64"}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		return &OpenAPISchema{Type: "array", Items: g.schema(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	default:
+		// interfaces can hold anything
+		return &OpenAPISchema{}
+	}
+}
+
+func (g *openAPIGenerator) structSchema(t reflect.Type) *OpenAPISchema {
+	if t.Name() == "" {
+		return g.structProperties(t)
+	}
+	name, ok := g.names[t]
+	if !ok {
+		name = t.Name()
+		if _, taken := g.schemas[name]; taken {
+			pkg := t.PkgPath()
+			name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+		}
+		g.names[t] = name
+		// registered first, for the recursive types
+		g.schemas[name] = &OpenAPISchema{}
+		*g.schemas[name] = *g.structProperties(t)
+	}
+	return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+func (g *openAPIGenerator) structProperties(t reflect.Type) *OpenAPISchema {
+	s := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.IndexByte(tag, ','); j >= 0 {
+			name, opts = tag[:j], tag[j:]
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		// the fields of embedded structs are promoted
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded := g.structProperties(ft)
+			for n, p := range embedded.Properties {
+				if _, ok := s.Properties[n]; !ok {
+					s.Properties[n] = p
+				}
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schema(f.Type)
+		if !strings.Contains(opts, ",omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// OpenAPICmd returns the command generating the OpenAPI description of the
+// commands of the tree.
+func OpenAPICmd(root *cmds.Command) *cmds.Command {
+	return &cmds.Command{
+		Helptext: cmds.HelpText{
+			Tagline: "Generate the OpenAPI description of the HTTP RPC API.",
+			ShortDescription: `
+Outputs an OpenAPI 3 document describing the commands which can be called
+over HTTP, with their arguments, options and outputs. It is also served by
+the daemon at /api/v0/openapi.json.
+
+  > ipfs commands openapi > ipfs-rpc.json
+`,
+		},
+		Extra: CreateCmdExtras(SetDoesNotUseRepo(true)),
+		Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+			return cmds.EmitOnce(res, OpenAPI(root))
+		},
+		Encoders: cmds.EncoderMap{
+			cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, doc *OpenAPIDocument) error {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(doc)
+			}),
+		},
+		Type: OpenAPIDocument{},
+	}
+}
@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+// checkOpenAPI checks that the OpenAPI description of the tree has the
+// commands of the list which can be called over HTTP, and only them.
+func checkOpenAPI(t *testing.T, root *cmds.Command, list []string) {
+	t.Helper()
+	doc := OpenAPI(root)
+
+	expected := make(map[string]bool)
+	for _, path := range list {
+		cmds, err := root.Resolve(strings.Split(path[1:], "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote := true
+		for _, cmd := range cmds {
+			remote = remote && !cmd.NoRemote
+		}
+		if remote && cmds[len(cmds)-1].Run != nil {
+			expected[path] = true
+		}
+	}
+	for path := range expected {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("%q not in the OpenAPI paths", path)
+		}
+	}
+	operationIDs := make(map[string]string)
+	for path, item := range doc.Paths {
+		if !expected[path] {
+			t.Errorf("%q in the OpenAPI paths but shouldn't be", path)
+		}
+		id := item["post"].OperationID
+		if other, ok := operationIDs[id]; ok {
+			t.Errorf("%q and %q have the same operation ID %q", path, other, id)
+		}
+		operationIDs[id] = path
+	}
+
+	// all the references resolve
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range regexp.MustCompile(`"\$ref":"#/components/schemas/([^"]+)"`).FindAllSubmatch(data, -1) {
+		if _, ok := doc.Components.Schemas[string(ref[1])]; !ok {
+			t.Errorf("unresolved schema %q", ref[1])
+		}
+	}
+}
+
+func TestOpenAPIOperation(t *testing.T) {
+	doc := OpenAPI(Root)
+
+	pinAdd := doc.Paths["/pin/add"]["post"]
+	if pinAdd.OperationID != "pinAdd" || pinAdd.Summary == "" || pinAdd.Tags[0] != "pin" {
+		t.Errorf("unexpected operation %+v", pinAdd)
+	}
+	params := make(map[string]*OpenAPIParameter)
+	for _, p := range pinAdd.Parameters {
+		params[p.Name] = p
+	}
+	// the paths can be read from stdin, sent in the body
+	if arg := params["arg"]; arg == nil || arg.Required || arg.Schema.Type != "array" || pinAdd.RequestBody == nil {
+		t.Errorf("unexpected arg parameter %+v", arg)
+	}
+	if recursive := params["recursive"]; recursive == nil || recursive.Schema.Type != "boolean" || recursive.Schema.Default != true {
+		t.Errorf("unexpected recursive parameter %+v", recursive)
+	}
+	if enc := params[cmds.EncLong]; enc == nil || enc.Schema.Type != "string" {
+		t.Errorf("expected the global options, got %+v", enc)
+	}
+	if params[ApiTokenOption] != nil || params[OfflineOption] != nil {
+		t.Error("expected the options of the command line to be left out")
+	}
+
+	// the output is reflected from the Type
+	schema := pinAdd.Responses["200"].Content["application/json"].Schema
+	if schema.Ref != "#/components/schemas/AddPinOutput" {
+		t.Fatalf("unexpected schema %+v", schema)
+	}
+	output := doc.Components.Schemas["AddPinOutput"]
+	if pins := output.Properties["Pins"]; pins == nil || pins.Type != "array" || pins.Items.Type != "string" {
+		t.Errorf("unexpected schema %+v", output)
+	}
+	if progress := output.Properties["Progress"]; progress == nil || progress.Type != "integer" {
+		t.Errorf("unexpected schema %+v", output)
+	}
+
+	if update := doc.Paths["/pin/update"]["post"]; !update.Parameters[0].Required || update.RequestBody != nil {
+		t.Errorf("expected the arguments to be required, got %+v", update.Parameters[0])
+	}
+	if add := doc.Paths["/add"]["post"]; add.RequestBody == nil || add.RequestBody.Content["multipart/form-data"] == nil {
+		t.Errorf("expected the files in a multipart body, got %+v", add.RequestBody)
+	}
+	if cat := doc.Paths["/cat"]["post"]; !cat.Streams || cat.Responses["200"].Content["text/plain"] == nil {
+		t.Errorf("expected cat to output bytes, got %+v", cat)
+	}
+	if opID := openAPIOperationID([]string{"object", "patch", "add-link"}); opID != "objectPatchAddLink" {
+		t.Errorf("unexpected operation ID %q", opID)
+	}
+}
@@ -0,0 +1,510 @@
+package pin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	files "github.com/ipfs/go-ipfs-files"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	gocarv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multicodec"
+
+	core "github.com/ipfs/go-ipfs/core"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/commands/cmdutils"
+	"github.com/ipfs/go-ipfs/core/corepins"
+)
+
+const (
+	pinFormatOptionName = "format"
+	pinCarOptionName    = "car"
+)
+
+var exportPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Export the local pins.",
+		ShortDescription: `
+Writes a manifest of the recursive and direct pins of the node, with their
+names, metadata and expiry, to restore them with 'ipfs pin import'.
+`,
+		LongDescription: `
+Writes a manifest of the recursive and direct pins of the node, with their
+names, metadata and expiry, to restore them with 'ipfs pin import'.
+
+The manifest is encoded in dag-json, or in dag-cbor with --format=cbor. With
+--car, a CAR is written instead, with the manifest as its only root, followed
+by the blocks of the DAGs of the recursive pins and the blocks of the direct
+pins, so that the node importing it doesn't fetch them.
+
+  > ipfs pin export --car > pins.car
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(pinFormatOptionName, "Encoding of the manifest: json or cbor.").WithDefault("json"),
+		cmds.BoolOption(pinCarOptionName, "Write a CAR with the blocks of the pins, and the manifest as its root."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		format, _ := req.Options[pinFormatOptionName].(string)
+		var codec multicodec.Code
+		switch format {
+		case "json":
+			codec = multicodec.DagJson
+		case "cbor":
+			codec = multicodec.DagCbor
+		default:
+			return fmt.Errorf("invalid %s %q, must be json or cbor", pinFormatOptionName, format)
+		}
+		car, _ := req.Options[pinCarOptionName].(bool)
+
+		// the pins exported stay pinned, and their blocks stored, until
+		// the export is done
+		unlocker := n.Blockstore.PinLock(req.Context)
+
+		m, err := pinManifest(req.Context, n)
+		if err != nil {
+			unlocker.Unlock(req.Context)
+			return err
+		}
+
+		if !car {
+			unlocker.Unlock(req.Context)
+			var buf bytes.Buffer
+			if err := m.Encode(&buf, codec); err != nil {
+				return err
+			}
+			return res.Emit(&buf)
+		}
+
+		pipeR, pipeW := io.Pipe()
+		errCh := make(chan error, 1)
+		go func() {
+			defer unlocker.Unlock(req.Context)
+			ng := dag.NewDAGService(bserv.New(n.Blockstore, offline.Exchange(n.Blockstore)))
+			err := writePinsCar(req.Context, pipeW, ng, m, codec)
+			pipeW.CloseWithError(err)
+			errCh <- err
+		}()
+
+		if err := res.Emit(pipeR); err != nil {
+			pipeR.Close() // ignore the error if any
+			return err
+		}
+		return <-errCh
+	},
+}
+
+// pinManifest returns the manifest of the recursive and direct pins of the
+// node, the recursive pins first.
+func pinManifest(ctx context.Context, n *core.IpfsNode) (*corepins.Manifest, error) {
+	infos, err := n.PinStore.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(corepins.Manifest)
+	for _, mode := range []pin.Mode{pin.Recursive, pin.Direct} {
+		var keys []cid.Cid
+		if mode == pin.Recursive {
+			keys, err = n.Pinning.RecursiveKeys(ctx)
+		} else {
+			keys, err = n.Pinning.DirectKeys(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].KeyString() < keys[j].KeyString() })
+
+		typ, _ := pin.ModeToString(mode)
+		for _, c := range keys {
+			m.Pins = append(m.Pins, corepins.ManifestPin{Cid: c, Type: typ, Info: infos[c]})
+		}
+	}
+	return m, nil
+}
+
+// writePinsCar writes a CARv1 with the manifest as its root and first block,
+// followed by the blocks of the pins, each block once.
+func writePinsCar(ctx context.Context, w io.Writer, ng ipld.NodeGetter, m *corepins.Manifest, codec multicodec.Code) error {
+	manifest, err := m.Block(codec)
+	if err != nil {
+		return err
+	}
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: []cid.Cid{manifest.Cid()}, Version: 1}, w); err != nil {
+		return err
+	}
+	if err := carutil.LdWrite(w, manifest.Cid().Bytes(), manifest.RawData()); err != nil {
+		return err
+	}
+
+	written, walked := cid.NewSet(), cid.NewSet()
+	for _, p := range m.Pins {
+		stack := []cid.Cid{p.Cid}
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if p.Type == "recursive" && !walked.Visit(c) {
+				continue
+			}
+
+			nd, err := ng.Get(ctx, c)
+			if err != nil {
+				return fmt.Errorf("pin %s: %w", p.Cid, err)
+			}
+			if written.Visit(c) {
+				if err := carutil.LdWrite(w, c.Bytes(), nd.RawData()); err != nil {
+					return err
+				}
+			}
+			if p.Type != "recursive" {
+				continue
+			}
+			links := nd.Links()
+			for i := len(links) - 1; i >= 0; i-- {
+				stack = append(stack, links[i].Cid)
+			}
+		}
+	}
+	return nil
+}
+
+// PinImportOutput is a pin restored by "pin import".
+type PinImportOutput struct {
+	Cid  string
+	Type string
+	Name string `json:",omitempty"`
+}
+
+var importPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Import the pins exported by 'ipfs pin export'.",
+		ShortDescription: `
+Restores the pins of a manifest written by 'ipfs pin export', with their names,
+metadata and expiry, and the blocks of the pins for a CAR.
+`,
+		LongDescription: `
+Restores the pins of a manifest written by 'ipfs pin export', with their names,
+metadata and expiry, and the blocks of the pins for a CAR. The format of the
+file, a dag-json or dag-cbor manifest or a CAR, is detected.
+
+The blocks of a CAR are imported without reaching out to the network. For a
+manifest alone, the blocks missing are fetched, unless --offline is set.
+
+No pin is added unless the blocks of all the pins are present: the recursive
+pins must have all their DAG, and the direct pins their block.
+
+  > ipfs pin import pins.car
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("path", true, false, "The manifest or CAR written by 'ipfs pin export'.").EnableStdin(),
+	},
+	Type: PinImportOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		it := req.Files.Entries()
+		if !it.Next() {
+			if err := it.Err(); err != nil {
+				return err
+			}
+			return errors.New("expected a file to import")
+		}
+		file := files.FileFromEntry(it)
+		if file == nil {
+			return errors.New("expected a file handle")
+		}
+		defer file.Close()
+
+		r := bufio.NewReader(file)
+		isCar, codec, err := detectPinsFormat(r)
+		if err != nil {
+			return err
+		}
+
+		// the lock keeps the blocks imported until they are pinned
+		defer n.Blockstore.PinLock(req.Context).Unlock(req.Context)
+
+		dags := api.Dag()
+		var m *corepins.Manifest
+		if isCar {
+			// like 'dag import', the blocks of a CAR are imported offline
+			var offlineAPI coreiface.CoreAPI
+			if offlineAPI, err = api.WithOptions(options.Api.Offline(true)); err != nil {
+				return err
+			}
+			dags = offlineAPI.Dag()
+			m, err = importPinsCar(req, r, dags)
+		} else {
+			m, err = corepins.DecodeManifest(r, codec)
+		}
+		if err != nil {
+			return err
+		}
+
+		// check that all the blocks are present before adding any pin
+		for _, p := range m.Pins {
+			if p.Type == "recursive" {
+				err = dag.FetchGraph(req.Context, p.Cid, dags)
+			} else {
+				_, err = dags.Get(req.Context, p.Cid)
+			}
+			if err != nil {
+				return fmt.Errorf("pin %s: %w, no pin was imported", p.Cid, err)
+			}
+		}
+
+		if err := restorePins(req.Context, n, m); err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+		for _, p := range m.Pins {
+			if err := res.Emit(&PinImportOutput{Cid: enc.Encode(p.Cid), Type: p.Type, Name: p.Name}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinImportOutput) error {
+			fmt.Fprintf(w, "imported %s %s%s\n", out.Cid, out.Type, formatPinInfo(out.Name, nil))
+			return nil
+		}),
+	},
+}
+
+// detectPinsFormat tells whether the file imported is a CAR or a manifest, and
+// the codec of the manifest. A CAR is recognized by its header, which is tried
+// first as its length can look like the start of a CBOR map; a dag-json
+// manifest may start with a byte order mark and white space.
+func detectPinsFormat(r *bufio.Reader) (isCar bool, codec multicodec.Code, err error) {
+	if isCarHeader(r) {
+		return true, 0, nil
+	}
+
+	if bom, _ := r.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		if _, err := r.Discard(len(bom)); err != nil {
+			return false, 0, err
+		}
+	}
+	for {
+		first, err := r.Peek(1)
+		if err == io.EOF {
+			return false, 0, errors.New("the file imported is empty")
+		} else if err != nil {
+			return false, 0, err
+		}
+		switch {
+		case first[0] == ' ' || first[0] == '\t' || first[0] == '\n' || first[0] == '\r':
+			if _, err := r.Discard(1); err != nil {
+				return false, 0, err
+			}
+		case first[0] == '{':
+			return false, multicodec.DagJson, nil
+		case first[0]>>5 == 5:
+			// a CBOR map
+			return false, multicodec.DagCbor, nil
+		default:
+			return false, 0, errors.New("unrecognized file, expected a dag-json or dag-cbor manifest or a CAR")
+		}
+	}
+}
+
+// isCarHeader tells whether the reader starts with the header of a CARv1 or
+// the pragma of a CARv2, without consuming it.
+func isCarHeader(r *bufio.Reader) bool {
+	prefix, _ := r.Peek(binary.MaxVarintLen64)
+	size, n := binary.Uvarint(prefix)
+	if n <= 0 || size == 0 || size > uint64(r.Size()-n) {
+		return false
+	}
+	header, err := r.Peek(n + int(size))
+	if err != nil {
+		return false
+	}
+	version, err := gocarv2.ReadVersion(bytes.NewReader(header))
+	return err == nil && (version == 1 || version == 2)
+}
+
+// importPinsCar adds the blocks of the CAR written by "pin export" and
+// returns the manifest at its root, which isn't stored.
+func importPinsCar(req *cmds.Request, r io.Reader, dags ipld.DAGService) (*corepins.Manifest, error) {
+	car, err := gocarv2.NewBlockReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(car.Roots) != 1 {
+		return nil, fmt.Errorf("expected the manifest as the only root of the CAR, got %d roots", len(car.Roots))
+	}
+	root := car.Roots[0]
+
+	batch := ipld.NewBatch(req.Context, dags)
+	var m *corepins.Manifest
+	for {
+		block, err := car.Next()
+		if err != nil && err != io.EOF {
+			return nil, err
+		} else if block == nil {
+			break
+		}
+
+		if block.Cid().Equals(root) {
+			m, err = corepins.DecodeManifest(bytes.NewReader(block.RawData()), multicodec.Code(root.Prefix().Codec))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := cmdutils.CheckBlockSize(req, uint64(len(block.RawData()))); err != nil {
+			return nil, err
+		}
+		nd, err := ipld.Decode(block)
+		if err != nil {
+			return nil, err
+		}
+		if err := batch.Add(req.Context, nd); err != nil {
+			return nil, err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("the manifest %s is missing from the CAR", root)
+	}
+	return m, nil
+}
+
+// restorePins adds the pins of the manifest, whose blocks are all present,
+// with their infos. The infos are written first, then the pins; if the pins
+// can't be saved, the changes of the pinner are undone and the infos put back
+// as they were, so a failed import leaves neither pins nor infos.
+func restorePins(ctx context.Context, n *core.IpfsNode, m *corepins.Manifest) error {
+	prevInfos := make([]*corepins.Info, len(m.Pins))
+	for i, p := range m.Pins {
+		if p.Info.IsZero() {
+			continue
+		}
+		prev, err := n.PinStore.Get(ctx, p.Cid)
+		if err != nil {
+			return err
+		}
+		prevInfos[i] = &prev
+	}
+
+	written := 0
+	restoreInfos := func() {
+		// backwards, so the first info saved for a CID listed twice wins
+		for i := written - 1; i >= 0; i-- {
+			if prevInfos[i] == nil {
+				continue
+			}
+			if err := n.PinStore.Put(ctx, m.Pins[i].Cid, *prevInfos[i]); err != nil {
+				log.Errorf("restoring the info of pin %s: %s", m.Pins[i].Cid, err)
+			}
+		}
+	}
+	for i, p := range m.Pins {
+		if prevInfos[i] != nil {
+			if err := n.PinStore.Put(ctx, p.Cid, p.Info); err != nil {
+				restoreInfos()
+				return err
+			}
+		}
+		written = i + 1
+	}
+
+	// the changes of the pinner, to undo them if the pins can't be saved
+	type change struct {
+		c     cid.Cid
+		mode  pin.Mode
+		added bool
+	}
+	var changes []change
+	undo := func() {
+		for i := len(changes) - 1; i >= 0; i-- {
+			ch := changes[i]
+			if ch.added {
+				n.Pinning.RemovePinWithMode(ch.c, ch.mode)
+			} else {
+				n.Pinning.PinWithMode(ch.c, ch.mode)
+			}
+		}
+		restoreInfos()
+	}
+	isPinned := func(c cid.Cid, mode pin.Mode) (bool, error) {
+		_, pinned, err := n.Pinning.IsPinnedWithType(ctx, c, mode)
+		return pinned, err
+	}
+	for _, p := range m.Pins {
+		recursive, err := isPinned(p.Cid, pin.Recursive)
+		if err != nil {
+			undo()
+			return err
+		}
+		direct, err := isPinned(p.Cid, pin.Direct)
+		if err != nil {
+			undo()
+			return err
+		}
+		if p.Type == "recursive" {
+			if !recursive {
+				n.Pinning.PinWithMode(p.Cid, pin.Recursive)
+				changes = append(changes, change{p.Cid, pin.Recursive, true})
+			}
+			if direct {
+				n.Pinning.RemovePinWithMode(p.Cid, pin.Direct)
+				changes = append(changes, change{p.Cid, pin.Direct, false})
+			}
+		} else if !recursive && !direct {
+			// a recursive pin covers the block already
+			n.Pinning.PinWithMode(p.Cid, pin.Direct)
+			changes = append(changes, change{p.Cid, pin.Direct, true})
+		}
+	}
+	if err := n.Pinning.Flush(ctx); err != nil {
+		undo()
+		return err
+	}
+
+	// the pins are saved, failing to announce them doesn't undo the import
+	for _, p := range m.Pins {
+		if err := n.Provider.Provide(p.Cid); err != nil {
+			log.Warnf("providing pin %s: %s", p.Cid, err)
+		}
+	}
+	return nil
+}
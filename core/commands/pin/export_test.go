@@ -0,0 +1,137 @@
+package pin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/corepins"
+
+	cid "github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	dag "github.com/ipfs/go-merkledag"
+	gocar "github.com/ipld/go-car"
+	gocarv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multicodec"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// failingPinner fails to save the pins.
+type failingPinner struct {
+	pin.Pinner
+}
+
+func (failingPinner) Flush(context.Context) error {
+	return errors.New("flush failed")
+}
+
+func TestRestorePinsUndo(t *testing.T) {
+	ctx := context.Background()
+	n, err := core.NewNode(ctx, &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	direct := dag.NodeWithData([]byte("direct")).Cid()
+	other := dag.NodeWithData([]byte("other")).Cid()
+	n.Pinning.PinWithMode(direct, pin.Direct)
+	if err := n.PinStore.Put(ctx, direct, corepins.Info{Name: "before"}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &corepins.Manifest{Pins: []corepins.ManifestPin{
+		{Cid: direct, Type: "recursive", Info: corepins.Info{Name: "imported"}},
+		{Cid: other, Type: "direct", Info: corepins.Info{Name: "other"}},
+	}}
+	n.Pinning = failingPinner{n.Pinning}
+	if err := restorePins(ctx, n, m); err == nil {
+		t.Fatal("expected the import to fail")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		c      cid.Cid
+		mode   pin.Mode
+		pinned bool
+		info   string
+	}{
+		{"direct", direct, pin.Recursive, false, "before"},
+		{"direct", direct, pin.Direct, true, "before"},
+		{"other", other, pin.Direct, false, ""},
+	} {
+		_, pinned, err := n.Pinning.IsPinnedWithType(ctx, tc.c, tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pinned != tc.pinned {
+			mode, _ := pin.ModeToString(tc.mode)
+			t.Errorf("%s pinned %s: expected %t, got %t", tc.name, mode, tc.pinned, pinned)
+		}
+		info, err := n.PinStore.Get(ctx, tc.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name != tc.info {
+			t.Errorf("%s: expected the name %q, got %q", tc.name, tc.info, info.Name)
+		}
+	}
+}
+
+func TestDetectPinsFormat(t *testing.T) {
+	m := &corepins.Manifest{Pins: []corepins.ManifestPin{
+		{Cid: dag.NodeWithData([]byte("a")).Cid(), Type: "recursive"},
+	}}
+	var jsonManifest, cborManifest bytes.Buffer
+	if err := m.Encode(&jsonManifest, multicodec.DagJson); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Encode(&cborManifest, multicodec.DagCbor); err != nil {
+		t.Fatal(err)
+	}
+
+	// a CARv1 whose header length starts like a CBOR map
+	var carv1 bytes.Buffer
+	for i := 0; carv1.Len() == 0 || carv1.Bytes()[0]>>5 != 5; i++ {
+		root, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.IDENTITY, MhLength: -1}.Sum(make([]byte, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		carv1.Reset()
+		if err := gocar.WriteHeader(&gocar.CarHeader{Roots: []cid.Cid{root}, Version: 1}, &carv1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		data  string
+		isCar bool
+		codec multicodec.Code
+	}{
+		{"dag-json", jsonManifest.String(), false, multicodec.DagJson},
+		{"dag-json with a BOM and spaces", "\xef\xbb\xbf \n\t" + jsonManifest.String(), false, multicodec.DagJson},
+		{"dag-cbor", cborManifest.String(), false, multicodec.DagCbor},
+		{"CARv1", carv1.String(), true, 0},
+		{"CARv2", string(gocarv2.Pragma), true, 0},
+	} {
+		isCar, codec, err := detectPinsFormat(bufio.NewReader(strings.NewReader(tc.data)))
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if isCar != tc.isCar || codec != tc.codec {
+			t.Errorf("%s: expected %t %s, got %t %s", tc.name, tc.isCar, tc.codec, isCar, codec)
+		}
+	}
+
+	for _, data := range []string{"", " \n", "[]", "\x00"} {
+		if _, _, err := detectPinsFormat(bufio.NewReader(strings.NewReader(data))); err == nil {
+			t.Errorf("expected %q to be rejected", data)
+		}
+	}
+}
@@ -8,11 +8,13 @@ import (
 	"os"
 	"time"
 
+	humanize "github.com/dustin/go-humanize"
 	bserv "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
 	cidenc "github.com/ipfs/go-cidutil/cidenc"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
 	dag "github.com/ipfs/go-merkledag"
 	verifcid "github.com/ipfs/go-verifcid"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
@@ -22,6 +24,7 @@ import (
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	"github.com/ipfs/go-ipfs/core/corepins"
 )
 
 var PinCmd = &cmds.Command{
@@ -35,6 +38,9 @@ var PinCmd = &cmds.Command{
 		"ls":     listPinCmd,
 		"verify": verifyPinCmd,
 		"update": updatePinCmd,
+		"status": statusPinCmd,
+		"export": exportPinCmd,
+		"import": importPinCmd,
 		"remote": remotePinCmd,
 	},
 }
@@ -51,12 +57,37 @@ type AddPinOutput struct {
 const (
 	pinRecursiveOptionName = "recursive"
 	pinProgressOptionName  = "progress"
+	pinMetadataOptionName  = "metadata"
+	pinExpiresInOptionName = "expires-in"
 )
 
 var addPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Pin objects to local storage.",
 		ShortDescription: "Stores an IPFS object(s) from a given path locally to disk.",
+		LongDescription: `
+Stores an IPFS object(s) from a given path locally to disk.
+
+The pins can be given a name and key/value metadata, shown by 'ipfs pin ls'
+and kept by 'ipfs pin update'.
+
+  > ipfs pin add --name=website --metadata=build=1234 QmFoo
+
+With --expires-in, the pins are removed by the daemon once the duration has
+passed, and their blocks are then collected by the garbage collection as
+usual. 'ipfs pin ls' shows the remaining lifetime of the pins.
+
+  > ipfs pin add --name=preview --expires-in=72h QmFoo
+
+Pinning an object again with --name, --metadata or --expires-in replaces its
+name, metadata and expiry.
+
+The progress of the recursive pins is saved while their blocks are fetched:
+when the daemon stops before they are pinned, it resumes the pins where they
+stopped when it starts again. Interrupting the command abandons the pin, and
+the blocks fetched are kept until the next garbage collection. 'ipfs pin
+status' shows the progress of the pins, and 'ipfs pin rm' stops them.
+`,
 	},
 
 	Arguments: []cmds.Argument{
@@ -65,6 +96,9 @@ var addPinCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmds.BoolOption(pinProgressOptionName, "Show progress"),
+		cmds.StringOption(pinNameOptionName, "An optional name for the pin(s)."),
+		cmds.StringsOption(pinMetadataOptionName, "Metadata of the pin(s), as key=value. Can be repeated."),
+		cmds.StringOption(pinExpiresInOptionName, "Remove the pin(s) after this duration, like 72h."),
 	},
 	Type: AddPinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -77,6 +111,32 @@ var addPinCmd = &cmds.Command{
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
 		showProgress, _ := req.Options[pinProgressOptionName].(bool)
 
+		opts := []options.PinAddOption{options.Pin.Recursive(recursive)}
+		// the info of the pin is passed to the PinAPI of the local node in
+		// the context, its options can't pass it
+		var add corepins.AddSettings
+		info := new(corepins.Info)
+		if name, ok := req.Options[pinNameOptionName].(string); ok {
+			info.Name = name
+			add.Info = info
+		}
+		if entries, ok := req.Options[pinMetadataOptionName].([]string); ok {
+			if info.Metadata, err = corepins.ParseMetadata(entries); err != nil {
+				return err
+			}
+			add.Info = info
+		}
+		if expiresIn, ok := req.Options[pinExpiresInOptionName].(string); ok {
+			d, err := time.ParseDuration(expiresIn)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", pinExpiresInOptionName, err)
+			}
+			if err := info.SetExpiresIn(d); err != nil {
+				return err
+			}
+			add.Info = info
+		}
+
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
 		}
@@ -87,7 +147,7 @@ var addPinCmd = &cmds.Command{
 		}
 
 		if !showProgress {
-			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(corepins.WithAddSettings(req.Context, add), api, enc, req.Arguments, opts)
 			if err != nil {
 				return err
 			}
@@ -96,7 +156,8 @@ var addPinCmd = &cmds.Command{
 		}
 
 		v := new(dag.ProgressTracker)
-		ctx := v.DeriveContext(req.Context)
+		add.Progress = v
+		ctx := corepins.WithAddSettings(req.Context, add)
 
 		type pinResult struct {
 			pins []string
@@ -105,7 +166,7 @@ var addPinCmd = &cmds.Command{
 
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(ctx, api, enc, req.Arguments, opts)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -181,7 +242,7 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
-func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool) ([]string, error) {
+func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, opts []options.PinAddOption) ([]string, error) {
 	added := make([]string, len(paths))
 	for i, b := range paths {
 		rp, err := api.ResolvePath(ctx, path.New(b))
@@ -189,7 +250,7 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 			return nil, err
 		}
 
-		if err := api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
+		if err := api.Pin().Add(ctx, rp, opts...); err != nil {
 			return nil, err
 		}
 		added[i] = enc.Encode(rp.Cid())
@@ -212,6 +273,8 @@ collected if needed. (By default, recursively. Use -r=false for direct pins.)
 A pin may not be removed because the specified object is not pinned or pinned
 indirectly. To determine if the object is pinned indirectly, use the command:
 ipfs pin ls -t indirect <cid>
+
+Removing a recursive pin still being added stops the fetch of its blocks.
 `,
 	},
 
@@ -300,6 +363,11 @@ With arguments, the command fails if any of the arguments is not a pinned
 object. And if --type=<type> is additionally used, the command will also fail
 if any of the arguments is not of the specified type.
 
+The names of the pins given by 'ipfs pin add --name' follow their type, and
+--name=<name> lists only the pins with that name. The remaining lifetime of
+the pins added with --expires-in follows. Their metadata and expiry time are
+part of the JSON output.
+
 Example:
 	$ echo "hello" | ipfs add -q
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
@@ -314,6 +382,11 @@ Example:
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN direct
 	$ ipfs pin ls QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN direct
+	# name the pin
+	$ ipfs pin add -r=false --name=hello QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
+	pinned QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN directly
+	$ ipfs pin ls --name=hello
+	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN direct hello
 `,
 	},
 
@@ -324,15 +397,21 @@ Example:
 		cmds.StringOption(pinTypeOptionName, "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\".").WithDefault("all"),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of objects."),
 		cmds.BoolOption(pinStreamOptionName, "s", "Enable streaming of pins as they are discovered."),
+		cmds.StringOption(pinNameOptionName, "Return only the pins with this name (case-sensitive, exact match)."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
 			return err
 		}
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
 
 		typeStr, _ := req.Options[pinTypeOptionName].(string)
 		stream, _ := req.Options[pinStreamOptionName].(bool)
+		name, nameFound := req.Options[pinNameOptionName].(string)
 
 		switch typeStr {
 		case "all", "direct", "indirect", "recursive":
@@ -341,21 +420,40 @@ Example:
 			return err
 		}
 
+		infos, err := n.PinStore.All(req.Context)
+		if err != nil {
+			return err
+		}
+
 		// For backward compatibility, we accumulate the pins in the same output type as before.
 		emit := res.Emit
 		lgcList := map[string]PinLsType{}
 		if !stream {
 			emit = func(v interface{}) error {
 				obj := v.(*PinLsOutputWrapper)
-				lgcList[obj.PinLsObject.Cid] = PinLsType{Type: obj.PinLsObject.Type}
+				lgcList[obj.PinLsObject.Cid] = PinLsType{
+					Type:     obj.PinLsObject.Type,
+					Name:     obj.PinLsObject.Name,
+					Metadata: obj.PinLsObject.Metadata,
+					Expires:  obj.PinLsObject.Expires,
+				}
 				return nil
 			}
 		}
+		if nameFound {
+			emitPin := emit
+			emit = func(v interface{}) error {
+				if v.(*PinLsOutputWrapper).PinLsObject.Name != name {
+					return nil
+				}
+				return emitPin(v)
+			}
+		}
 
 		if len(req.Arguments) > 0 {
-			err = pinLsKeys(req, typeStr, api, emit)
+			err = pinLsKeys(req, typeStr, api, infos, emit)
 		} else {
-			err = pinLsAll(req, typeStr, api, emit)
+			err = pinLsAll(req, typeStr, api, infos, emit)
 		}
 		if err != nil {
 			return err
@@ -390,7 +488,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", out.PinLsObject.Cid)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type)
+					fmt.Fprintf(w, "%s %s%s\n", out.PinLsObject.Cid, out.PinLsObject.Type, formatPinInfo(out.PinLsObject.Name, out.PinLsObject.Expires))
 				}
 				return nil
 			}
@@ -399,7 +497,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", k)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", k, v.Type)
+					fmt.Fprintf(w, "%s %s%s\n", k, v.Type, formatPinInfo(v.Name, v.Expires))
 				}
 			}
 
@@ -421,18 +519,42 @@ type PinLsList struct {
 	Keys map[string]PinLsType
 }
 
-// PinLsType contains the type of a pin
+// PinLsType contains the type of a pin, and its name, metadata and expiry
+// if any
 type PinLsType struct {
-	Type string
+	Type     string
+	Name     string            `json:",omitempty"`
+	Metadata map[string]string `json:",omitempty"`
+	Expires  *time.Time        `json:",omitempty"`
 }
 
 // PinLsObject contains the description of a pin
 type PinLsObject struct {
-	Cid  string `json:",omitempty"`
-	Type string `json:",omitempty"`
+	Cid      string            `json:",omitempty"`
+	Type     string            `json:",omitempty"`
+	Name     string            `json:",omitempty"`
+	Metadata map[string]string `json:",omitempty"`
+	Expires  *time.Time        `json:",omitempty"`
 }
 
-func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+// formatPinInfo formats the name and remaining lifetime of a pin, following
+// its type.
+func formatPinInfo(name string, expires *time.Time) string {
+	var s string
+	if name != "" {
+		s += " " + name
+	}
+	if expires != nil {
+		if left := time.Until(*expires).Round(time.Second); left > 0 {
+			s += fmt.Sprintf(" (expires in %s)", left)
+		} else {
+			s += " (expired)"
+		}
+	}
+	return s
+}
+
+func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, infos map[cid.Cid]corepins.Info, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -470,10 +592,14 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 			pinType = "indirect through " + pinType
 		}
 
+		info := infos[rp.Cid()]
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: pinType,
-				Cid:  enc.Encode(rp.Cid()),
+				Type:     pinType,
+				Cid:      enc.Encode(rp.Cid()),
+				Name:     info.Name,
+				Metadata: info.Metadata,
+				Expires:  info.Expires,
 			},
 		})
 		if err != nil {
@@ -484,7 +610,7 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 	return nil
 }
 
-func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, infos map[cid.Cid]corepins.Info, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -511,10 +637,14 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		if err := p.Err(); err != nil {
 			return err
 		}
+		info := infos[p.Path().Cid()]
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: p.Type(),
-				Cid:  enc.Encode(p.Path().Cid()),
+				Type:     p.Type(),
+				Cid:      enc.Encode(p.Path().Cid()),
+				Name:     info.Name,
+				Metadata: info.Metadata,
+				Expires:  info.Expires,
 			},
 		})
 		if err != nil {
@@ -540,7 +670,7 @@ This command is useful when the new pin contains many similarities or is a
 derivative of an existing one, particularly for large objects. This allows a more
 efficient DAG-traversal which fully skips already-pinned branches from the old
 object. As a requirement, the old object needs to be an existing recursive
-pin.
+pin. The name, metadata and expiry of the old pin are given to the new one.
 `,
 	},
 
@@ -590,6 +720,163 @@ pin.
 	},
 }
 
+// pinStatusMissingShown is how many of the subtrees missing "pin status"
+// lists in text.
+const pinStatusMissingShown = 10
+
+// PinStatusOutput is the status of a recursive pin returned by "pin status".
+type PinStatusOutput struct {
+	Cid string
+	// Status is "pinned", "fetching" or "interrupted".
+	Status  string
+	Started *time.Time `json:",omitempty"`
+	Blocks  uint64     `json:",omitempty"`
+	Bytes   uint64     `json:",omitempty"`
+	// Missing are the roots of the subtrees not fetched yet.
+	Missing []string `json:",omitempty"`
+	// Providers are the peers which sent blocks since the fetch started.
+	Providers []string `json:",omitempty"`
+}
+
+var statusPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the progress of recursive pins.",
+		ShortDescription: `
+Shows whether recursive pins are pinned, still fetching their blocks, or
+interrupted, and how far the fetch of their blocks went. Without arguments,
+the pins being fetched or interrupted are shown.
+`,
+		LongDescription: `
+Shows whether recursive pins are pinned, still fetching their blocks, or
+interrupted, and how far the fetch of their blocks went. Without arguments,
+the pins being fetched or interrupted are shown.
+
+For the pins not pinned yet, the status has the blocks and bytes fetched, the
+roots of the subtrees not fetched yet, and the peers which sent blocks to the
+node since the fetch started. The pins interrupted by the daemon stopping are
+resumed when it starts again.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", false, true, "Path to object(s) to show the status of."),
+	},
+	Type: PinStatusOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		if len(req.Arguments) == 0 {
+			fetches, err := n.PinFetches.Pending(req.Context)
+			if err != nil {
+				return err
+			}
+			for _, f := range fetches {
+				status, err := n.PinFetches.Status(req.Context, f.Cid)
+				if err != nil {
+					return err
+				}
+				if status == nil {
+					// done since listed
+					continue
+				}
+				if err := res.Emit(pinStatusOutput(enc, status)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		for _, b := range req.Arguments {
+			rp, err := api.ResolvePath(req.Context, path.New(b))
+			if err != nil {
+				return err
+			}
+			c := rp.Cid()
+
+			status, err := n.PinFetches.Status(req.Context, c)
+			if err != nil {
+				return err
+			}
+			if status == nil || !status.Running {
+				_, pinned, err := n.Pinning.IsPinnedWithType(req.Context, c, pin.Recursive)
+				if err != nil {
+					return err
+				}
+				if pinned {
+					if err := res.Emit(&PinStatusOutput{Cid: enc.Encode(c), Status: "pinned"}); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if status == nil {
+				return fmt.Errorf("%s is not pinned recursively", enc.Encode(c))
+			}
+			if err := res.Emit(pinStatusOutput(enc, status)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinStatusOutput) error {
+			fmt.Fprintf(w, "%s %s\n", out.Cid, out.Status)
+			if out.Started == nil {
+				return nil
+			}
+			fmt.Fprintf(w, "  started:   %s\n", out.Started.Local().Format(time.RFC3339))
+			fmt.Fprintf(w, "  fetched:   %d blocks, %s\n", out.Blocks, humanize.Bytes(out.Bytes))
+			fmt.Fprintf(w, "  missing:   %d subtrees\n", len(out.Missing))
+			for i, c := range out.Missing {
+				if i == pinStatusMissingShown {
+					fmt.Fprintf(w, "    ... (--enc=json lists them all)\n")
+					break
+				}
+				fmt.Fprintf(w, "    %s\n", c)
+			}
+			if out.Status == "fetching" {
+				fmt.Fprintf(w, "  providers: %d peers\n", len(out.Providers))
+				for _, p := range out.Providers {
+					fmt.Fprintf(w, "    %s\n", p)
+				}
+			}
+			return nil
+		}),
+	},
+}
+
+func pinStatusOutput(enc cidenc.Encoder, status *corepins.FetchStatus) *PinStatusOutput {
+	out := &PinStatusOutput{
+		Cid:     enc.Encode(status.Cid),
+		Status:  "interrupted",
+		Started: &status.Started,
+		Blocks:  status.Blocks,
+		Bytes:   status.Bytes,
+	}
+	if status.Running {
+		out.Status = "fetching"
+	}
+	for _, c := range status.Frontier {
+		out.Missing = append(out.Missing, enc.Encode(c))
+	}
+	for _, p := range status.Providers {
+		out.Providers = append(out.Providers, p.String())
+	}
+	return out
+}
+
 const (
 	pinVerboseOptionName = "verbose"
 )
@@ -19,17 +19,18 @@ var log = logging.Logger("core/commands")
 var ErrNotOnline = errors.New("this command must be run in online mode. Try running 'ipfs daemon' first")
 
 const (
-	ConfigOption  = "config"
-	DebugOption   = "debug"
-	LocalOption   = "local" // DEPRECATED: use OfflineOption
-	OfflineOption = "offline"
-	ApiOption     = "api"
+	ConfigOption   = "config"
+	DebugOption    = "debug"
+	LocalOption    = "local" // DEPRECATED: use OfflineOption
+	OfflineOption  = "offline"
+	ApiOption      = "api"
+	ApiTokenOption = "api-token"
 )
 
 var Root = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:  "Global p2p merkle-dag filesystem.",
-		Synopsis: "ipfs [--config=<config> | -c] [--debug | -D] [--help] [-h] [--api=<api>] [--offline] [--cid-base=<base>] [--upgrade-cidv0-in-output] [--encoding=<encoding> | --enc] [--timeout=<timeout>] <command> ...",
+		Synopsis: "ipfs [--config=<config> | -c] [--debug | -D] [--help] [-h] [--api=<api>] [--api-token=<token>] [--offline] [--cid-base=<base>] [--upgrade-cidv0-in-output] [--encoding=<encoding> | --enc] [--timeout=<timeout>] <command> ...",
 		Subcommands: `
 BASIC COMMANDS
   init          Initialize local IPFS configuration
@@ -57,6 +58,8 @@ ADVANCED COMMANDS
   dns           Resolve DNS links
   pin           Pin objects to local storage
   repo          Manipulate the IPFS repository
+  jobs          Run long commands detached from the requests
+  events        Watch the events of the node
   stats         Various operational stats
   p2p           Libp2p stream mounting
   filestore     Manage the filestore (experimental)
@@ -72,6 +75,7 @@ NETWORK COMMANDS
 
 TOOL COMMANDS
   config        Manage configuration
+  api           Manage the access to the RPC API
   version       Show IPFS version information
   diag          Generate diagnostic reports
   update        Download and apply go-ipfs updates
@@ -102,6 +106,7 @@ The CLI will exit with one of the following values:
 		cmds.BoolOption(LocalOption, "L", "Run the command locally, instead of using the daemon. DEPRECATED: use --offline."),
 		cmds.BoolOption(OfflineOption, "Run the command offline."),
 		cmds.StringOption(ApiOption, "Use a specific API instance (defaults to /ip4/127.0.0.1/tcp/5001)"),
+		cmds.StringOption(ApiTokenOption, "Bearer token to authenticate to the API with (defaults to $IPFS_API_TOKEN)"),
 
 		// global options, added to every command
 		cmdenv.OptionCidBase,
@@ -118,6 +123,7 @@ var CommandsDaemonCmd = CommandsCmd(Root)
 
 var rootSubcommands = map[string]*cmds.Command{
 	"add":       AddCmd,
+	"api":       APICmd,
 	"bitswap":   BitswapCmd,
 	"block":     BlockCmd,
 	"cat":       CatCmd,
@@ -134,7 +140,9 @@ var rootSubcommands = map[string]*cmds.Command{
 	"dht":       DhtCmd,
 	"diag":      DiagCmd,
 	"dns":       DNSCmd,
+	"events":    EventsCmd,
 	"id":        IDCmd,
+	"jobs":      JobsCmd,
 	"key":       KeyCmd,
 	"log":       LogCmd,
 	"ls":        LsCmd,
@@ -44,6 +44,9 @@ import (
 	madns "github.com/multiformats/go-multiaddr-dns"
 
 	"github.com/ipfs/go-ipfs/core/bootstrap"
+	"github.com/ipfs/go-ipfs/core/coreevents"
+	"github.com/ipfs/go-ipfs/core/corejobs"
+	"github.com/ipfs/go-ipfs/core/corepins"
 	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
 	"github.com/ipfs/go-ipfs/fuse/mount"
@@ -66,6 +69,8 @@ type IpfsNode struct {
 
 	// Local node
 	Pinning         pin.Pinner             // the pinning manager
+	PinStore        *corepins.Store        // the names and metadata of the pins
+	PinFetches      *corepins.Fetcher      // the fetches of the recursive pins
 	Mounts          Mounts                 `optional:"true"` // current mount state, if any.
 	PrivateKey      ic.PrivKey             `optional:"true"` // the local node's private Key
 	PNetFingerprint libp2p.PNetFingerprint `optional:"true"` // fingerprint of private network
@@ -84,6 +89,7 @@ type IpfsNode struct {
 	Discovery            mdns.Service              `optional:"true"`
 	FilesRoot            *mfs.Root
 	RecordValidator      record.Validator
+	Events               *coreevents.Bus // the events streamed by `ipfs events subscribe`
 
 	// Online
 	PeerHost        p2phost.Host            `optional:"true"` // the network host (server+client)
@@ -107,6 +113,10 @@ type IpfsNode struct {
 
 	P2P *p2p.P2P `optional:"true"`
 
+	// Jobs runs the commands submitted with `ipfs jobs`. It's last, so that
+	// the running jobs are stopped before the services they use.
+	Jobs *corejobs.Manager
+
 	Process goprocess.Process
 	ctx     context.Context
 
@@ -40,6 +40,7 @@ import (
 	madns "github.com/multiformats/go-multiaddr-dns"
 
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/corepins"
 	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-namesys"
@@ -55,6 +56,8 @@ type CoreAPI struct {
 	blockstore blockstore.GCBlockstore
 	baseBlocks blockstore.Blockstore
 	pinning    pin.Pinner
+	pins       *corepins.Store
+	pinFetches *corepins.Fetcher
 
 	blocks               bserv.BlockService
 	dag                  ipld.DAGService
@@ -168,6 +171,8 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		blockstore: n.Blockstore,
 		baseBlocks: n.BaseBlocks,
 		pinning:    n.Pinning,
+		pins:       n.PinStore,
+		pinFetches: n.PinFetches,
 
 		blocks:               n.Blocks,
 		dag:                  n.DAG,
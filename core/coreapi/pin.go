@@ -8,7 +8,9 @@ import (
 	"github.com/ipfs/go-cid"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs/core/corepins"
 	"github.com/ipfs/go-ipfs/tracing"
+	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	caopts "github.com/ipfs/interface-go-ipfs-core/options"
@@ -32,21 +34,66 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 	if err != nil {
 		return err
 	}
+	// the settings of corepins set the info of the pin and track its progress
+	add := corepins.AddSettingsFromContext(ctx)
 
 	span.SetAttributes(attribute.Bool("recursive", settings.Recursive))
 
 	defer api.blockstore.PinLock(ctx).Unlock(ctx)
 
-	err = api.pinning.Pin(ctx, dagNode, settings.Recursive)
+	if settings.Recursive {
+		err = api.pinRecursive(ctx, dagNode, add)
+	} else {
+		err = api.pinning.Pin(ctx, dagNode, false)
+	}
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
 	}
 
+	if add.Info != nil {
+		if err := api.pins.Put(ctx, dagNode.Cid(), *add.Info); err != nil {
+			return fmt.Errorf("pin: %s", err)
+		}
+	}
+
 	if err := api.provider.Provide(dagNode.Cid()); err != nil {
 		return err
 	}
 
-	return api.pinning.Flush(ctx)
+	if err := api.pinning.Flush(ctx); err != nil {
+		return err
+	}
+
+	if settings.Recursive {
+		return api.pinFetches.Done(ctx, dagNode.Cid())
+	}
+	return nil
+}
+
+// pinRecursive fetches the DAG of the node with the fetcher of the pins, which
+// saves its progress to resume it after a restart, and pins it.
+func (api *PinAPI) pinRecursive(ctx context.Context, nd ipld.Node, add corepins.AddSettings) error {
+	c := nd.Cid()
+	_, pinned, err := api.pinning.IsPinnedWithType(ctx, c, pin.Recursive)
+	if err != nil || pinned {
+		return err
+	}
+
+	err = api.pinFetches.Fetch(ctx, c, merkledag.NewSession(ctx, api.dag), add.Info, add.Progress)
+	if err != nil {
+		return err
+	}
+
+	// the DAG is local and synced: pin it without walking it again
+	api.pinning.PinWithMode(c, pin.Recursive)
+	_, direct, err := api.pinning.IsPinnedWithType(ctx, c, pin.Direct)
+	if err != nil {
+		return err
+	}
+	if direct {
+		api.pinning.RemovePinWithMode(c, pin.Direct)
+	}
+	return nil
 }
 
 func (api *PinAPI) Ls(ctx context.Context, opts ...caopts.PinLsOption) (<-chan coreiface.Pin, error) {
@@ -110,11 +157,23 @@ func (api *PinAPI) Rm(ctx context.Context, p path.Path, opts ...caopts.PinRmOpti
 
 	span.SetAttributes(attribute.Bool("recursive", settings.Recursive))
 
+	// removing a recursive pin being added stops its fetch, before taking
+	// the lock held by the fetch
+	var fetching bool
+	if settings.Recursive {
+		if fetching, err = api.pinFetches.Remove(ctx, rp.Cid()); err != nil {
+			return err
+		}
+	}
+
 	// Note: after unpin the pin sets are flushed to the blockstore, so we need
 	// to take a lock to prevent a concurrent garbage collection
 	defer api.blockstore.PinLock(ctx).Unlock(ctx)
 
 	if err = api.pinning.Unpin(ctx, rp.Cid(), settings.Recursive); err != nil {
+		if err == pin.ErrNotPinned && fetching {
+			return nil
+		}
 		return err
 	}
 
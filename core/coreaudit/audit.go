@@ -0,0 +1,302 @@
+// Package coreaudit implements the append-only log of the API calls changing
+// the node, rotated by size.
+package coreaudit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	config "github.com/ipfs/go-ipfs/config"
+)
+
+const (
+	DefaultPath     = "audit.log"
+	DefaultMaxSize  = "100MB"
+	DefaultMaxFiles = 5
+)
+
+// DefaultCommands are the commands logged by default: the commands changing
+// the node, and exporting keys.
+var DefaultCommands = []string{
+	"add",
+	"api/token/create",
+	"api/token/rm",
+	"block/put",
+	"block/rm",
+	"bootstrap/add/*",
+	"bootstrap/rm/*",
+	"config",
+	"config/profile/apply",
+	"config/replace",
+	"dag/import",
+	"dag/put",
+	"dht/put",
+	"diag/cmds/clear",
+	"diag/cmds/set-time",
+	"files/chcid",
+	"files/cp",
+	"files/mkdir",
+	"files/mv",
+	"files/rm",
+	"files/write",
+	"jobs/cancel",
+	"jobs/submit",
+	"key/export",
+	"key/gen",
+	"key/import",
+	"key/rename",
+	"key/rm",
+	"key/rotate",
+	"log/level",
+	"name/publish",
+	"name/pubsub/cancel",
+	"object/new",
+	"object/patch/*",
+	"object/put",
+	"p2p/close",
+	"p2p/forward",
+	"p2p/listen",
+	"p2p/stream/close",
+	"pin/add",
+	"pin/import",
+	"pin/remote/add",
+	"pin/remote/rm",
+	"pin/remote/service/add",
+	"pin/remote/service/rm",
+	"pin/rm",
+	"pin/update",
+	"pubsub/pub",
+	"repo/gc",
+	"shutdown",
+	"swarm/connect",
+	"swarm/disconnect",
+	"swarm/filters/add",
+	"swarm/filters/rm",
+	"swarm/limit",
+	"swarm/peering/add",
+	"swarm/peering/rm",
+	"tar/add",
+	"urlstore/add",
+}
+
+// Redacted replaces the concealed arguments in the log.
+const Redacted = "<redacted>"
+
+// ConcealSelectors select the config keys whose values are concealed in the
+// log, like config.PinningConcealSelector.
+var ConcealSelectors = [][]string{
+	config.PinningConcealSelector,
+	{"Identity", "PrivKey"},
+	{"API", "Tokens"},
+	{"S3", "AccessKeys"},
+	{"WebDAV", "Users"},
+}
+
+// concealedArguments maps commands to the index of their first secret
+// argument.
+var concealedArguments = map[string]int{
+	"pin/remote/service/add": 2,
+}
+
+// Entry is an API call in the log.
+type Entry struct {
+	Time       time.Time
+	RemoteAddr string
+	// Token is the name of the API token of the call, if any.
+	Token     string `json:",omitempty"`
+	Command   string
+	Arguments []string            `json:",omitempty"`
+	Options   map[string][]string `json:",omitempty"`
+	// Status is the HTTP status of the response, and Error the error of
+	// the command, if any.
+	Status int
+	Error  string `json:",omitempty"`
+}
+
+// Conceal redacts the secrets among the arguments of the command.
+func Conceal(cmdPath string, args []string) []string {
+	first := len(args)
+	if i, ok := concealedArguments[cmdPath]; ok && i < first {
+		first = i
+	}
+	// `ipfs config <key> <value>`
+	if cmdPath == "config" && len(args) > 1 {
+		for _, selector := range ConcealSelectors {
+			if matchesSelector(args[0], selector) {
+				first = 1
+				break
+			}
+		}
+	}
+	if first == len(args) {
+		return args
+	}
+	concealed := append([]string(nil), args[:first]...)
+	for range args[first:] {
+		concealed = append(concealed, Redacted)
+	}
+	return concealed
+}
+
+// matchesSelector returns whether the config key, like "Identity.PrivKey",
+// is or contains the keys selected.
+func matchesSelector(key string, selector []string) bool {
+	k := strings.Split(key, ".")
+	for i, s := range selector {
+		if i >= len(k) {
+			break
+		}
+		if s != "*" && !strings.EqualFold(k[i], s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Log is an audit log, rotated when it grows over MaxSize: the previous logs
+// are renamed with the suffixes ".1" (the newest) to ".<MaxFiles>".
+type Log struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+
+	// Commands are the patterns of the commands logged.
+	Commands []string
+
+	mu sync.Mutex
+}
+
+// Open returns the audit log configured, or nil if it is disabled. Relative
+// paths are relative to the repo.
+func Open(repoPath string, cfg config.APIAuditLog) (*Log, error) {
+	if !cfg.Enabled.WithDefault(false) {
+		return nil, nil
+	}
+	path := cfg.Path.WithDefault(DefaultPath)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repoPath, path)
+	}
+	maxSize, err := humanize.ParseBytes(cfg.MaxSize.WithDefault(DefaultMaxSize))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API.AuditLog.MaxSize: %w", err)
+	}
+	commands := cfg.Commands
+	if commands == nil {
+		commands = DefaultCommands
+	}
+	return &Log{
+		Path:     path,
+		MaxSize:  int64(maxSize),
+		MaxFiles: int(cfg.MaxFiles.WithDefault(DefaultMaxFiles)),
+		Commands: commands,
+	}, nil
+}
+
+// Logs returns whether the calls to the command are logged.
+func (l *Log) Logs(cmdPath string) bool {
+	return config.MatchCommand(l.Commands, cmdPath)
+}
+
+// Write appends the entry to the log, rotating it first if it would grow
+// over MaxSize.
+func (l *Log) Write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if fi, err := os.Stat(l.Path); err == nil && fi.Size() > 0 && fi.Size()+int64(len(data)) > l.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (l *Log) rotate() error {
+	if l.MaxFiles < 1 {
+		return os.Remove(l.Path)
+	}
+	for i := l.MaxFiles - 1; i >= 1; i-- {
+		err := os.Rename(rotatedPath(l.Path, i), rotatedPath(l.Path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.Path, rotatedPath(l.Path, 1))
+}
+
+func rotatedPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// Read passes the entries of the log at the given path to emit, oldest
+// first, starting with the oldest rotated log.
+func Read(path string, emit func(Entry) error) error {
+	var paths []string
+	for i := 1; ; i++ {
+		p := rotatedPath(path, i)
+		if _, err := os.Stat(p); err != nil {
+			break
+		}
+		paths = append([]string{p}, paths...)
+	}
+	paths = append(paths, path)
+
+	for _, p := range paths {
+		err := readFile(p, emit)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(path string, emit func(Entry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a partial line is an entry being written
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("invalid entry in %s: %w", path, err)
+		}
+		if err := emit(entry); err != nil {
+			return err
+		}
+	}
+}
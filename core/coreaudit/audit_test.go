@@ -0,0 +1,97 @@
+package coreaudit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	config "github.com/ipfs/go-ipfs/config"
+)
+
+func TestConceal(t *testing.T) {
+	for _, test := range []struct {
+		command  string
+		args     []string
+		expected []string
+	}{
+		{"config", []string{"Pinning.RemoteServices.foo.API.Key", "secret"}, []string{"Pinning.RemoteServices.foo.API.Key", Redacted}},
+		{"config", []string{"pinning.remoteservices.foo.api.key", "secret"}, []string{"pinning.remoteservices.foo.api.key", Redacted}},
+		{"config", []string{"Pinning", `{"RemoteServices": {}}`}, []string{"Pinning", Redacted}},
+		{"config", []string{"Pinning.RemoteServices.foo.API.Endpoint", "https://example.com"}, []string{"Pinning.RemoteServices.foo.API.Endpoint", "https://example.com"}},
+		{"config", []string{"API.Tokens"}, []string{"API.Tokens"}},
+		{"config", []string{"WebDAV.Users", `{"alice": "secret"}`}, []string{"WebDAV.Users", Redacted}},
+		{"config", []string{"WebDAV.Users.alice", "secret"}, []string{"WebDAV.Users.alice", Redacted}},
+		{"config", []string{"WebDAV", `{"Users": {"alice": "secret"}}`}, []string{"WebDAV", Redacted}},
+		{"config", []string{"Addresses.API", "/ip4/127.0.0.1/tcp/5001"}, []string{"Addresses.API", "/ip4/127.0.0.1/tcp/5001"}},
+		{"pin/remote/service/add", []string{"foo", "https://example.com", "secret"}, []string{"foo", "https://example.com", Redacted}},
+		{"pin/add", []string{"QmFoo"}, []string{"QmFoo"}},
+	} {
+		if concealed := Conceal(test.command, test.args); !reflect.DeepEqual(concealed, test.expected) {
+			t.Errorf("%s %v: expected %v, got %v", test.command, test.args, test.expected, concealed)
+		}
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	maxFiles := new(config.OptionalInteger)
+	if err := maxFiles.UnmarshalJSON([]byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	maxSize := new(config.OptionalString)
+	if err := maxSize.UnmarshalJSON([]byte(`"1KB"`)); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(dir, config.APIAuditLog{Enabled: config.True, MaxSize: maxSize, MaxFiles: maxFiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Path != filepath.Join(dir, DefaultPath) || !l.Logs("pin/add") || !l.Logs("pin/import") || l.Logs("pin/ls") || l.Logs("pin/export") {
+		t.Fatalf("unexpected log %+v", l)
+	}
+
+	for i := 0; i < 40; i++ {
+		err := l.Write(Entry{Time: time.Now(), Command: "pin/add", Arguments: []string{fmt.Sprint(i)}, Status: 200})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{l.Path, l.Path + ".1", l.Path + ".2"} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 1000 {
+			t.Errorf("expected %s to be rotated, it has %d bytes", p, fi.Size())
+		}
+	}
+	if _, err := os.Stat(l.Path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated logs to be kept, got %v", err)
+	}
+
+	var read []string
+	err = Read(l.Path, func(entry Entry) error {
+		read = append(read, entry.Arguments...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the oldest entries were dropped, the others are read in order
+	if len(read) == 0 || len(read) == 40 {
+		t.Fatalf("expected the newest entries, got %v", read)
+	}
+	for i, arg := range read {
+		if expected := fmt.Sprint(40 - len(read) + i); arg != expected {
+			t.Fatalf("expected the entries oldest first, got %v", read)
+		}
+	}
+
+	disabled, err := Open(dir, config.APIAuditLog{})
+	if err != nil || disabled != nil {
+		t.Errorf("expected the log to be disabled by default, got %v (%v)", disabled, err)
+	}
+}
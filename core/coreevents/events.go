@@ -0,0 +1,227 @@
+// Package coreevents implements the bus of the events of the node, streamed
+// to the clients by `ipfs events subscribe`.
+package coreevents
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var log = logging.Logger("core/events")
+
+// Type is the type of an event, like "pin/added". The part before the slash
+// is its category.
+type Type string
+
+const (
+	PeerConnected    Type = "peer/connected"
+	PeerDisconnected Type = "peer/disconnected"
+	PinAdded         Type = "pin/added"
+	PinRemoved       Type = "pin/removed"
+	GCStarted        Type = "gc/started"
+	GCFinished       Type = "gc/finished"
+	NamePublished    Type = "name/published"
+	FilesRootChanged Type = "files/root-changed"
+	ProvideSucceeded Type = "provide/succeeded"
+	ProvideFailed    Type = "provide/failed"
+)
+
+// Types are all the types of events.
+var Types = []Type{
+	PeerConnected,
+	PeerDisconnected,
+	PinAdded,
+	PinRemoved,
+	GCStarted,
+	GCFinished,
+	NamePublished,
+	FilesRootChanged,
+	ProvideSucceeded,
+	ProvideFailed,
+}
+
+// Event is an event of the node. Data is one of the *Data types of this
+// package, depending on the Type.
+type Event struct {
+	Seq  uint64
+	Type Type
+	Time time.Time
+	Data interface{}
+}
+
+// PeerData is the data of the PeerConnected and PeerDisconnected events,
+// sent for the first and last connections to a peer.
+type PeerData struct {
+	Peer      peer.ID
+	Addr      string
+	Direction string
+}
+
+// PinData is the data of the PinAdded and PinRemoved events.
+type PinData struct {
+	Cid  cid.Cid
+	Mode string
+}
+
+// GCData is the data of the GCFinished event.
+type GCData struct {
+	Removed  uint64
+	Errors   uint64
+	Duration time.Duration
+}
+
+// NameData is the data of the NamePublished event.
+type NameData struct {
+	Name  string
+	Value string
+	EOL   time.Time `json:",omitempty"`
+}
+
+// FilesRootData is the data of the FilesRootChanged event.
+type FilesRootData struct {
+	Cid cid.Cid
+}
+
+// ProvideData is the data of the ProvideSucceeded and ProvideFailed events.
+// The keys provided in batches, by the accelerated DHT client, have raw CIDv1
+// and aren't marked as reprovides.
+type ProvideData struct {
+	Cid       cid.Cid
+	Reprovide bool   `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// SubscriptionBuffer is the number of events buffered for each subscriber.
+// The events are dropped for the subscribers which don't keep up.
+const SubscriptionBuffer = 1024
+
+// Bus dispatches the events of the node to the subscribers. The nil bus
+// drops the events.
+type Bus struct {
+	mu   sync.RWMutex
+	seq  uint64
+	subs map[*Subscription]struct{}
+}
+
+// NewBus returns a bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the events of the types it selects.
+type Subscription struct {
+	bus     *Bus
+	filter  []string
+	events  chan Event
+	dropped uint64
+	once    sync.Once
+}
+
+// ParseFilter checks the event types or categories of a filter, like
+// "pin/added" or "pin".
+func ParseFilter(filter []string) error {
+	for _, f := range filter {
+		known := false
+		for _, t := range Types {
+			if matches(f, t) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event type %q", f)
+		}
+	}
+	return nil
+}
+
+func matches(f string, t Type) bool {
+	return string(t) == f || strings.HasPrefix(string(t), f+"/")
+}
+
+// Subscribe returns a subscription to the events of the types or categories
+// of the filter, or to all the events if it is empty.
+func (b *Bus) Subscribe(filter []string) (*Subscription, error) {
+	if err := ParseFilter(filter); err != nil {
+		return nil, err
+	}
+	s := &Subscription{
+		bus:    b,
+		filter: filter,
+		events: make(chan Event, SubscriptionBuffer),
+	}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s, nil
+}
+
+// Publish sends an event to the subscribers, without blocking.
+func (b *Bus) Publish(t Type, data interface{}) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.subs) == 0 {
+		return
+	}
+	e := Event{
+		Seq:  atomic.AddUint64(&b.seq, 1),
+		Type: t,
+		Time: time.Now(),
+		Data: data,
+	}
+	for s := range b.subs {
+		if !s.selects(t) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			if atomic.AddUint64(&s.dropped, 1) == 1 {
+				log.Warn("dropping the events for a subscriber too slow")
+			}
+		}
+	}
+}
+
+func (s *Subscription) selects(t Type) bool {
+	if len(s.filter) == 0 {
+		return true
+	}
+	for _, f := range s.filter {
+		if matches(f, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Events returns the channel of the events, closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the subscriber
+// didn't keep up.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.events)
+	})
+}
@@ -0,0 +1,73 @@
+package coreevents
+
+import (
+	"testing"
+)
+
+func TestSubscriptionFilter(t *testing.T) {
+	b := NewBus()
+	if _, err := b.Subscribe([]string{"pins"}); err == nil {
+		t.Fatal("expected unknown types to be rejected")
+	}
+	all, err := b.Subscribe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins, err := b.Subscribe([]string{"pin", "gc/finished"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Publish(PinAdded, &PinData{})
+	b.Publish(GCStarted, nil)
+	b.Publish(GCFinished, &GCData{})
+	all.Close()
+	pins.Close()
+
+	for _, test := range []struct {
+		sub      *Subscription
+		expected []Type
+	}{
+		{all, []Type{PinAdded, GCStarted, GCFinished}},
+		{pins, []Type{PinAdded, GCFinished}},
+	} {
+		var received []Type
+		var seq uint64
+		for e := range test.sub.Events() {
+			if e.Seq <= seq {
+				t.Errorf("expected increasing sequence numbers, got %d after %d", e.Seq, seq)
+			}
+			seq = e.Seq
+			received = append(received, e.Type)
+		}
+		if len(received) != len(test.expected) {
+			t.Fatalf("expected %v, got %v", test.expected, received)
+		}
+		for i := range received {
+			if received[i] != test.expected[i] {
+				t.Fatalf("expected %v, got %v", test.expected, received)
+			}
+		}
+	}
+
+	// publishing after the subscriptions are closed doesn't block
+	b.Publish(PinRemoved, &PinData{})
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	b := NewBus()
+	s, err := b.Subscribe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < SubscriptionBuffer+10; i++ {
+		b.Publish(PinAdded, &PinData{})
+	}
+	if s.Dropped() != 10 {
+		t.Errorf("expected 10 events dropped, got %d", s.Dropped())
+	}
+
+	var nilBus *Bus
+	nilBus.Publish(PinAdded, &PinData{})
+}
@@ -1,6 +1,7 @@
 package corehttp
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -13,6 +14,7 @@ import (
 	oldcmds "github.com/ipfs/go-ipfs/commands"
 	"github.com/ipfs/go-ipfs/core"
 	corecommands "github.com/ipfs/go-ipfs/core/commands"
+	"github.com/ipfs/go-ipfs/core/coreaudit"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	cmdsHttp "github.com/ipfs/go-ipfs-cmds/http"
@@ -123,7 +125,7 @@ func patchCORSVars(c *cmdsHttp.ServerConfig, addr net.Addr) {
 	c.SetAllowedOrigins(newOrigins...)
 }
 
-func commandsOption(cctx oldcmds.Context, command *cmds.Command, allowGet bool) ServeOption {
+func commandsOption(cctx oldcmds.Context, command *cmds.Command, allowGet, authorize bool) ServeOption {
 	return func(n *core.IpfsNode, l net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
 
 		cfg := cmdsHttp.NewServerConfig()
@@ -145,22 +147,63 @@ func commandsOption(cctx oldcmds.Context, command *cmds.Command, allowGet bool)
 		addCORSDefaults(cfg)
 		patchCORSVars(cfg, l.Addr())
 
-		cmdHandler := cmdsHttp.NewHandler(&cctx, command, cfg)
+		var cmdHandler http.Handler = cmdsHttp.NewHandler(&cctx, command, cfg)
+		if authorize {
+			cmdHandler = withEventStream(n, cfg, cmdHandler)
+			cmdHandler = withAPITokens(n, command, cmdHandler)
+
+			audit, err := coreaudit.Open(cctx.ConfigRoot, rcfg.API.AuditLog)
+			if err != nil {
+				return nil, err
+			}
+			if audit != nil {
+				cmdHandler = withAuditLog(n, command, audit, cmdHandler)
+			}
+		}
 		mux.Handle(APIPath+"/", cmdHandler)
+
+		openAPIHandler, err := openAPIHandler(command)
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle(APIPath+"/openapi.json", openAPIHandler)
 		return mux, nil
 	}
 }
 
+// openAPIHandler serves the OpenAPI description of the commands, which
+// doesn't require API tokens.
+func openAPIHandler(command *cmds.Command) (http.Handler, error) {
+	doc, err := json.Marshal(corecommands.OpenAPI(command))
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(doc)))
+		if r.Method == http.MethodGet {
+			w.Write(doc)
+		}
+	}), nil
+}
+
 // CommandsOption constructs a ServerOption for hooking the commands into the
-// HTTP server. It will NOT allow GET requests.
+// HTTP server. It will NOT allow GET requests, except for the server-sent
+// events of `ipfs events subscribe`, requires the API.Tokens when set, and
+// writes the API.AuditLog when enabled.
 func CommandsOption(cctx oldcmds.Context) ServeOption {
-	return commandsOption(cctx, corecommands.Root, false)
+	return commandsOption(cctx, corecommands.Root, false, true)
 }
 
 // CommandsROOption constructs a ServerOption for hooking the read-only commands
 // into the HTTP server. It will allow GET requests.
 func CommandsROOption(cctx oldcmds.Context) ServeOption {
-	return commandsOption(cctx, corecommands.RootRO, true)
+	return commandsOption(cctx, corecommands.RootRO, true, false)
 }
 
 // CheckVersionOption returns a ServeOption that checks whether the client ipfs version matches. Does nothing when the user agent string does not contain `/go-ipfs/`
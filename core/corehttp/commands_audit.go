@@ -0,0 +1,117 @@
+package corehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	cmdsHttp "github.com/ipfs/go-ipfs-cmds/http"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreaudit"
+)
+
+// maxAuditErrorSize is the size of the error responses read for the log.
+const maxAuditErrorSize = 4096
+
+// withAuditLog writes the calls to the commands selected by the audit log to
+// it, with their outcome. The calls denied by withAPITokens are logged too.
+func withAuditLog(n *core.IpfsNode, root *cmds.Command, audit *coreaudit.Log, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urlPath := strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/")
+		cmdPath := commandPath(root, urlPath)
+		if r.Method == http.MethodOptions || cmdPath == "" || !audit.Logs(cmdPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the segments after the command are arguments, like the arg
+		// parameters
+		var args []string
+		if rest := strings.Trim(strings.TrimPrefix(urlPath, cmdPath), "/"); rest != "" {
+			args = append(args, rest)
+		}
+		var opts map[string][]string
+		for name, values := range r.URL.Query() {
+			if name == "arg" {
+				args = append(args, values...)
+				continue
+			}
+			if opts == nil {
+				opts = make(map[string][]string)
+			}
+			opts[name] = values
+		}
+		entry := coreaudit.Entry{
+			Time:       time.Now(),
+			RemoteAddr: r.RemoteAddr,
+			Command:    cmdPath,
+			Arguments:  coreaudit.Conceal(cmdPath, args),
+			Options:    opts,
+		}
+		if cfg, err := n.Repo.Config(); err == nil && len(cfg.API.Tokens) > 0 {
+			entry.Token, _ = findAPIToken(cfg.API.Tokens, r)
+		}
+
+		rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		entry.Status = rec.status
+		if e := w.Header().Get(cmdsHttp.StreamErrHeader); e != "" {
+			entry.Error = e
+		} else if rec.status >= http.StatusBadRequest {
+			entry.Error = auditErrorMessage(rec.body.Bytes())
+		}
+		if err := audit.Write(entry); err != nil {
+			log.Errorf("writing the audit log: %s", err)
+		}
+	})
+}
+
+// auditErrorMessage returns the message of an error response, which is
+// either a cmds.Error or text.
+func auditErrorMessage(body []byte) string {
+	var cmdErr cmds.Error
+	if err := json.Unmarshal(body, &cmdErr); err == nil && cmdErr.Message != "" {
+		return cmdErr.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// auditRecorder records the status of a response, and the start of its body
+// for errors.
+type auditRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *auditRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *auditRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	if r.status >= http.StatusBadRequest && r.body.Len() < maxAuditErrorSize {
+		rest := maxAuditErrorSize - r.body.Len()
+		if len(p) < rest {
+			rest = len(p)
+		}
+		r.body.Write(p[:rest])
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush lets the commands stream their outputs.
+func (r *auditRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,104 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	oldcmds "github.com/ipfs/go-ipfs/commands"
+	config "github.com/ipfs/go-ipfs/config"
+	core "github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreaudit"
+)
+
+func TestCommandsAuditLog(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{
+		"Tokens": {
+			"ci": {"Hash": "`+config.HashAPIToken("ci-secret")+`", "Allow": ["pin/*", "config", "version"]}
+		},
+		"AuditLog": {"Enabled": true, "Path": "`+filepath.ToSlash(path)+`"}
+	}`), &cfg.API); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	cctx := oldcmds.Context{
+		ReqLog:        &oldcmds.ReqLog{},
+		ConstructNode: func() (*core.IpfsNode, error) { return n, nil },
+	}
+	dh.Handler, err = makeHandler(n, ts.Listener, CommandsOption(cctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path, token string
+		status      int
+	}{
+		{"/version", "ci-secret", http.StatusOK},
+		{"/pin/rm?arg=QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn&recursive=true", "ci-secret", http.StatusInternalServerError},
+		{"/config?arg=Pinning.RemoteServices.foo.API.Key&arg=secret", "ci-secret", http.StatusInternalServerError},
+		{"/key/rm?arg=foo", "ci-secret", http.StatusForbidden},
+		{"/key/rm?arg=foo", "", http.StatusUnauthorized},
+	} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+APIPath+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Fatalf("%s: expected status %d, got %d", test.path, test.status, res.StatusCode)
+		}
+	}
+
+	var entries []coreaudit.Entry
+	err = coreaudit.Read(path, func(entry coreaudit.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected the calls changing the node to be logged, got %+v", entries)
+	}
+	pinRm := entries[0]
+	if pinRm.Command != "pin/rm" || pinRm.Token != "ci" || pinRm.Arguments[0] != "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn" ||
+		pinRm.Options["recursive"][0] != "true" || pinRm.Error == "" || pinRm.RemoteAddr == "" {
+		t.Errorf("unexpected entry %+v", pinRm)
+	}
+	if conf := entries[1]; conf.Command != "config" || conf.Arguments[1] != coreaudit.Redacted {
+		t.Errorf("expected the secret to be redacted, got %+v", conf)
+	}
+	if denied := entries[2]; denied.Token != "ci" || denied.Status != http.StatusForbidden {
+		t.Errorf("unexpected entry %+v", denied)
+	}
+	if anonymous := entries[3]; anonymous.Token != "" || anonymous.Status != http.StatusUnauthorized {
+		t.Errorf("unexpected entry %+v", anonymous)
+	}
+}
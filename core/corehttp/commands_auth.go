@@ -0,0 +1,120 @@
+package corehttp
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	config "github.com/ipfs/go-ipfs/config"
+	"github.com/ipfs/go-ipfs/core"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+)
+
+// withAPITokens requires the requests to the commands to carry one of the
+// bearer tokens of API.Tokens, when any, and the command to be allowed for
+// it. The tokens are read on every request, so that `ipfs api token`
+// changes apply immediately. The token is passed to the commands in the
+// context of the request.
+func withAPITokens(n *core.IpfsNode, root *cmds.Command, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// CORS preflight requests don't run commands, and can't carry tokens
+		if len(cfg.API.Tokens) == 0 || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, token := findAPIToken(cfg.API.Tokens, r)
+		if token == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ipfs"`)
+			http.Error(w, "401 - Unauthorized: a valid API token is required", http.StatusUnauthorized)
+			return
+		}
+		cmdPath := commandPath(root, strings.TrimPrefix(r.URL.Path, APIPath))
+		if !token.Allows(cmdPath) {
+			http.Error(w, fmt.Sprintf("403 - Forbidden: token %q is not allowed to call %q", name, cmdPath), http.StatusForbidden)
+			return
+		}
+		ctx := cmdenv.ContextWithAPIToken(r.Context(), cmdenv.APIToken{Name: name, APIToken: token})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// APITokensOption requires the requests to the routes of the API listener
+// other than the commands, like /debug/pprof/, /webui or the gateway of
+// --unrestricted-api, to carry one of the tokens of API.Tokens, when any,
+// allowed to call everything ("*"). The commands check their tokens
+// themselves, see withAPITokens, and /api/v0/openapi.json is public.
+func APITokensOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, APIPath+"/") || r.Method == http.MethodOptions {
+				mux.ServeHTTP(w, r)
+				return
+			}
+			cfg, err := n.Repo.Config()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if len(cfg.API.Tokens) == 0 {
+				mux.ServeHTTP(w, r)
+				return
+			}
+
+			name, token := findAPIToken(cfg.API.Tokens, r)
+			if token == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="ipfs"`)
+				http.Error(w, "401 - Unauthorized: a valid API token is required", http.StatusUnauthorized)
+				return
+			}
+			if !token.Allows("*") {
+				http.Error(w, fmt.Sprintf("403 - Forbidden: token %q is not allowed to access %q", name, r.URL.Path), http.StatusForbidden)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+		return mux, nil
+	}
+}
+
+// findAPIToken returns the token of the Authorization header of the request,
+// or nil if it has none or an unknown one.
+func findAPIToken(tokens map[string]*config.APIToken, r *http.Request) (string, *config.APIToken) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return "", nil
+	}
+	hash := []byte(config.HashAPIToken(strings.TrimSpace(auth[len("Bearer "):])))
+	for name, token := range tokens {
+		if token != nil && subtle.ConstantTimeCompare(hash, []byte(token.Hash)) == 1 {
+			return name, token
+		}
+	}
+	return "", nil
+}
+
+// commandPath returns the path of the command called by a request to the
+// given URL path, like "pin/add" for "/pin/add/QmFoo": the segments after the
+// command are its arguments.
+func commandPath(root *cmds.Command, urlPath string) string {
+	var pth []string
+	cmd := root
+	for _, name := range strings.Split(strings.Trim(urlPath, "/"), "/") {
+		sub, ok := cmd.Subcommands[name]
+		if !ok {
+			break
+		}
+		pth = append(pth, name)
+		cmd = sub
+	}
+	return strings.Join(pth, "/")
+}
@@ -0,0 +1,177 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/pprof"
+	"strings"
+	"testing"
+
+	oldcmds "github.com/ipfs/go-ipfs/commands"
+	config "github.com/ipfs/go-ipfs/config"
+	core "github.com/ipfs/go-ipfs/core"
+)
+
+func TestCommandsAPITokens(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	cctx := oldcmds.Context{
+		ReqLog:        &oldcmds.ReqLog{},
+		ConstructNode: func() (*core.IpfsNode, error) { return n, nil },
+	}
+	dh.Handler, err = makeHandler(n, ts.Listener, CommandsOption(cctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, cmdPath, token string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+APIPath+cmdPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	// no tokens, no authentication
+	if status, body := call(http.MethodPost, "/version", ""); status != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", status, body)
+	}
+
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"Tokens": {
+		"ci": {"Hash": "`+config.HashAPIToken("ci-secret")+`", "Allow": ["pin/*", "version"]}
+	}}`), &cfg.API); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{http.MethodPost, "/version", "", http.StatusUnauthorized},
+		{http.MethodPost, "/version", "wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/version", "ci-secret", http.StatusOK},
+		{http.MethodPost, "/pin/ls", "ci-secret", http.StatusOK},
+		{http.MethodPost, "/config/replace", "ci-secret", http.StatusForbidden},
+		{http.MethodPost, "/key/export?arg=self", "ci-secret", http.StatusForbidden},
+		{http.MethodPost, "/version/deps", "ci-secret", http.StatusForbidden},
+		{http.MethodOptions, "/config/replace", "", http.StatusNoContent},
+		{http.MethodGet, "/openapi.json", "", http.StatusOK},
+		{http.MethodPost, "/openapi.json", "", http.StatusMethodNotAllowed},
+	} {
+		status, body := call(test.method, test.path, test.token)
+		if status != test.status {
+			t.Errorf("%s %s with %q: expected status %d, got %d: %s", test.method, test.path, test.token, test.status, status, body)
+		}
+		if status == http.StatusForbidden && !strings.Contains(body, strings.TrimPrefix(strings.Split(test.path, "?")[0], "/")) {
+			t.Errorf("expected the command path in %q", body)
+		}
+	}
+}
+
+func TestAPITokensOption(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	cctx := oldcmds.Context{
+		ReqLog:        &oldcmds.ReqLog{},
+		ConstructNode: func() (*core.IpfsNode, error) { return n, nil },
+	}
+	pprofOption := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		return mux, nil
+	}
+	dh.Handler, err = makeHandler(n, ts.Listener, APITokensOption(), CommandsOption(cctx), pprofOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, pth, token string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+pth, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// no tokens, no authentication
+	if status := call(http.MethodGet, "/debug/pprof/", ""); status != http.StatusOK {
+		t.Fatalf("unexpected status %d", status)
+	}
+
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"Tokens": {
+		"ci": {"Hash": "`+config.HashAPIToken("ci-secret")+`", "Allow": ["pin/*", "version"]},
+		"admin": {"Hash": "`+config.HashAPIToken("admin-secret")+`", "Allow": ["*"]}
+	}}`), &cfg.API); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{http.MethodGet, "/debug/pprof/", "", http.StatusUnauthorized},
+		{http.MethodGet, "/debug/pprof/", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/debug/pprof/", "ci-secret", http.StatusForbidden},
+		{http.MethodGet, "/debug/pprof/", "admin-secret", http.StatusOK},
+		{http.MethodGet, "/ipfs/bafkqaaa", "", http.StatusUnauthorized},
+		{http.MethodPost, APIPath + "/version", "", http.StatusUnauthorized},
+		{http.MethodPost, APIPath + "/version", "ci-secret", http.StatusOK},
+		{http.MethodGet, APIPath + "/openapi.json", "", http.StatusOK},
+	} {
+		if status := call(test.method, test.path, test.token); status != test.status {
+			t.Errorf("%s %s with %q: expected status %d, got %d", test.method, test.path, test.token, test.status, status)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	cmdsHttp "github.com/ipfs/go-ipfs-cmds/http"
+	"github.com/ipfs/go-ipfs/core"
+)
+
+// eventsPath is the path of the command streaming the events, also served
+// as server-sent events to GET requests.
+const eventsPath = "/events/subscribe"
+
+// eventsKeepAlive is the interval of the comments sent to keep the event
+// streams open through proxies.
+var eventsKeepAlive = 30 * time.Second
+
+// withEventStream serves the events of the node as server-sent events to the
+// GET requests to eventsPath, filtered by their "type" parameters, and passes
+// the other requests to next.
+func withEventStream(n *core.IpfsNode, cfg *cmdsHttp.ServerConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != APIPath+eventsPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		origin, ok := eventsOrigin(r, cfg)
+		if !ok {
+			http.Error(w, "403 - Forbidden", http.StatusForbidden)
+			log.Warnf("API blocked request to %s. (possible CSRF)", r.URL)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		sub, err := n.Events.Subscribe(r.URL.Query()["type"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer sub.Close()
+
+		for k, v := range cfg.Headers {
+			w.Header()[k] = v
+		}
+		if origin != "" {
+			w.Header().Set(cmdsHttp.ACAOrigin, origin)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		// the comment sends the headers before the first event
+		fmt.Fprint(w, ": subscribed\n\n")
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case e := <-sub.Events():
+				data, err := json.Marshal(&e)
+				if err != nil {
+					log.Errorf("encoding event %d: %s", e.Seq, err)
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// eventsOrigin checks the Origin and Referer of the request against the
+// allowed origins of the API, like the commands handler does, and returns
+// the origin to allow in the response, if any.
+func eventsOrigin(r *http.Request, cfg *cmdsHttp.ServerConfig) (string, bool) {
+	origin := r.Header.Get("Origin")
+	if origin == "" && r.Referer() != "" {
+		u, err := url.Parse(r.Referer())
+		if err != nil {
+			return "", false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	if origin == "" {
+		return "", true
+	}
+	for _, o := range cfg.AllowedOrigins() {
+		if o == "*" || o == origin {
+			return origin, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,95 @@
+package corehttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	oldcmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreevents"
+)
+
+func TestCommandsEventStream(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	cctx := oldcmds.Context{
+		ReqLog:        &oldcmds.ReqLog{},
+		ConstructNode: func() (*core.IpfsNode, error) { return n, nil },
+	}
+	dh.Handler, err = makeHandler(n, ts.Listener, CommandsOption(cctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(ts.URL + APIPath + "/events/subscribe?type=unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected unknown types to be rejected, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(ts.URL + APIPath + "/events/subscribe?type=pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	r := bufio.NewReader(res.Body)
+	readEvent := func() []string {
+		t.Helper()
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				return lines
+			}
+			lines = append(lines, line)
+		}
+	}
+	if lines := readEvent(); len(lines) != 1 || !strings.HasPrefix(lines[0], ":") {
+		t.Fatalf("expected a comment first, got %q", lines)
+	}
+
+	c, err := cid.Decode("bafkqaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Events.Publish(coreevents.GCStarted, nil)
+	n.Pinning.PinWithMode(c, pin.Direct)
+
+	lines := readEvent()
+	if len(lines) != 3 || lines[1] != "event: pin/added" || !strings.HasPrefix(lines[0], "id: ") {
+		t.Fatalf("expected only the pin event, got %q", lines)
+	}
+	var e struct {
+		Type string
+		Data coreevents.PinData
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != string(coreevents.PinAdded) || !e.Data.Cid.Equals(c) || e.Data.Mode != "direct" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	core "github.com/ipfs/go-ipfs/core"
@@ -139,3 +140,29 @@ func Serve(node *core.IpfsNode, lis net.Listener, options ...ServeOption) error
 	log.Infof("server at %s terminated", addr)
 	return serverError
 }
+
+// IsLocalListener returns whether the listener only accepts connections from
+// the local host: on a loopback address or a unix socket.
+func IsLocalListener(lis net.Listener) bool {
+	addr, err := manet.FromNetAddr(lis.Addr())
+	if err != nil {
+		return false
+	}
+	if _, err := addr.ValueForProtocol(ma.P_UNIX); err == nil {
+		return true
+	}
+	return manet.IsIPLoopback(addr)
+}
+
+// repoRelativePath resolves a path of the config relative to the directory of
+// the repo of the node, which has to be on disk then.
+func repoRelativePath(n *core.IpfsNode, p string) (string, error) {
+	if filepath.IsAbs(p) {
+		return p, nil
+	}
+	r, ok := n.Repo.(interface{ Path() string })
+	if !ok || r.Path() == "" {
+		return "", fmt.Errorf("relative path %q requires a repo on disk", p)
+	}
+	return filepath.Join(r.Path(), p), nil
+}
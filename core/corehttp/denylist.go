@@ -0,0 +1,309 @@
+package corehttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	cid "github.com/ipfs/go-cid"
+	config "github.com/ipfs/go-ipfs/config"
+	core "github.com/ipfs/go-ipfs/core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultDenylistResponse is the body of responses for denied content, unless
+// Gateway.DenylistResponse is set.
+const DefaultDenylistResponse = "410 Gone: this content is not available on this gateway\n"
+
+// denylist blocks content on the gateway. Entries are CIDs, CIDs with a path
+// prefix, and IPNS names, loaded from the files in Gateway.Denylists:
+//
+//   # comments and empty lines are ignored
+//   bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi
+//   /ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/some/path
+//   /ipns/example.com
+//   /ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8
+//
+// CIDs are matched by multihash, so a CIDv0 entry also blocks the CIDv1 of the
+// same content, and the other way around.
+type denylist struct {
+	files    []string
+	response string
+
+	lk sync.RWMutex
+	// namespace root → path prefixes, where an empty prefix blocks everything
+	entries map[string][]string
+
+	blockedMetric *prometheus.CounterVec
+	reloadMetric  *prometheus.CounterVec
+}
+
+// nodeDenylist loads the denylist of Gateway.Denylists, and watches its files
+// for changes until stop is called. It returns nil when Gateway.Denylists is
+// empty.
+func nodeDenylist(n *core.IpfsNode, cfg *config.Config) (d *denylist, stop func(), err error) {
+	if len(cfg.Gateway.Denylists) == 0 {
+		return nil, func() {}, nil
+	}
+
+	files := make([]string, 0, len(cfg.Gateway.Denylists))
+	for _, f := range cfg.Gateway.Denylists {
+		f, err := repoRelativePath(n, f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Gateway.Denylists: %w", err)
+		}
+		files = append(files, f)
+	}
+
+	d, err = newDenylist(files, cfg.Gateway.DenylistResponse.WithDefault(DefaultDenylistResponse))
+	if err != nil {
+		return nil, nil, err
+	}
+	if stop, err = d.watch(); err != nil {
+		return nil, nil, err
+	}
+	return d, stop, nil
+}
+
+func newDenylist(files []string, response string) (*denylist, error) {
+	d := &denylist{
+		files:    files,
+		response: response,
+		blockedMetric: newGatewayCounterMetric(
+			"gw_denylist_blocked_total",
+			"The number of gateway requests blocked by the denylist, by type of the matching entry.",
+			"type",
+		),
+		reloadMetric: newGatewayCounterMetric(
+			"gw_denylist_reloads_total",
+			"The number of times the gateway denylist files were reloaded, by result.",
+			"result",
+		),
+	}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// reload replaces the entries with the current content of the files. Missing
+// files are ignored, so that they can be created while the node runs.
+func (d *denylist) reload() error {
+	entries := map[string][]string{}
+	for _, f := range d.files {
+		if err := loadDenylistFile(f, entries); err != nil {
+			d.reloadMetric.WithLabelValues("error").Inc()
+			return err
+		}
+	}
+
+	d.lk.Lock()
+	d.entries = entries
+	d.lk.Unlock()
+	d.reloadMetric.WithLabelValues("success").Inc()
+	return nil
+}
+
+func loadDenylistFile(file string, entries map[string][]string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Warnf("denylist file %s does not exist", file)
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "/") {
+			// a bare CID
+			line = ipfsPathPrefix + line
+		}
+		root, rest, err := denylistKey(line)
+		if err != nil {
+			return fmt.Errorf("denylist %s line %d: %w", file, lineNum, err)
+		}
+		if rest == "/" {
+			rest = ""
+		}
+		entries[root] = append(entries[root], rest)
+	}
+	return s.Err()
+}
+
+// denylistKey splits a content path into the key of its namespace root and
+// the cleaned path after the root.
+func denylistKey(p string) (root string, rest string, err error) {
+	segments := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+	if len(segments) < 2 || segments[1] == "" {
+		return "", "", fmt.Errorf("invalid path %q", p)
+	}
+	rest = "/"
+	if len(segments) == 3 {
+		rest = gopath.Clean("/" + segments[2])
+	}
+
+	switch segments[0] {
+	case "ipfs":
+		c, err := cid.Decode(segments[1])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid CID in %q: %w", p, err)
+		}
+		return "ipfs:" + string(c.Hash()), rest, nil
+	case "ipns":
+		name := segments[1]
+		if id, err := peer.Decode(name); err == nil {
+			// the same key may be represented as a PeerID or a CID
+			return "ipns:" + peer.ToCid(id).String(), rest, nil
+		}
+		return "ipns:" + strings.ToLower(name), rest, nil
+	default:
+		return "", "", fmt.Errorf("path %q is not in the /ipfs or /ipns namespace", p)
+	}
+}
+
+// match returns the type of the entry blocking the content path, if any: a
+// whole CID, an IPNS name, or a path prefix under one of those.
+func (d *denylist) match(contentPath string) (string, bool) {
+	root, rest, err := denylistKey(contentPath)
+	if err != nil {
+		return "", false
+	}
+
+	d.lk.RLock()
+	prefixes, ok := d.entries[root]
+	d.lk.RUnlock()
+	if !ok {
+		return "", false
+	}
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			return strings.SplitN(root, ":", 2)[0], true
+		}
+		if rest == prefix || strings.HasPrefix(rest, prefix+"/") {
+			return "path", true
+		}
+	}
+	return "", false
+}
+
+// serveIfDenied responds with 410 Gone if any of the content paths is on the
+// denylist, and returns true if it did.
+func (d *denylist) serveIfDenied(w http.ResponseWriter, contentPaths ...string) bool {
+	if d == nil {
+		return false
+	}
+	for _, p := range contentPaths {
+		matchType, ok := d.match(p)
+		if !ok {
+			continue
+		}
+		log.Debugw("blocked by denylist", "path", p, "type", matchType)
+		d.blockedMetric.WithLabelValues(matchType).Inc()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprint(w, d.response)
+		return true
+	}
+	return false
+}
+
+// intermediatePaths returns the content path from each of the CIDs its
+// resolution passes through, from the one of the root of an IPNS path to the
+// parent of its terminal element, so that content denied by CID is denied
+// when reached through a path too. It returns nil without a denylist.
+func (i *gatewayHandler) intermediatePaths(ctx context.Context, contentPath ipath.Path) []string {
+	if i.denylist == nil {
+		return nil
+	}
+	segments := strings.Split(strings.Trim(contentPath.String(), "/"), "/")
+	first := 2
+	if segments[0] == "ipfs" {
+		// the root is already a CID
+		first = 3
+	}
+	var paths []string
+	for n := first; n < len(segments); n++ {
+		resolved, err := i.resolvePath(ctx, ipath.New("/"+strings.Join(segments[:n], "/")))
+		if err != nil {
+			// the whole path resolved, so this is unlikely
+			log.Debugw("resolving a parent path for the denylist", "path", contentPath, "error", err)
+			break
+		}
+		paths = append(paths, ipfsPathPrefix+resolved.Cid().String()+"/"+strings.Join(segments[n:], "/"))
+	}
+	return paths
+}
+
+// watch reloads the denylist when one of its files changes. The parent
+// directories are watched, as editors often replace files instead of writing
+// to them, and the files in missing directories are not watched.
+func (d *denylist) watch() (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	watched := map[string]bool{}
+	for _, f := range d.files {
+		dir := filepath.Dir(f)
+		if watched[dir] {
+			continue
+		}
+		watched[dir] = true
+		if err := watcher.Add(dir); err != nil {
+			if os.IsNotExist(err) {
+				log.Warnf("denylist directory %s does not exist, the changes of its files need a restart", dir)
+				continue
+			}
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	isDenylistFile := map[string]bool{}
+	for _, f := range d.files {
+		isDenylistFile[filepath.Clean(f)] = true
+	}
+
+	go func() {
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !isDenylistFile[filepath.Clean(e.Name)] {
+					continue
+				}
+				if err := d.reload(); err != nil {
+					log.Errorf("failed to reload denylist, keeping the previous entries: %s", err)
+					continue
+				}
+				log.Infof("reloaded denylist after change to %s", e.Name)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("denylist watcher: %s", err)
+			}
+		}
+	}()
+	return func() { watcher.Close() }, nil
+}
@@ -0,0 +1,262 @@
+package corehttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	config "github.com/ipfs/go-ipfs/config"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	repo "github.com/ipfs/go-ipfs/repo"
+	path "github.com/ipfs/go-path"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDenylistMatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "denylist")
+	err := ioutil.WriteFile(file, []byte(`# comment
+QmbWqxBEKC3P8tqsKc98xmWNzrzDtRLMiMPL8wBuTGsMnR
+/ipfs/bafkqaaa/some/path
+/ipns/Example.com
+/ipns/QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerID, err := peer.Decode("QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDenylist([]string{file}, DefaultDenylistResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path      string
+		matchType string
+	}{
+		{"/ipfs/QmbWqxBEKC3P8tqsKc98xmWNzrzDtRLMiMPL8wBuTGsMnR", "ipfs"},
+		{"/ipfs/QmbWqxBEKC3P8tqsKc98xmWNzrzDtRLMiMPL8wBuTGsMnR/file", "ipfs"},
+		// CIDv1 of the same multihash
+		{"/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", "ipfs"},
+		{"/ipfs/bafkqaaa/some/path", "path"},
+		{"/ipfs/bafkqaaa/some/path/deeper", "path"},
+		{"/ipfs/bafkqaaa/some//path/", "path"},
+		{"/ipfs/bafkqaaa/some/pathology", ""},
+		{"/ipfs/bafkqaaa/some", ""},
+		{"/ipns/example.com/index.html", "ipns"},
+		// libp2p-key CID of the same PeerID
+		{"/ipns/" + peer.ToCid(peerID).String(), "ipns"},
+		{"/ipns/example.org", ""},
+		{"/ipfs/", ""},
+	} {
+		matchType, ok := d.match(test.path)
+		if ok != (test.matchType != "") || matchType != test.matchType {
+			t.Errorf("%s: got (%q, %t), expected %q", test.path, matchType, ok, test.matchType)
+		}
+	}
+
+	if err := ioutil.WriteFile(file, []byte("/ipfs/not-a-cid\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reload(); err == nil {
+		t.Fatal("expected an error for an invalid entry")
+	}
+	if _, ok := d.match("/ipfs/QmbWqxBEKC3P8tqsKc98xmWNzrzDtRLMiMPL8wBuTGsMnR"); !ok {
+		t.Fatal("a failed reload should keep the previous entries")
+	}
+}
+
+func TestGatewayDenylist(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("allowed")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("denied")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := api.Unixfs().Add(n.Context(), files.NewMapDirectory(map[string]files.Node{
+		"denied": files.NewMapDirectory(map[string]files.Node{
+			"file": files.NewBytesFile([]byte("in a denied directory")),
+		}),
+		"allowed": files.NewBytesFile([]byte("next to a denied directory")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deniedDir, err := api.ResolvePath(n.Context(), ipath.Join(dir, "denied"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/denied.example.com"] = path.FromString(denied.String())
+	ns["/ipns/allowed.example.com"] = path.FromString(allowed.String())
+	ns["/ipns/dir.example.com"] = path.FromString(dir.String())
+
+	file := filepath.Join(t.TempDir(), "denylist")
+	if err := ioutil.WriteFile(file, []byte(denied.Cid().String()+"\n"+deniedDir.Cid().String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Gateway.Denylists = []string{file}
+	cfg.Gateway.DenylistResponse = &config.OptionalString{}
+	if err := cfg.Gateway.DenylistResponse.UnmarshalJSON([]byte(`"blocked"`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	state, err := NewGatewayState(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { state.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, state.HostnameOption(), state.GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(host, urlPath string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	blocked := testutil.ToFloat64(state.denylist.blockedMetric.WithLabelValues("ipfs"))
+	for _, test := range []struct {
+		host, path string
+		status     int
+	}{
+		{"", allowed.String(), http.StatusOK},
+		{"", denied.String(), http.StatusGone},
+		{"allowed.example.com", "/", http.StatusOK},
+		// the DNSLink is only denied after resolution
+		{"denied.example.com", "/", http.StatusGone},
+		{"", "/ipns/denied.example.com", http.StatusGone},
+		// denied CIDs are denied when reached through a path too
+		{"", dir.String() + "/denied/file", http.StatusGone},
+		{"", "/ipns/dir.example.com/denied/file", http.StatusGone},
+		{"", dir.String() + "/allowed", http.StatusOK},
+	} {
+		status, body := get(test.host, test.path)
+		if status != test.status {
+			t.Errorf("%s%s: got status %d, expected %d", test.host, test.path, status, test.status)
+		}
+		if status == http.StatusGone && body != "blocked" {
+			t.Errorf("%s%s: unexpected body %q", test.host, test.path, body)
+		}
+	}
+	if count := testutil.ToFloat64(state.denylist.blockedMetric.WithLabelValues("ipfs")) - blocked; count != 5 {
+		t.Errorf("expected 5 blocked requests in metrics, got %v", count)
+	}
+
+	// the file is reloaded when it changes
+	if err := ioutil.WriteFile(file, []byte("/ipns/allowed.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := get("", denied.String())
+		if status == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("denylist was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status, _ := get("allowed.example.com", "/"); status != http.StatusGone {
+		t.Errorf("expected the reloaded denylist to block the IPNS name, got %d", status)
+	}
+}
+
+// pathRepo is a repo in the directory at path.
+type pathRepo struct {
+	repo.Repo
+	path string
+}
+
+func (r pathRepo) Path() string { return r.path }
+
+func TestDenylistRepoPath(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Gateway.Denylists = []string{"denylist"}
+
+	// a repo which isn't on disk has no directory to resolve the path
+	if _, _, err := nodeDenylist(n, cfg); err == nil {
+		t.Fatal("expected an error for a relative denylist without a repo on disk")
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "denylist"), []byte("bafkqaaa\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	n.Repo = pathRepo{Repo: n.Repo, path: dir}
+	d, stop, err := nodeDenylist(n, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if _, ok := d.match("/ipfs/bafkqaaa"); !ok {
+		t.Error("expected the denylist in the repo to be loaded")
+	}
+}
+
+func TestDenylistMissingDirectory(t *testing.T) {
+	d, err := newDenylist([]string{filepath.Join(t.TempDir(), "missing", "denylist")}, DefaultDenylistResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, err := d.watch()
+	if err != nil {
+		t.Fatalf("expected the missing directory to be skipped: %s", err)
+	}
+	stop()
+}
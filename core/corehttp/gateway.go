@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/http"
 	"sort"
+	"time"
 
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
@@ -16,11 +17,24 @@ import (
 )
 
 type GatewayConfig struct {
-	Headers      map[string][]string
-	Writable     bool
-	PathPrefixes []string
+	Headers       map[string][]string
+	Writable      bool
+	PathPrefixes  []string
+	TrustlessOnly bool
+
+	// Deadlines of Gateway.Timeouts, zero disables them
+	ResolutionTimeout time.Duration
+	FirstBlockTimeout time.Duration
+	TotalTimeout      time.Duration
 }
 
+// Default values of Gateway.Timeouts
+const (
+	DefaultGatewayResolutionTimeout = 2 * time.Minute
+	DefaultGatewayFirstBlockTimeout = 2 * time.Minute
+	DefaultGatewayTotalTimeout      = time.Hour
+)
+
 // A helper function to clean up a set of headers:
 // 1. Canonicalizes.
 // 2. Deduplicates.
@@ -41,7 +55,53 @@ func cleanHeaderSet(headers []string) []string {
 	return result
 }
 
+// GatewayState is the state shared by all the gateways of a node: the denylist
+// of Gateway.Denylists and the cache of Gateway.Cache. It is built once by
+// NewGatewayState and passed to the gateway options.
+type GatewayState struct {
+	denylist *denylist
+	cache    *gatewayCache
+	stop     func()
+}
+
+// NewGatewayState loads the denylist and opens the cache configured for the
+// node. The denylist files are watched for changes until Close is called.
+func NewGatewayState(n *core.IpfsNode) (*GatewayState, error) {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	denylist, stop, err := nodeDenylist(n, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := nodeGatewayCache(n, cfg)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	return &GatewayState{denylist: denylist, cache: cache, stop: stop}, nil
+}
+
+// Close stops watching the denylist files.
+func (s *GatewayState) Close() error {
+	s.stop()
+	return nil
+}
+
+// GatewayOption serves the gateway on the paths, without the denylist and the
+// cache of a GatewayState.
 func GatewayOption(writable bool, paths ...string) ServeOption {
+	return gatewayOption(nil, writable, paths)
+}
+
+// GatewayOption serves the gateway on the paths, with the denylist and the
+// cache of the state.
+func (s *GatewayState) GatewayOption(writable bool, paths ...string) ServeOption {
+	return gatewayOption(s, writable, paths)
+}
+
+func gatewayOption(state *GatewayState, writable bool, paths []string) ServeOption {
 	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
 		cfg, err := n.Repo.Config()
 		if err != nil {
@@ -88,13 +148,38 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 				"X-Stream-Output",
 			}, headers[ACEHeadersName]...))
 
-		var gateway http.Handler = newGatewayHandler(GatewayConfig{
-			Headers:      headers,
-			Writable:     writable,
-			PathPrefixes: cfg.Gateway.PathPrefixes,
+		if writable && cfg.Gateway.TrustlessOnly {
+			log.Warn("Gateway.TrustlessOnly is set, the gateway is not writable")
+			writable = false
+		}
+
+		gatewayHandler := newGatewayHandler(GatewayConfig{
+			Headers:       headers,
+			Writable:      writable,
+			PathPrefixes:  cfg.Gateway.PathPrefixes,
+			TrustlessOnly: cfg.Gateway.TrustlessOnly,
+
+			ResolutionTimeout: cfg.Gateway.Timeouts.Resolution.WithDefault(DefaultGatewayResolutionTimeout),
+			FirstBlockTimeout: cfg.Gateway.Timeouts.FirstBlock.WithDefault(DefaultGatewayFirstBlockTimeout),
+			TotalTimeout:      cfg.Gateway.Timeouts.Total.WithDefault(DefaultGatewayTotalTimeout),
 		}, api)
+		gatewayHandler.routing = n.Routing
+		if state != nil {
+			gatewayHandler.denylist = state.denylist
+			gatewayHandler.cache = state.cache
+		} else if len(cfg.Gateway.Denylists) > 0 {
+			log.Warn("Gateway.Denylists is set, but the gateway is served without its denylist")
+		}
+
+		var gateway http.Handler = otelhttp.NewHandler(gatewayHandler, "Gateway.Request")
 
-		gateway = otelhttp.NewHandler(gateway, "Gateway.Request")
+		limiter, err := newRateLimiter(cfg.Gateway.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		if limiter != nil {
+			gateway = limiter.wrap(gateway)
+		}
 
 		for _, p := range paths {
 			mux.Handle(p+"/", gateway)
@@ -0,0 +1,350 @@
+package corehttp
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	config "github.com/ipfs/go-ipfs/config"
+	core "github.com/ipfs/go-ipfs/core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Default values of Gateway.Cache
+const (
+	DefaultGatewayCacheDir     = "gateway-cache"
+	DefaultGatewayCacheIPNSTTL = time.Minute
+)
+
+// the approximate memory used by a cache entry, on top of its key and value
+const gatewayCacheEntryOverhead = 128
+
+// files of the on-disk cache, which may share its directory with other files
+const gatewayCacheFileSuffix = ".listing"
+
+// gatewayCache caches resolved paths and rendered directory listings, so that
+// popular paths are not resolved again on every request. Paths in the
+// immutable /ipfs/ namespace are cached until evicted, /ipns/ paths only for
+// IPNSTTL. Listings are keyed by the CID of the directory and by what their
+// HTML depends on: the content path, the URL path, the gateway hostname and
+// the hash of the assets. As the CID is resolved first, a listing of an /ipns/
+// path is never served for a previous version of the directory.
+//
+// Entries are evicted in least recently used order once the memory budget is
+// reached. Listings are also written to disk, when it has a budget, where they
+// survive eviction from memory and restarts.
+type gatewayCache struct {
+	ipnsTTL time.Duration
+	now     func() time.Time
+
+	lk      sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List // front is the most recently used
+	entries map[string]*list.Element
+
+	disk *gatewayDiskCache
+
+	hitMetric  *prometheus.CounterVec
+	missMetric *prometheus.CounterVec
+}
+
+type gatewayCacheEntry struct {
+	key     string
+	value   interface{}
+	size    int64
+	expires time.Time // zero for entries that don't expire
+}
+
+// nodeGatewayCache opens the cache of Gateway.Cache. It returns nil when
+// Gateway.Cache.MaxMemory is not set.
+func nodeGatewayCache(n *core.IpfsNode, cfg *config.Config) (*gatewayCache, error) {
+	maxMemory, err := parseCacheSize("Gateway.Cache.MaxMemory", cfg.Gateway.Cache.MaxMemory)
+	if err != nil || maxMemory == 0 {
+		return nil, err
+	}
+	maxDisk, err := parseCacheSize("Gateway.Cache.MaxDisk", cfg.Gateway.Cache.MaxDisk)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newGatewayCache(maxMemory, cfg.Gateway.Cache.IPNSTTL.WithDefault(DefaultGatewayCacheIPNSTTL))
+	if maxDisk > 0 {
+		dir, err := repoRelativePath(n, cfg.Gateway.Cache.Dir.WithDefault(DefaultGatewayCacheDir))
+		if err != nil {
+			return nil, fmt.Errorf("Gateway.Cache.Dir: %w", err)
+		}
+		if c.disk, err = newGatewayDiskCache(dir, maxDisk); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func parseCacheSize(name string, s *config.OptionalString) (int64, error) {
+	v := s.WithDefault("")
+	if v == "" {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return int64(size), nil
+}
+
+func newGatewayCache(maxSize int64, ipnsTTL time.Duration) *gatewayCache {
+	return &gatewayCache{
+		ipnsTTL: ipnsTTL,
+		now:     time.Now,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		hitMetric: newGatewayCounterMetric(
+			"gw_cache_hits_total",
+			"The number of gateway cache hits, by kind of entry (path or listing).",
+			"kind",
+		),
+		missMetric: newGatewayCounterMetric(
+			"gw_cache_misses_total",
+			"The number of gateway cache misses, by kind of entry (path or listing).",
+			"kind",
+		),
+	}
+}
+
+// resolvedPath returns the cached resolution of p
+func (c *gatewayCache) resolvedPath(p ipath.Path) (ipath.Resolved, bool) {
+	if c == nil {
+		return nil, false
+	}
+	v, ok := c.get("path:" + p.String())
+	if !ok {
+		c.missMetric.WithLabelValues("path").Inc()
+		return nil, false
+	}
+	c.hitMetric.WithLabelValues("path").Inc()
+	return v.(ipath.Resolved), true
+}
+
+// addResolvedPath caches the resolution of p, for IPNSTTL when p is mutable
+func (c *gatewayCache) addResolvedPath(p ipath.Path, resolved ipath.Resolved) {
+	if c == nil {
+		return
+	}
+	var expires time.Time
+	if p.Mutable() {
+		if c.ipnsTTL <= 0 {
+			return
+		}
+		expires = c.now().Add(c.ipnsTTL)
+	}
+	key := "path:" + p.String()
+	c.add(key, resolved, int64(len(key)+len(resolved.String())), expires)
+}
+
+// listing returns the cached rendering of a directory listing
+func (c *gatewayCache) listing(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key = "listing:" + key
+	if v, ok := c.get(key); ok {
+		c.hitMetric.WithLabelValues("listing").Inc()
+		return v.([]byte), true
+	}
+	if data, ok := c.disk.get(key); ok {
+		c.add(key, data, int64(len(key)+len(data)), time.Time{})
+		c.hitMetric.WithLabelValues("listing").Inc()
+		return data, true
+	}
+	c.missMetric.WithLabelValues("listing").Inc()
+	return nil, false
+}
+
+// addListing caches the rendering of a directory listing
+func (c *gatewayCache) addListing(key string, data []byte) {
+	if c == nil {
+		return
+	}
+	key = "listing:" + key
+	c.add(key, data, int64(len(key)+len(data)), time.Time{})
+	c.disk.add(key, data)
+}
+
+func (c *gatewayCache) get(key string) (interface{}, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*gatewayCacheEntry)
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *gatewayCache) add(key string, value interface{}, size int64, expires time.Time) {
+	size += gatewayCacheEntryOverhead
+	if size > c.maxSize {
+		return
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&gatewayCacheEntry{key: key, value: value, size: size, expires: expires})
+	c.size += size
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *gatewayCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*gatewayCacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// gatewayDiskCache stores directory listings in files named after the hash of
+// their key, and removes the least recently used ones once over budget.
+type gatewayDiskCache struct {
+	dir     string
+	maxSize int64
+
+	lk      sync.Mutex
+	size    int64
+	lru     *list.List // of *gatewayDiskEntry, front is the most recently used
+	entries map[string]*list.Element
+}
+
+type gatewayDiskEntry struct {
+	name string
+	size int64
+}
+
+// newGatewayDiskCache indexes the files left in dir by a previous run, from
+// the most recently modified
+func newGatewayDiskCache(dir string, maxSize int64) (*gatewayDiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &gatewayDiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().After(infos[j].ModTime()) })
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), gatewayCacheFileSuffix) {
+			continue
+		}
+		d.entries[info.Name()] = d.lru.PushBack(&gatewayDiskEntry{name: info.Name(), size: info.Size()})
+		d.size += info.Size()
+	}
+	d.lk.Lock()
+	d.evict()
+	d.lk.Unlock()
+	return d, nil
+}
+
+func gatewayDiskCacheName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + gatewayCacheFileSuffix
+}
+
+func (d *gatewayDiskCache) get(key string) ([]byte, bool) {
+	if d == nil {
+		return nil, false
+	}
+	name := gatewayDiskCacheName(key)
+	d.lk.Lock()
+	el, ok := d.entries[name]
+	if ok {
+		d.lru.MoveToFront(el)
+	}
+	d.lk.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(d.dir, name))
+	if err != nil {
+		// evicted in the meantime
+		return nil, false
+	}
+	return data, true
+}
+
+func (d *gatewayDiskCache) add(key string, data []byte) {
+	if d == nil || int64(len(data)) > d.maxSize {
+		return
+	}
+	name := gatewayDiskCacheName(key)
+	d.lk.Lock()
+	_, ok := d.entries[name]
+	d.lk.Unlock()
+	if ok {
+		return
+	}
+
+	// write and rename, so that readers never see partial files
+	tmp, err := ioutil.TempFile(d.dir, ".tmp-")
+	if err != nil {
+		log.Warnf("gateway cache: %s", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(d.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Warnf("gateway cache: %s", err)
+		return
+	}
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if _, ok := d.entries[name]; ok {
+		return
+	}
+	d.entries[name] = d.lru.PushFront(&gatewayDiskEntry{name: name, size: int64(len(data))})
+	d.size += int64(len(data))
+	d.evict()
+}
+
+// evict removes the least recently used files until the cache is within
+// budget, it has to be called with the lock held
+func (d *gatewayDiskCache) evict() {
+	for d.size > d.maxSize {
+		e := d.lru.Remove(d.lru.Back()).(*gatewayDiskEntry)
+		delete(d.entries, e.name)
+		d.size -= e.size
+		if err := os.Remove(filepath.Join(d.dir, e.name)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("gateway cache: %s", err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package corehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	path "github.com/ipfs/go-path"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGatewayCacheEviction(t *testing.T) {
+	c := newGatewayCache(3*(gatewayCacheEntryOverhead+100), time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	listing := bytes.Repeat([]byte("a"), 100-len("listing:k0"))
+	for _, k := range []string{"k0", "k1", "k2"} {
+		c.addListing(k, listing)
+	}
+	// k0 becomes the most recently used
+	if _, ok := c.listing("k0"); !ok {
+		t.Fatal("expected k0 to be cached")
+	}
+	c.addListing("k3", listing)
+	if _, ok := c.listing("k1"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	for _, k := range []string{"k0", "k2", "k3"} {
+		if _, ok := c.listing(k); !ok {
+			t.Errorf("expected %s to be cached", k)
+		}
+	}
+	if c.size > c.maxSize {
+		t.Errorf("cache size %d over budget %d", c.size, c.maxSize)
+	}
+
+	// entries over budget are not cached
+	c.addListing("big", bytes.Repeat([]byte("a"), int(c.maxSize)))
+	if _, ok := c.listing("big"); ok {
+		t.Error("expected an entry over budget not to be cached")
+	}
+
+	// /ipns/ paths expire
+	c0, err := cid.Decode("bafkqaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved := ipath.IpfsPath(c0)
+	ipfsPath, ipnsPath := ipath.New("/ipfs/"+resolved.Cid().String()+"/a"), ipath.New("/ipns/example.com/a")
+	c.addResolvedPath(ipfsPath, resolved)
+	c.addResolvedPath(ipnsPath, resolved)
+	if r, ok := c.resolvedPath(ipnsPath); !ok || r.String() != resolved.String() {
+		t.Fatal("expected the /ipns/ path to be cached")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.resolvedPath(ipnsPath); ok {
+		t.Error("expected the /ipns/ path to expire")
+	}
+	if _, ok := c.resolvedPath(ipfsPath); !ok {
+		t.Error("expected the /ipfs/ path not to expire")
+	}
+
+	// nil caches are disabled
+	var disabled *gatewayCache
+	disabled.addResolvedPath(ipfsPath, resolved)
+	if _, ok := disabled.resolvedPath(ipfsPath); ok {
+		t.Error("expected a nil cache to be empty")
+	}
+}
+
+func TestGatewayDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "unrelated"), []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newGatewayDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.add("a", []byte("aaaa"))
+	d.add("b", []byte("bbbb"))
+	if data, ok := d.get("a"); !ok || string(data) != "aaaa" {
+		t.Fatalf("unexpected entry %q", data)
+	}
+	d.add("c", []byte("cccc"))
+	if _, ok := d.get("b"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+
+	// the entries survive restarts, within budget
+	d, err = newGatewayDiskCache(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.size != 4 || len(d.entries) != 1 {
+		t.Errorf("expected a single entry after restart, got %d bytes in %d entries", d.size, len(d.entries))
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "unrelated")); err != nil {
+		t.Errorf("expected unrelated files to be kept: %s", err)
+	}
+}
+
+func TestGatewayDiskCacheRepoDir(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	dir := t.TempDir()
+	n.Repo = pathRepo{Repo: n.Repo, path: dir}
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"MaxMemory": "1MB", "MaxDisk": "1MB", "Dir": "cache"}`), &cfg.Gateway.Cache); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := nodeGatewayCache(n, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "cache"); c.disk.dir != expected {
+		t.Errorf("expected the disk cache in %s, got %s", expected, c.disk.dir)
+	}
+}
+
+func TestGatewayCache(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("first")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("second")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := api.Unixfs().Add(n.Context(), files.NewMapDirectory(map[string]files.Node{
+		"file.txt": files.NewBytesFile([]byte("file")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.com"] = path.FromString(first.String())
+
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"MaxMemory": "1MiB", "IPNSTTL": "1h"}`), &cfg.Gateway.Cache); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	state, err := NewGatewayState(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { state.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, state.HostnameOption(), state.GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(urlPath string) string {
+		res, err := http.Get(ts.URL + urlPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d: %s", urlPath, res.StatusCode, body)
+		}
+		return string(body)
+	}
+
+	c := state.cache
+	pathHits := testutil.ToFloat64(c.hitMetric.WithLabelValues("path"))
+	listingHits := testutil.ToFloat64(c.hitMetric.WithLabelValues("listing"))
+
+	if body := get("/ipns/example.com"); body != "first" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	// the name is not resolved again within the TTL
+	ns["/ipns/example.com"] = path.FromString(second.String())
+	if body := get("/ipns/example.com"); body != "first" {
+		t.Fatalf("expected the cached resolution, got %q", body)
+	}
+	if hits := testutil.ToFloat64(c.hitMetric.WithLabelValues("path")) - pathHits; hits != 1 {
+		t.Errorf("expected 1 path hit, got %v", hits)
+	}
+
+	listing := get(dir.String() + "/")
+	if !strings.Contains(listing, "file.txt") {
+		t.Fatalf("unexpected listing %q", listing)
+	}
+	if cached := get(dir.String() + "/"); cached != listing {
+		t.Error("expected the cached listing to be identical")
+	}
+	if hits := testutil.ToFloat64(c.hitMetric.WithLabelValues("listing")) - listingHits; hits != 1 {
+		t.Errorf("expected 1 listing hit, got %v", hits)
+	}
+}
@@ -65,8 +65,11 @@ type redirectTemplateData struct {
 // gatewayHandler is a HTTP handler that serves IPFS objects (accessible by default at /ipfs/<path>)
 // (it serves requests like GET /ipfs/QmVRzPKPzNtSrEzBFm2UZfxmPAgnaLke4DMcerbsGGSaFe/link)
 type gatewayHandler struct {
-	config GatewayConfig
-	api    coreiface.CoreAPI
+	config   GatewayConfig
+	api      coreiface.CoreAPI
+	routing  routing.ValueStore
+	denylist *denylist
+	cache    *gatewayCache
 
 	// generic metrics
 	firstContentBlockGetMetric *prometheus.HistogramVec
@@ -77,6 +80,9 @@ type gatewayHandler struct {
 	unixfsGenDirGetMetric *prometheus.HistogramVec
 	carStreamGetMetric    *prometheus.HistogramVec
 	rawBlockGetMetric     *prometheus.HistogramVec
+	codecGetMetric        *prometheus.HistogramVec
+	ipnsRecordGetMetric   *prometheus.HistogramVec
+	tarStreamGetMetric    *prometheus.HistogramVec
 }
 
 // StatusResponseWriter enables us to override HTTP Status Code passed to
@@ -186,7 +192,7 @@ func newGatewaySummaryMetric(name string, help string) *prometheus.SummaryVec {
 	return summaryMetric
 }
 
-func newGatewayHistogramMetric(name string, help string) *prometheus.HistogramVec {
+func newGatewayHistogramMetric(name string, help string, labels ...string) *prometheus.HistogramVec {
 	// We can add buckets as a parameter in the future, but for now using static defaults
 	// suggested in https://github.com/ipfs/go-ipfs/issues/8441
 	defaultBuckets := []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60}
@@ -198,7 +204,7 @@ func newGatewayHistogramMetric(name string, help string) *prometheus.HistogramVe
 			Help:      help,
 			Buckets:   defaultBuckets,
 		},
-		[]string{"gateway"},
+		append([]string{"gateway"}, labels...),
 	)
 	if err := prometheus.Register(histogramMetric); err != nil {
 		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
@@ -210,6 +216,45 @@ func newGatewayHistogramMetric(name string, help string) *prometheus.HistogramVe
 	return histogramMetric
 }
 
+func newGatewayCounterMetric(name string, help string, labels ...string) *prometheus.CounterVec {
+	counterMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "http",
+			Name:      name,
+			Help:      help,
+		},
+		labels,
+	)
+	if err := prometheus.Register(counterMetric); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			counterMetric = are.ExistingCollector.(*prometheus.CounterVec)
+		} else {
+			log.Errorf("failed to register ipfs_http_%s: %v", name, err)
+		}
+	}
+	return counterMetric
+}
+
+func newGatewayGaugeMetric(name string, help string) prometheus.Gauge {
+	gaugeMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "ipfs",
+			Subsystem: "http",
+			Name:      name,
+			Help:      help,
+		},
+	)
+	if err := prometheus.Register(gaugeMetric); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			gaugeMetric = are.ExistingCollector.(prometheus.Gauge)
+		} else {
+			log.Errorf("failed to register ipfs_http_%s: %v", name, err)
+		}
+	}
+	return gaugeMetric
+}
+
 func newGatewayHandler(c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
 	i := &gatewayHandler{
 		config: c,
@@ -220,7 +265,8 @@ func newGatewayHandler(c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
 		// (format-agnostic, across all response types)
 		firstContentBlockGetMetric: newGatewayHistogramMetric(
 			"gw_first_content_block_get_latency_seconds",
-			"The time till the first content block is received on GET from the gateway.",
+			"The time till the first content block is received on GET from the gateway, by outcome (ok, timeout or error).",
+			"outcome",
 		),
 
 		// Response-type specific metrics
@@ -245,6 +291,21 @@ func newGatewayHandler(c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
 			"gw_raw_block_get_duration_seconds",
 			"The time to GET an entire raw Block from the gateway.",
 		),
+		// Codec: time it takes to return a dag-json or dag-cbor node
+		codecGetMetric: newGatewayHistogramMetric(
+			"gw_codec_get_duration_seconds",
+			"The time to GET a dag-json, dag-cbor, json or cbor node from the gateway.",
+		),
+		// IPNS Record: time it takes to return a signed IPNS record
+		ipnsRecordGetMetric: newGatewayHistogramMetric(
+			"gw_ipns_record_get_duration_seconds",
+			"The time to GET a signed IPNS record from the gateway.",
+		),
+		// TAR: time it takes to return a TAR stream of a UnixFS directory
+		tarStreamGetMetric: newGatewayHistogramMetric(
+			"gw_tar_stream_get_duration_seconds",
+			"The time to GET an entire TAR stream from the gateway.",
+		),
 
 		// Legacy Metrics
 		// ----------------------------
@@ -278,8 +339,8 @@ func parseIpfsPath(p string) (cid.Cid, string, error) {
 }
 
 func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// the hour is a hard fallback, we don't expect it to happen, but just in case
-	ctx, cancel := context.WithTimeout(r.Context(), time.Hour)
+	// Gateway.Timeouts.Total bounds the whole request, including the response
+	ctx, cancel := withTimeout(r.Context(), i.config.TotalTimeout)
 	defer cancel()
 	r = r.WithContext(ctx)
 
@@ -358,19 +419,67 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if err := i.handleTrustlessOnly(r); err != nil {
+		webRequestError(w, err)
+		return
+	}
+
 	contentPath := ipath.New(r.URL.Path)
 	if requestHandled := handleSuperfluousNamespace(w, r, contentPath); requestHandled {
 		return
 	}
 
+	if i.denylist.serveIfDenied(w, contentPath.String()) {
+		return
+	}
+
+	// Detect when explicit Accept header or ?format parameter are present
+	responseFormat, formatParams, err := customResponseFormat(r)
+	if err != nil {
+		webError(w, "error while processing the Accept header", err, http.StatusBadRequest)
+		return
+	}
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ResponseFormat", responseFormat))
+
+	// IPNS records are served as-is, without resolving the name
+	if responseFormat == "application/vnd.ipfs.ipns-record" {
+		logger.Debugw("serving ipns record", "path", contentPath)
+		i.serveIpnsRecord(r.Context(), w, r, contentPath, begin)
+		return
+	}
+
 	// Resolve path to the final DAG node for the ETag
-	resolvedPath, err := i.api.ResolvePath(r.Context(), contentPath)
+	resolvedPath, err := i.resolvePath(r.Context(), contentPath)
 	switch err {
 	case nil:
 	case coreiface.ErrOffline:
 		webError(w, "ipfs resolve -r "+debugStr(contentPath.String()), err, http.StatusServiceUnavailable)
 		return
 	default:
+		// deadlines of Gateway.Timeouts
+		if _, ok := err.(*gatewayTimeoutError); ok {
+			webError(w, "ipfs resolve -r "+debugStr(contentPath.String()), err, http.StatusGatewayTimeout)
+			return
+		}
+
+		// verifiable responses have no pretty errors
+		if i.config.TrustlessOnly {
+			webError(w, "ipfs resolve -r "+debugStr(contentPath.String()), err, http.StatusNotFound)
+			return
+		}
+
+		// websites on subdomain and DNSLink origins can define their own
+		// redirects and rewrites for missing paths in a _redirects file
+		if rewrittenPath, handled := i.handleRedirectsFile(w, r, contentPath, logger); handled {
+			return
+		} else if rewrittenPath != nil {
+			if rewrittenResolved, rewriteErr := i.resolvePath(r.Context(), rewrittenPath); rewriteErr == nil {
+				logger.Debugw("serving _redirects rewrite", "path", rewrittenPath)
+				resolvedPath, contentPath = rewrittenResolved, rewrittenPath
+				break
+			}
+		}
+
 		// if Accept is text/html, see if ipfs-404.html is present
 		if i.servePretty404IfPresent(w, r, contentPath) {
 			logger.Debugw("serve pretty 404 if present")
@@ -381,18 +490,25 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Detect when explicit Accept header or ?format parameter are present
-	responseFormat, formatParams, err := customResponseFormat(r)
-	if err != nil {
-		webError(w, "error while processing the Accept header", err, http.StatusBadRequest)
+	// Match the denylist again after resolution, to catch denied content
+	// reached through IPNS, DNSLink, a _redirects rewrite or a path from
+	// another CID
+	deniedPaths := append([]string{contentPath.String(), resolvedPath.String(), ipfsPathPrefix + resolvedPath.Cid().String()}, i.intermediatePaths(r.Context(), contentPath)...)
+	if i.denylist.serveIfDenied(w, deniedPaths...) {
 		return
 	}
-	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ResponseFormat", responseFormat))
+
 	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ResolvedPath", resolvedPath.String()))
 
 	// Finish early if client already has matching Etag
 	ifNoneMatch := r.Header.Get("If-None-Match")
-	if ifNoneMatch == getEtag(r, resolvedPath.Cid()) || ifNoneMatch == getDirListingEtag(resolvedPath.Cid()) {
+	if responseFormat == "application/vnd.ipld.car" {
+		// CARs have their own Etag, which depends on the CAR parameters
+		if etag, ok := requestCarEtag(r, resolvedPath, formatParams["version"]); ok && (ifNoneMatch == etag || ifNoneMatch == `W/`+etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if ifNoneMatch == getEtag(r, resolvedPath.Cid()) || ifNoneMatch == getDirListingEtag(resolvedPath.Cid()) {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
@@ -410,6 +526,11 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 	// Support custom response formats passed via ?format or Accept HTTP header
 	switch responseFormat {
 	case "": // The implicit response format is UnixFS
+		if isServedAsCodec(resolvedPath.Cid()) {
+			logger.Debugw("serving codec", "path", contentPath)
+			i.serveCodec(r.Context(), w, r, resolvedPath, contentPath, begin, responseFormat)
+			return
+		}
 		logger.Debugw("serving unixfs", "path", contentPath)
 		i.serveUnixFS(r.Context(), w, r, resolvedPath, contentPath, begin, logger)
 		return
@@ -422,6 +543,30 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		carVersion := formatParams["version"]
 		i.serveCAR(r.Context(), w, r, resolvedPath, contentPath, carVersion, begin)
 		return
+	case "application/json", "application/cbor":
+		if responseFormat == "application/json" && i.isUnixFSDirectory(r.Context(), resolvedPath) {
+			logger.Debugw("serving unixfs directory listing", "path", contentPath)
+			i.serveDirectoryJSON(r.Context(), w, r, resolvedPath, contentPath, begin, logger)
+			return
+		}
+		// Plain JSON and CBOR in the Accept header do not override UnixFS, as
+		// a UnixFS file may already be a JSON or CBOR document
+		if !isServedAsCodec(resolvedPath.Cid()) && r.URL.Query().Get("format") == "" {
+			logger.Debugw("serving unixfs", "path", contentPath)
+			i.serveUnixFS(r.Context(), w, r, resolvedPath, contentPath, begin, logger)
+			return
+		}
+		logger.Debugw("serving codec", "path", contentPath)
+		i.serveCodec(r.Context(), w, r, resolvedPath, contentPath, begin, responseFormat)
+		return
+	case "application/vnd.ipld.dag-json", "application/vnd.ipld.dag-cbor":
+		logger.Debugw("serving codec", "path", contentPath)
+		i.serveCodec(r.Context(), w, r, resolvedPath, contentPath, begin, responseFormat)
+		return
+	case "application/x-tar":
+		logger.Debugw("serving tar stream", "path", contentPath)
+		i.serveTAR(r.Context(), w, r, resolvedPath, contentPath, begin)
+		return
 	default: // catch-all for unsuported application/vnd.*
 		err := fmt.Errorf("unsupported format %q", responseFormat)
 		webError(w, "failed respond with requested content type", err, http.StatusBadRequest)
@@ -762,8 +907,10 @@ func webError(w http.ResponseWriter, message string, err error, defaultCode int)
 		webErrorWithCode(w, message, err, http.StatusNotFound)
 	} else if err == routing.ErrNotFound {
 		webErrorWithCode(w, message, err, http.StatusNotFound)
+	} else if _, ok := err.(*gatewayTimeoutError); ok {
+		webErrorWithCode(w, message, err, http.StatusGatewayTimeout)
 	} else if err == context.DeadlineExceeded {
-		webErrorWithCode(w, message, err, http.StatusRequestTimeout)
+		webErrorWithCode(w, message, err, http.StatusGatewayTimeout)
 	} else {
 		webErrorWithCode(w, message, err, defaultCode)
 	}
@@ -796,12 +943,12 @@ func getEtag(r *http.Request, cid cid.Cid) string {
 	suffix := `"`
 	responseFormat, _, err := customResponseFormat(r)
 	if err == nil && responseFormat != "" {
-		// application/vnd.ipld.foo → foo
-		f := responseFormat[strings.LastIndex(responseFormat, ".")+1:]
+		// application/vnd.ipld.foo → foo, application/json → json
+		f := responseFormat[strings.LastIndexAny(responseFormat, "/.")+1:]
 		// Etag: "cid.foo" (gives us nice compression together with Content-Disposition in block (raw) and car responses)
 		suffix = `.` + f + suffix
 	}
-	// TODO: include selector suffix when https://github.com/ipfs/go-ipfs/issues/8769 lands
+	// CAR responses with a DAG scope or selector get a further suffix in getCarEtag
 	return prefix + cid.String() + suffix
 }
 
@@ -814,19 +961,39 @@ func customResponseFormat(r *http.Request) (mediaType string, params map[string]
 			return "application/vnd.ipld.raw", nil, nil
 		case "car":
 			return "application/vnd.ipld.car", nil, nil
+		case "dag-json":
+			return "application/vnd.ipld.dag-json", nil, nil
+		case "dag-cbor":
+			return "application/vnd.ipld.dag-cbor", nil, nil
+		case "json":
+			return "application/json", nil, nil
+		case "cbor":
+			return "application/cbor", nil, nil
+		case "ipns-record":
+			return "application/vnd.ipfs.ipns-record", nil, nil
+		case "tar":
+			return "application/x-tar", nil, nil
 		}
 	}
 	// Browsers and other user agents will send Accept header with generic types like:
 	// Accept:text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8
-	// We only care about explciit, vendor-specific content-types.
-	for _, accept := range r.Header.Values("Accept") {
-		// respond to the very first ipld content type
-		if strings.HasPrefix(accept, "application/vnd.ipld") {
-			mediatype, params, err := mime.ParseMediaType(accept)
-			if err != nil {
-				return "", nil, err
+	// We only care about explciit, vendor-specific content-types, plain
+	// JSON and CBOR, and TAR.
+	for _, header := range r.Header.Values("Accept") {
+		for _, accept := range strings.Split(header, ",") {
+			accept = strings.TrimSpace(accept)
+			// respond to the very first ipld content type
+			if strings.HasPrefix(accept, "application/vnd.ipld") ||
+				strings.HasPrefix(accept, "application/vnd.ipfs.ipns-record") ||
+				strings.HasPrefix(accept, "application/json") ||
+				strings.HasPrefix(accept, "application/cbor") ||
+				strings.HasPrefix(accept, "application/x-tar") {
+				mediatype, params, err := mime.ParseMediaType(accept)
+				if err != nil {
+					return "", nil, err
+				}
+				return mediatype, params, nil
 			}
-			return mediatype, params, nil
 		}
 	}
 	return "", nil, nil
@@ -922,6 +1089,25 @@ func handleProtocolHandlerRedirect(w http.ResponseWriter, r *http.Request, logge
 	return false
 }
 
+// In Gateway.TrustlessOnly mode, reject requests for response formats which
+// can't be verified by the client, such as deserialized UnixFS or HTML
+func (i *gatewayHandler) handleTrustlessOnly(r *http.Request) *requestError {
+	if !i.config.TrustlessOnly {
+		return nil
+	}
+	responseFormat, _, err := customResponseFormat(r)
+	if err != nil {
+		return newRequestError("error while processing the Accept header", err, http.StatusBadRequest)
+	}
+	switch responseFormat {
+	case "application/vnd.ipld.raw", "application/vnd.ipld.car", "application/vnd.ipfs.ipns-record":
+		return nil
+	default:
+		err := fmt.Errorf("only application/vnd.ipld.raw, application/vnd.ipld.car and application/vnd.ipfs.ipns-record responses are supported")
+		return newRequestError("trustless gateway", err, http.StatusNotAcceptable)
+	}
+}
+
 // Disallow Service Worker registration on namespace roots
 // https://github.com/ipfs/go-ipfs/issues/4025
 func handleServiceWorkerRegistration(r *http.Request) (err *requestError) {
@@ -983,14 +1169,21 @@ func handleSuperfluousNamespace(w http.ResponseWriter, r *http.Request, contentP
 func (i *gatewayHandler) handleGettingFirstBlock(r *http.Request, begin time.Time, contentPath ipath.Path, resolvedPath ipath.Resolved) *requestError {
 	// Update the global metric of the time it takes to read the final root block of the requested resource
 	// NOTE: for legacy reasons this happens before we go into content-type specific code paths
-	_, err := i.api.Block().Get(r.Context(), resolvedPath)
+	ctx, cancel := withTimeout(r.Context(), i.config.FirstBlockTimeout)
+	defer cancel()
+	_, err := i.api.Block().Get(ctx, resolvedPath)
+	ns := contentPath.Namespace()
+	timeToGetFirstContentBlock := time.Since(begin).Seconds()
 	if err != nil {
+		if terr := i.timeoutError(r.Context(), ctx, "FirstBlock", i.config.FirstBlockTimeout); terr != nil {
+			i.firstContentBlockGetMetric.WithLabelValues(ns, "timeout").Observe(timeToGetFirstContentBlock)
+			return newRequestError("ipfs block get "+resolvedPath.Cid().String(), terr, http.StatusGatewayTimeout)
+		}
+		i.firstContentBlockGetMetric.WithLabelValues(ns, "error").Observe(timeToGetFirstContentBlock)
 		return newRequestError("ipfs block get "+resolvedPath.Cid().String(), err, http.StatusInternalServerError)
 	}
-	ns := contentPath.Namespace()
-	timeToGetFirstContentBlock := time.Since(begin).Seconds()
 	i.unixfsGetMetric.WithLabelValues(ns).Observe(timeToGetFirstContentBlock) // deprecated, use firstContentBlockGetMetric instead
-	i.firstContentBlockGetMetric.WithLabelValues(ns).Observe(timeToGetFirstContentBlock)
+	i.firstContentBlockGetMetric.WithLabelValues(ns, "ok").Observe(timeToGetFirstContentBlock)
 	return nil
 }
 
@@ -3,21 +3,32 @@ package corehttp
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/cespare/xxhash"
 	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
 	"github.com/ipfs/go-ipfs/tracing"
+	ipfspath "github.com/ipfs/go-path"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
 	gocar "github.com/ipld/go-car"
-	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	carutil "github.com/ipld/go-car/util"
+	carv2 "github.com/ipld/go-car/v2"
+	carindex "github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
 
-// serveCAR returns a CAR stream for specific DAG+selector
+// serveCAR returns a CAR stream for specific DAG+selector.
+// The dag-scope, entity-bytes and selector query parameters limit the
+// response to a subset of the DAG behind the terminal element of the path,
+// and skip-blocks resumes an interrupted download.
 func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, carVersion string, begin time.Time) {
 	ctx, span := tracing.Span(ctx, "Gateway", "ServeCAR", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
 	defer span.End()
@@ -25,22 +36,38 @@ func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r
 	defer cancel()
 
 	switch carVersion {
-	case "": // noop, client does not care about version
-	case "1": // noop, we support this
+	case "": // client does not care about version, default to CARv1
+		carVersion = "1"
+	case "1", "2": // noop, we support these
 	default:
-		err := fmt.Errorf("only version=1 is supported")
+		err := fmt.Errorf("only version=1 and version=2 are supported")
 		webError(w, "unsupported CAR version", err, http.StatusBadRequest)
 		return
 	}
-	rootCid := resolvedPath.Cid()
+	params, err := getCarParams(r)
+	if err != nil {
+		webError(w, "invalid CAR request parameters", err, http.StatusBadRequest)
+		return
+	}
+	if carVersion == "2" && params.skipBlocks > 0 {
+		err := fmt.Errorf("skip-blocks is only supported with version=1")
+		webError(w, "invalid CAR request parameters", err, http.StatusBadRequest)
+		return
+	}
+	terminalCid := resolvedPath.Cid()
+
+	// Path-scoped CAR starts at the root of the content path, and includes
+	// the blocks required for resolving the path to the terminal element
+	rootCid := resolvedPath.Root()
+	segments := ipfspath.Path(resolvedPath.String()).Segments()[2:]
 
 	// Set Content-Disposition
-	name := rootCid.String() + ".car"
+	name := terminalCid.String() + ".car"
 	setContentDispositionHeader(w, name, "attachment")
 
 	// Weak Etag W/ because we can't guarantee byte-for-byte identical  responses
 	// (CAR is streamed, and in theory, blocks may arrive from datastore in non-deterministic order)
-	etag := `W/` + getEtag(r, rootCid)
+	etag := `W/` + getCarEtag(r, resolvedPath, carVersion, params)
 	w.Header().Set("Etag", etag)
 
 	// Finish early if Etag match
@@ -49,38 +76,153 @@ func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
-	// Make it clear we don't support range-requests over a car stream
-	// Partial downloads and resumes should be handled using
-	// IPLD selectors: https://github.com/ipfs/go-ipfs/issues/8769
+	// Make it clear we don't support range-requests over a car stream.
+	// Partial downloads are resumed with skip-blocks instead, which relies on
+	// the deterministic block order of the traversal, not on byte offsets.
 	w.Header().Set("Accept-Ranges", "none")
 
 	// Explicit Cache-Control to ensure fresh stream on retry.
 	// CAR stream could be interrupted, and client should be able to resume and get full response, not the truncated one
 	w.Header().Set("Cache-Control", "no-cache, no-transform")
 
-	w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+	w.Header().Set("Content-Type", "application/vnd.ipld.car; version="+carVersion)
 	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
 
-	// Same go-car settings as dag.export command
+	// Blocks are written in the order they are first visited by the traversal
 	store := dagStore{dag: i.api.Dag(), ctx: ctx}
 
-	// TODO: support selectors passed as request param: https://github.com/ipfs/go-ipfs/issues/8769
-	dag := gocar.Dag{Root: rootCid, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
-	car := gocar.NewSelectiveCar(ctx, store, []gocar.Dag{dag}, gocar.TraverseLinksOnlyOnce())
-
-	if err := car.Write(w); err != nil {
-		// We return error as a trailer, however it is not something browsers can access
-		// (https://github.com/mdn/browser-compat-data/issues/14703)
-		// Due to this, we suggest client always verify that
-		// the received CAR stream response is matching requested DAG selector
-		w.Header().Set("X-Stream-Error", err.Error())
-		return
+	if carVersion == "2" {
+		if err := writeCarV2(ctx, w, store, rootCid, segments, params); err != nil {
+			w.Header().Set("X-Stream-Error", err.Error())
+			return
+		}
+	} else {
+		// When resuming, the client already has the header and the skipped
+		// blocks, so the response is the remainder of the full CAR stream
+		if params.skipBlocks == 0 {
+			if err := gocar.WriteHeader(&gocar.CarHeader{Roots: []cid.Cid{rootCid}, Version: 1}, w); err != nil {
+				w.Header().Set("X-Stream-Error", err.Error())
+				return
+			}
+		}
+		var skipped uint64
+		traversal := newCarTraversal(ctx, store, func(blk blocks.Block) error {
+			if skipped < params.skipBlocks {
+				skipped++
+				return nil
+			}
+			return carutil.LdWrite(w, blk.Cid().Bytes(), blk.RawData())
+		})
+		if err := traversal.walk(rootCid, segments, params); err != nil {
+			// We return error as a trailer, however it is not something browsers can access
+			// (https://github.com/mdn/browser-compat-data/issues/14703)
+			// Due to this, we suggest client always verify that
+			// the received CAR stream response is matching requested DAG selector
+			w.Header().Set("X-Stream-Error", err.Error())
+			return
+		}
 	}
 
 	// Update metrics
 	i.carStreamGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
 }
 
+// writeCarV2 writes a CARv2 with the traversal as its CARv1 payload and an
+// index of the blocks appended. The CARv2 header has to include the size of the
+// payload, so the first pass of the traversal only collects the CIDs and sizes
+// of the blocks, and the second pass writes them from the local blockstore,
+// where the first pass left them.
+func writeCarV2(ctx context.Context, w io.Writer, store dagStore, rootCid cid.Cid, segments []string, params carParams) error {
+	header := gocar.CarHeader{Roots: []cid.Cid{rootCid}, Version: 1}
+	dataSize, err := gocar.HeaderSize(&header)
+	if err != nil {
+		return err
+	}
+	var cids []cid.Cid
+	traversal := newCarTraversal(ctx, store, func(blk blocks.Block) error {
+		cids = append(cids, blk.Cid())
+		dataSize += carutil.LdSize(blk.Cid().Bytes(), blk.RawData())
+		return nil
+	})
+	if err := traversal.walk(rootCid, segments, params); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(carv2.Pragma); err != nil {
+		return err
+	}
+	if _, err := carv2.NewHeader(dataSize).WriteTo(w); err != nil {
+		return err
+	}
+	if err := gocar.WriteHeader(&header, w); err != nil {
+		return err
+	}
+	offset, err := gocar.HeaderSize(&header)
+	if err != nil {
+		return err
+	}
+	records := make([]carindex.Record, 0, len(cids))
+	for _, c := range cids {
+		blk, err := store.Get(c)
+		if err != nil {
+			return err
+		}
+		if err := carutil.LdWrite(w, c.Bytes(), blk.RawData()); err != nil {
+			return err
+		}
+		// same as go-car, identity CIDs carry their data and are not indexed
+		if c.Prefix().MhType != multihash.IDENTITY {
+			records = append(records, carindex.Record{Cid: c, Offset: offset})
+		}
+		offset += carutil.LdSize(c.Bytes(), blk.RawData())
+	}
+
+	idx, err := carindex.New(multicodec.CarMultihashIndexSorted)
+	if err != nil {
+		return err
+	}
+	if err := idx.Load(records); err != nil {
+		return err
+	}
+	_, err = carindex.WriteTo(idx, w)
+	return err
+}
+
+// getCarEtag returns the Etag of a CAR response. Responses for a subpath, for
+// CARv2 or with non-default parameters include a hash of those, as they
+// produce different CARs for the same terminal CID.
+func getCarEtag(r *http.Request, resolvedPath ipath.Resolved, carVersion string, params carParams) string {
+	etag := getEtag(r, resolvedPath.Cid())
+	suffix := params.etagSuffix()
+	if carVersion != "1" {
+		suffix = "version=" + carVersion + "&" + suffix
+	}
+	if resolvedPath.Root() != resolvedPath.Cid() || resolvedPath.Remainder() != "" {
+		suffix = resolvedPath.String() + "?" + suffix
+	}
+	if suffix == "" {
+		return etag
+	}
+	return fmt.Sprintf("%s.%x\"", strings.TrimSuffix(etag, `"`), xxhash.Sum64String(suffix))
+}
+
+// requestCarEtag returns the Etag of the CAR requested, if its parameters are
+// valid.
+func requestCarEtag(r *http.Request, resolvedPath ipath.Resolved, carVersion string) (string, bool) {
+	switch carVersion {
+	case "":
+		carVersion = "1"
+	case "1", "2":
+	default:
+		return "", false
+	}
+	params, err := getCarParams(r)
+	if err != nil {
+		return "", false
+	}
+	return getCarEtag(r, resolvedPath, carVersion, params), true
+}
+
 type dagStore struct {
 	dag coreiface.APIDagService
 	ctx context.Context
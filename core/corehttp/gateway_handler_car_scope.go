@@ -0,0 +1,464 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/ipfs/go-unixfsnode"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+// dagScope describes which part of the DAG behind the terminal element of
+// the requested content path is included in a CAR response.
+type dagScope string
+
+const (
+	// dagScopeAll includes the entire DAG behind the terminal element.
+	dagScopeAll dagScope = "all"
+	// dagScopeEntity includes the blocks needed to read the terminal
+	// element: all blocks of a UnixFS file, the shards of a HAMT directory,
+	// or the single block of anything else.
+	dagScopeEntity dagScope = "entity"
+	// dagScopeBlock includes only the root block of the terminal element.
+	dagScopeBlock dagScope = "block"
+)
+
+// carParams are the parameters of a CAR request passed via the URL query.
+type carParams struct {
+	// scope is the dag-scope applied to the terminal element of the path.
+	scope dagScope
+
+	// entityBytes limits a UnixFS file entity to the blocks holding the
+	// requested byte range. Only valid with the entity scope.
+	entityBytes *byteRange
+
+	// selector is an explicit IPLD selector applied from the terminal
+	// element instead of the dag-scope, if present.
+	selector ipld.Node
+
+	// rawSelector is the selector as passed by the client.
+	rawSelector string
+
+	// skipBlocks is the number of blocks at the start of the traversal to
+	// leave out of the response, allowing interrupted downloads to resume.
+	skipBlocks uint64
+}
+
+// byteRange is an inclusive range of bytes. Negative values are offsets
+// counted from the end of the file, and a nil to means "until the end".
+type byteRange struct {
+	from int64
+	to   *int64
+}
+
+// getCarParams parses the CAR-specific query parameters of the request.
+func getCarParams(r *http.Request) (carParams, error) {
+	q := r.URL.Query()
+	params := carParams{scope: dagScopeAll}
+
+	if s := q.Get("dag-scope"); s != "" {
+		switch dagScope(s) {
+		case dagScopeAll, dagScopeEntity, dagScopeBlock:
+			params.scope = dagScope(s)
+		default:
+			return carParams{}, fmt.Errorf("unsupported dag-scope %q, must be one of %q, %q or %q", s, dagScopeBlock, dagScopeEntity, dagScopeAll)
+		}
+	}
+
+	if s := q.Get("entity-bytes"); s != "" {
+		if params.scope != dagScopeEntity {
+			return carParams{}, fmt.Errorf("entity-bytes can only be used with dag-scope=%s", dagScopeEntity)
+		}
+		br, err := parseByteRange(s)
+		if err != nil {
+			return carParams{}, err
+		}
+		params.entityBytes = &br
+	}
+
+	if s := q.Get("selector"); s != "" {
+		if q.Get("dag-scope") != "" {
+			return carParams{}, fmt.Errorf("selector and dag-scope are mutually exclusive")
+		}
+		sel, err := parseSelector(s)
+		if err != nil {
+			return carParams{}, err
+		}
+		params.selector = sel
+		params.rawSelector = s
+	}
+
+	if s := q.Get("skip-blocks"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return carParams{}, fmt.Errorf("invalid skip-blocks %q: %w", s, err)
+		}
+		params.skipBlocks = n
+	}
+
+	return params, nil
+}
+
+// parseByteRange parses "from:to" where to may be "*" to read until the end.
+func parseByteRange(s string) (byteRange, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return byteRange{}, fmt.Errorf("invalid entity-bytes %q, expected from:to", s)
+	}
+	from, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return byteRange{}, fmt.Errorf("invalid entity-bytes %q: %w", s, err)
+	}
+	br := byteRange{from: from}
+	if parts[1] != "*" {
+		to, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return byteRange{}, fmt.Errorf("invalid entity-bytes %q: %w", s, err)
+		}
+		if from >= 0 && to >= 0 && to < from {
+			return byteRange{}, fmt.Errorf("invalid entity-bytes %q: to is lower than from", s)
+		}
+		br.to = &to
+	}
+	return br, nil
+}
+
+// resolve returns absolute, inclusive offsets for a file of the given size.
+func (br byteRange) resolve(size int64) (int64, int64) {
+	from, to := br.from, size-1
+	if from < 0 {
+		from = size + from
+		if from < 0 {
+			from = 0
+		}
+	}
+	if br.to != nil {
+		to = *br.to
+		if to < 0 {
+			to = size + to
+		}
+		if to > size-1 {
+			to = size - 1
+		}
+	}
+	return from, to
+}
+
+// parseSelector decodes a dag-json encoded IPLD selector.
+func parseSelector(s string) (ipld.Node, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, strings.NewReader(s)); err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+	sel := nb.Build()
+	if _, err := selector.ParseSelector(sel); err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+	return sel, nil
+}
+
+// etagSuffix returns a short, stable representation of the non-default
+// parameters, allowing HTTP caches to tell apart different CAR responses for
+// the same content path.
+func (p carParams) etagSuffix() string {
+	var parts []string
+	if p.scope != dagScopeAll {
+		parts = append(parts, "dag-scope="+string(p.scope))
+	}
+	if p.entityBytes != nil {
+		to := "*"
+		if p.entityBytes.to != nil {
+			to = strconv.FormatInt(*p.entityBytes.to, 10)
+		}
+		parts = append(parts, "entity-bytes="+strconv.FormatInt(p.entityBytes.from, 10)+":"+to)
+	}
+	if p.rawSelector != "" {
+		parts = append(parts, "selector="+url.QueryEscape(p.rawSelector))
+	}
+	if p.skipBlocks > 0 {
+		parts = append(parts, "skip-blocks="+strconv.FormatUint(p.skipBlocks, 10))
+	}
+	return strings.Join(parts, "&")
+}
+
+// carTraversal walks a DAG and passes every block to onBlock exactly once,
+// in the order the blocks were first visited. For the same DAG and
+// parameters the order is always the same, which is what makes resuming
+// with skip-blocks possible.
+type carTraversal struct {
+	ctx     context.Context
+	store   dagStore
+	seen    *cid.Set
+	onBlock func(blocks.Block) error
+	lsys    ipld.LinkSystem
+}
+
+func newCarTraversal(ctx context.Context, store dagStore, onBlock func(blocks.Block) error) *carTraversal {
+	t := &carTraversal{
+		ctx:     ctx,
+		store:   store,
+		seen:    cid.NewSet(),
+		onBlock: onBlock,
+	}
+	t.lsys = cidlink.DefaultLinkSystem()
+	t.lsys.TrustedStorage = true
+	t.lsys.KnownReifiers = map[string]ipld.NodeReifier{"unixfs": unixfsnode.Reify}
+	t.lsys.StorageReadOpener = func(_ ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type %T", lnk)
+		}
+		blk, err := t.get(cl.Cid)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(blk.RawData()), nil
+	}
+	return t
+}
+
+// get returns the block with the given CID, emitting it on the first visit.
+func (t *carTraversal) get(c cid.Cid) (blocks.Block, error) {
+	blk, err := t.store.Get(c)
+	if err != nil {
+		return nil, err
+	}
+	if t.seen.Visit(c) {
+		if err := t.onBlock(blk); err != nil {
+			return nil, err
+		}
+	}
+	return blk, nil
+}
+
+// walk emits the blocks needed to resolve segments from root, followed by
+// the blocks of the terminal element selected by params.
+func (t *carTraversal) walk(root cid.Cid, segments []string, params carParams) error {
+	terminal, err := t.walkPath(root, segments)
+	if err != nil {
+		return err
+	}
+
+	if params.selector != nil {
+		return t.walkSelector(terminal, params.selector, false)
+	}
+
+	switch params.scope {
+	case dagScopeBlock:
+		_, err = t.get(terminal)
+		return err
+	case dagScopeEntity:
+		return t.walkEntity(terminal, params.entityBytes)
+	default:
+		// TraverseLinksOnlyOnce is safe for an exhaustive selector
+		return t.walkSelector(terminal, selectorparse.CommonSelector_ExploreAllRecursively, true)
+	}
+}
+
+// walkPath follows the path segments from root, interpreting dag-pb nodes as
+// UnixFS (including HAMT-sharded directories), and returns the CID of the
+// block the path ends in.
+func (t *carTraversal) walkPath(root cid.Cid, segments []string) (cid.Cid, error) {
+	current := root
+	nd, err := t.loadPathNode(current)
+	if err != nil {
+		return cid.Undef, err
+	}
+	for _, seg := range segments {
+		if seg == "" {
+			continue
+		}
+		nd, err = nd.LookupBySegment(ipld.ParsePathSegment(seg))
+		if err != nil {
+			return cid.Undef, err
+		}
+		if nd.Kind() != ipld.Kind_Link {
+			// path continues within the same block
+			continue
+		}
+		lnk, err := nd.AsLink()
+		if err != nil {
+			return cid.Undef, err
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return cid.Undef, fmt.Errorf("unsupported link type %T", lnk)
+		}
+		current = cl.Cid
+		if nd, err = t.loadPathNode(current); err != nil {
+			return cid.Undef, err
+		}
+	}
+	return current, nil
+}
+
+func (t *carTraversal) loadPathNode(c cid.Cid) (ipld.Node, error) {
+	lctx := ipld.LinkContext{Ctx: t.ctx}
+	lnk := cidlink.Link{Cid: c}
+	proto, _ := prototypeChooser(lnk, lctx) // never errors
+	nd, err := t.lsys.Load(lctx, lnk, proto)
+	if err != nil {
+		return nil, err
+	}
+	return unixfsnode.Reify(lctx, nd, &t.lsys)
+}
+
+// walkSelector emits the blocks matching the selector, starting at root.
+func (t *carTraversal) walkSelector(root cid.Cid, sel ipld.Node, linksOnlyOnce bool) error {
+	parsed, err := selector.ParseSelector(sel)
+	if err != nil {
+		return err
+	}
+	lctx := ipld.LinkContext{Ctx: t.ctx}
+	lnk := cidlink.Link{Cid: root}
+	proto, _ := prototypeChooser(lnk, lctx) // never errors
+	nd, err := t.lsys.Load(lctx, lnk, proto)
+	if err != nil {
+		return err
+	}
+	prog := traversal.Progress{
+		Cfg: &traversal.Config{
+			Ctx:                            t.ctx,
+			LinkSystem:                     t.lsys,
+			LinkTargetNodePrototypeChooser: prototypeChooser,
+			LinkVisitOnlyOnce:              linksOnlyOnce,
+		},
+	}
+	return prog.WalkAdv(nd, parsed, func(traversal.Progress, ipld.Node, traversal.VisitReason) error { return nil })
+}
+
+// walkEntity emits the blocks of a single logical entity: a UnixFS file (or
+// the part of it within br), a UnixFS directory including its HAMT shards,
+// or the single block of anything else.
+func (t *carTraversal) walkEntity(root cid.Cid, br *byteRange) error {
+	blk, err := t.get(root)
+	if err != nil {
+		return err
+	}
+	if root.Prefix().Codec != cid.DagProtobuf {
+		return nil
+	}
+	pn, fsn, err := decodeUnixFSBlock(blk)
+	if err != nil {
+		// not UnixFS, the block is the entity
+		return nil
+	}
+
+	switch fsn.Type() {
+	case ft.TFile, ft.TRaw:
+		from, to := int64(0), int64(math.MaxInt64)
+		if br != nil {
+			from, to = br.resolve(int64(fsn.FileSize()))
+		}
+		return t.walkFileRange(pn, fsn, from, to)
+	case ft.THAMTShard:
+		return t.walkHAMTShards(pn, fsn)
+	default:
+		return nil
+	}
+}
+
+// walkFileRange emits the children of a UnixFS file node which hold bytes in
+// the inclusive range between from and to, relative to the node.
+func (t *carTraversal) walkFileRange(pn *dag.ProtoNode, fsn *ft.FSNode, from, to int64) error {
+	links := pn.Links()
+	if len(links) != fsn.NumChildren() {
+		return fmt.Errorf("inconsistent UnixFS file %s: %d links, %d block sizes", pn.Cid(), len(links), fsn.NumChildren())
+	}
+	offset := int64(len(fsn.Data()))
+	for i, l := range links {
+		size := int64(fsn.BlockSize(i))
+		start, end := offset, offset+size-1
+		offset += size
+		if end < from || start > to {
+			continue
+		}
+		blk, err := t.get(l.Cid)
+		if err != nil {
+			return err
+		}
+		if l.Cid.Prefix().Codec != cid.DagProtobuf {
+			continue
+		}
+		cpn, cfsn, err := decodeUnixFSBlock(blk)
+		if err != nil {
+			return err
+		}
+		if err := t.walkFileRange(cpn, cfsn, from-start, to-start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkHAMTShards emits the sub-shards of a HAMT-sharded directory, without
+// descending into the directory entries.
+func (t *carTraversal) walkHAMTShards(pn *dag.ProtoNode, fsn *ft.FSNode) error {
+	fanout := fsn.Fanout()
+	if fanout == 0 {
+		return fmt.Errorf("HAMT shard %s has no fanout", pn.Cid())
+	}
+	padLen := len(fmt.Sprintf("%X", fanout-1))
+	for _, l := range pn.Links() {
+		// Links to sub-shards are named with the bucket prefix only,
+		// entries have the prefix followed by the entry name.
+		if len(l.Name) != padLen {
+			continue
+		}
+		blk, err := t.get(l.Cid)
+		if err != nil {
+			return err
+		}
+		cpn, cfsn, err := decodeUnixFSBlock(blk)
+		if err != nil {
+			return err
+		}
+		if err := t.walkHAMTShards(cpn, cfsn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeUnixFSBlock(blk blocks.Block) (*dag.ProtoNode, *ft.FSNode, error) {
+	pn, err := dag.DecodeProtobufBlock(blk)
+	if err != nil {
+		return nil, nil, err
+	}
+	protoNode, ok := pn.(*dag.ProtoNode)
+	if !ok {
+		return nil, nil, dag.ErrNotProtobuf
+	}
+	fsn, err := ft.FSNodeFromBytes(protoNode.Data())
+	if err != nil {
+		return nil, nil, err
+	}
+	return protoNode, fsn, nil
+}
+
+// prototypeChooser decodes dag-pb nodes using the PBNode prototype, which is
+// required for UnixFS reification, and everything else as basic nodes.
+func prototypeChooser(lnk ipld.Link, _ ipld.LinkContext) (ipld.NodePrototype, error) {
+	if cl, ok := lnk.(cidlink.Link); ok && cl.Cid.Prefix().Codec == cid.DagProtobuf {
+		return dagpb.Type.PBNode, nil
+	}
+	return basicnode.Prototype.Any, nil
+}
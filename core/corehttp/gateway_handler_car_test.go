@@ -0,0 +1,320 @@
+package corehttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	carv2 "github.com/ipld/go-car/v2"
+	carindex "github.com/ipld/go-car/v2/index"
+)
+
+// addCarTestDir adds a directory with a single file split into 4-byte chunks
+// and returns the path of the directory.
+func addCarTestDir(t *testing.T, ctx context.Context, api iface.CoreAPI) ipath.Resolved {
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"file": files.NewBytesFile([]byte("0123456789abcdef")),
+		}),
+	})
+	p, err := api.Unixfs().Add(ctx, dir, options.Unixfs.Chunker("size-4"), options.Unixfs.RawLeaves(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func getCarBlocks(t *testing.T, ts *httptest.Server, urlPath string) ([]cid.Cid, []cid.Cid) {
+	res, err := http.Get(ts.URL + urlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		t.Fatalf("unexpected status %d for %s: %s", res.StatusCode, urlPath, body)
+	}
+	cr, err := gocar.NewCarReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cids []cid.Cid
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, blk.Cid())
+	}
+	return cr.Header.Roots, cids
+}
+
+func TestGatewayCarDagScope(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	root := addCarTestDir(t, ctx, api)
+	filePath := root.String() + "/sub/file"
+
+	sub, err := api.ResolvePath(ctx, ipath.Join(root, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := api.ResolvePath(ctx, ipath.New(filePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileNode, err := api.Dag().Get(ctx, file.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []cid.Cid
+	for _, l := range fileNode.Links() {
+		chunks = append(chunks, l.Cid)
+	}
+	if len(chunks) != 4 {
+		t.Fatalf("expected 4 chunks, got %d", len(chunks))
+	}
+	pathBlocks := []cid.Cid{root.Cid(), sub.Cid(), file.Cid()}
+
+	for _, test := range []struct {
+		query  string
+		blocks []cid.Cid
+	}{
+		{"", append(pathBlocks, chunks...)},
+		{"&dag-scope=all", append(pathBlocks, chunks...)},
+		{"&dag-scope=entity", append(pathBlocks, chunks...)},
+		{"&dag-scope=block", pathBlocks},
+		{"&dag-scope=entity&entity-bytes=0:3", append(pathBlocks, chunks[0])},
+		{"&dag-scope=entity&entity-bytes=5:9", append(pathBlocks, chunks[1], chunks[2])},
+		{"&dag-scope=entity&entity-bytes=-4:*", append(pathBlocks, chunks[3])},
+		{`&selector={".":{}}`, pathBlocks},
+	} {
+		roots, blocks := getCarBlocks(t, ts, filePath+"?format=car"+test.query)
+		if len(roots) != 1 || roots[0] != root.Cid() {
+			t.Errorf("%s: expected root %s, got %s", test.query, root.Cid(), roots)
+		}
+		if !cidsEqual(blocks, test.blocks) {
+			t.Errorf("%s: expected blocks %s, got %s", test.query, test.blocks, blocks)
+		}
+	}
+
+	// the whole DAG starting at the root of the path
+	_, blocks := getCarBlocks(t, ts, root.String()+"?format=car&dag-scope=block")
+	if !cidsEqual(blocks, []cid.Cid{root.Cid()}) {
+		t.Errorf("expected only the root block, got %s", blocks)
+	}
+	_, blocks = getCarBlocks(t, ts, root.String()+"?format=car")
+	if !cidsEqual(blocks, append(pathBlocks, chunks...)) {
+		t.Errorf("expected all blocks, got %s", blocks)
+	}
+}
+
+func TestGatewayCarResume(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	root := addCarTestDir(t, ctx, api)
+
+	get := func(query string) []byte {
+		res, err := http.Get(ts.URL + root.String() + "?format=car" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d for %s", res.StatusCode, query)
+		}
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	full := get("")
+
+	// byte offsets of the end of the header and of every block in the full CAR
+	cr, err := gocar.NewCarReader(bytes.NewReader(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerSize, err := gocar.HeaderSize(cr.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := []uint64{headerSize}
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := carutil.LdSize(blk.Cid().Bytes(), blk.RawData())
+		offsets = append(offsets, offsets[len(offsets)-1]+size)
+	}
+	if len(offsets) != 8 {
+		t.Fatalf("expected 7 blocks in the full CAR, got %d", len(offsets)-1)
+	}
+
+	for skip, offset := range offsets {
+		resumed := get(fmt.Sprintf("&skip-blocks=%d", skip))
+		if skip == 0 {
+			offset = 0 // the header is only part of the initial response
+		}
+		joined := append(append([]byte{}, full[:offset]...), resumed...)
+		if !bytes.Equal(joined, full) {
+			t.Errorf("skip-blocks=%d: resumed CAR does not match the full CAR", skip)
+		}
+	}
+}
+
+func TestGatewayCarV2(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	root := addCarTestDir(t, ctx, api)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+root.String()+"/sub/file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car; version=2")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.car; version=2" {
+		t.Fatalf("unexpected Content-Type %q", ct)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr, err := carv2.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Version != 2 || !cr.Header.HasIndex() {
+		t.Fatalf("expected an indexed CARv2, got version %d", cr.Version)
+	}
+	roots, err := cr.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0] != root.Cid() {
+		t.Fatalf("expected root %s, got %s", root.Cid(), roots)
+	}
+
+	// every block of the payload is reachable through the appended index
+	idx, err := carindex.ReadFrom(cr.IndexReader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, expected := getCarBlocks(t, ts, root.String()+"/sub/file?format=car")
+	for _, c := range expected {
+		offset, err := carindex.GetFirst(idx, c)
+		if err != nil {
+			t.Fatalf("block %s not found in the index: %s", c, err)
+		}
+		data := cr.DataReader()
+		if _, err := data.Seek(int64(offset), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		found, _, err := carutil.ReadNode(bufio.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != c {
+			t.Fatalf("expected block %s at offset %d, got %s", c, offset, found)
+		}
+	}
+}
+
+func TestGatewayCarInvalidParams(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	root := addCarTestDir(t, ctx, api)
+
+	for _, query := range []string{
+		"&dag-scope=everything",
+		"&entity-bytes=0:10",
+		"&dag-scope=entity&entity-bytes=10:0",
+		"&dag-scope=entity&entity-bytes=nope",
+		"&selector=nope",
+		"&skip-blocks=-1",
+		`&dag-scope=all&selector={"."%3A{}}`,
+	} {
+		res, err := http.Get(ts.URL + root.String() + "?format=car" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
+
+func TestGatewayCarEtag(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	root := addCarTestDir(t, ctx, api)
+
+	get := func(query, ifNoneMatch string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+root.String()+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	blockEtag := get("?format=car&dag-scope=block", "").Header.Get("Etag")
+	unixfsEtag := get("", "").Header.Get("Etag")
+
+	for _, test := range []struct {
+		query, ifNoneMatch string
+		status             int
+	}{
+		{"?format=car&dag-scope=block", blockEtag, http.StatusNotModified},
+		{"?format=car", blockEtag, http.StatusOK},
+		{"?format=car", unixfsEtag, http.StatusOK},
+		{"", blockEtag, http.StatusOK},
+	} {
+		if res := get(test.query, test.ifNoneMatch); res.StatusCode != test.status {
+			t.Errorf("%q with If-None-Match %s: expected status %d, got %d", test.query, test.ifNoneMatch, test.status, res.StatusCode)
+		}
+	}
+}
+
+func cidsEqual(a, b []cid.Cid) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].Bytes(), b[i].Bytes()) {
+			return false
+		}
+	}
+	return true
+}
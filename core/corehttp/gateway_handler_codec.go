@@ -0,0 +1,277 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs/tracing"
+	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/cbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	_ "github.com/ipld/go-ipld-prime/codec/json"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/traversal"
+	mc "github.com/multiformats/go-multicodec"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// codecToContentType maps the codecs which can be served as-is to the
+// Content-Type of their responses
+var codecToContentType = map[mc.Code]string{
+	mc.Json:    "application/json",
+	mc.Cbor:    "application/cbor",
+	mc.DagJson: "application/vnd.ipld.dag-json",
+	mc.DagCbor: "application/vnd.ipld.dag-cbor",
+}
+
+// contentTypeToCodec maps the requested response formats to the codec used
+// for transcoding. Plain JSON and CBOR are served as their DAG- variants, as
+// those are a superset which can also represent links.
+var contentTypeToCodec = map[string]mc.Code{
+	"application/json":              mc.DagJson,
+	"application/cbor":              mc.DagCbor,
+	"application/vnd.ipld.dag-json": mc.DagJson,
+	"application/vnd.ipld.dag-cbor": mc.DagCbor,
+}
+
+// contentTypeToExtension is used for the filename in Content-Disposition
+var contentTypeToExtension = map[string]string{
+	"application/json":              ".json",
+	"application/cbor":              ".cbor",
+	"application/vnd.ipld.dag-json": ".json",
+	"application/vnd.ipld.dag-cbor": ".cbor",
+}
+
+// HTML view of a DAG node for web browsers
+var dagTemplate = template.Must(template.New("dag").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<title>{{.Path}}</title>
+	</head>
+	<body>
+		<h1>{{.Path}}</h1>
+		<p>CID: <code>{{.CID}}</code> ({{.Codec}})</p>
+		<p>
+			Download as
+			<a href="?format=dag-json" rel="nofollow">dag-json</a>,
+			<a href="?format=dag-cbor" rel="nofollow">dag-cbor</a>,
+			<a href="?format=raw" rel="nofollow">raw block</a> or
+			<a href="?format=car" rel="nofollow">CAR</a>
+		</p>
+		{{if .Links}}<h2>Links</h2>
+		<ul>{{range .Links}}
+			<li><a href="/ipfs/{{.}}">{{.}}</a></li>{{end}}
+		</ul>{{end}}
+		<h2>Data</h2>
+		<pre>{{.Data}}</pre>
+	</body>
+</html>`))
+
+type dagTemplateData struct {
+	Path  string
+	CID   string
+	Codec string
+	Links []string
+	Data  string
+}
+
+// isServedAsCodec returns true for codecs which can't be represented as
+// UnixFS, and are served as dag-json, dag-cbor or their HTML view instead.
+func isServedAsCodec(c cid.Cid) bool {
+	_, ok := codecToContentType[mc.Code(c.Prefix().Codec)]
+	return ok
+}
+
+// serveCodec returns the node at the resolved path, following the remainder of
+// the path inside of the block. When responseFormat is empty, the data is
+// served in its own codec, or as HTML when requested by a web browser.
+// Otherwise, it is transcoded to the requested format when necessary.
+func (i *gatewayHandler) serveCodec(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, begin time.Time, responseFormat string) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeCodec", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
+	defer span.End()
+
+	blockCid := resolvedPath.Cid()
+	codec := mc.Code(blockCid.Prefix().Codec)
+
+	if responseFormat == "" {
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			i.serveCodecHTML(ctx, w, r, resolvedPath, contentPath)
+			return
+		}
+		ctype, ok := codecToContentType[codec]
+		if !ok {
+			err := fmt.Errorf("codec %s can't be served", codec)
+			webError(w, "failed respond with requested content type", err, http.StatusNotAcceptable)
+			return
+		}
+		responseFormat = ctype
+	}
+
+	targetCodec, ok := contentTypeToCodec[responseFormat]
+	if !ok {
+		err := fmt.Errorf("unsupported format %q", responseFormat)
+		webError(w, "failed respond with requested content type", err, http.StatusBadRequest)
+		return
+	}
+
+	// Serve the block as-is when it already is in the requested format
+	var data []byte
+	if resolvedPath.Remainder() == "" && (codecToContentType[codec] == responseFormat || codec == targetCodec) {
+		blockReader, err := i.api.Block().Get(ctx, resolvedPath)
+		if err != nil {
+			webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
+			return
+		}
+		data, err = ioutil.ReadAll(blockReader)
+		if err != nil {
+			webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		node, err := i.resolveCodecNode(ctx, resolvedPath)
+		if err != nil {
+			webError(w, "ipfs dag get "+debugStr(contentPath.String()), err, http.StatusInternalServerError)
+			return
+		}
+		encoder, err := multicodec.LookupEncoder(uint64(targetCodec))
+		if err != nil {
+			webError(w, "failed to find encoder for "+targetCodec.String(), err, http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := encoder(node, &buf); err != nil {
+			webError(w, "failed to encode as "+targetCodec.String(), err, http.StatusInternalServerError)
+			return
+		}
+		data = buf.Bytes()
+	}
+
+	// Set Content-Disposition
+	name := blockCid.String() + contentTypeToExtension[responseFormat]
+	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
+		name = urlFilename
+	}
+	disposition := "inline"
+	if targetCodec == mc.DagCbor || r.URL.Query().Get("download") == "true" {
+		// binary, browsers would not be able to display it anyway
+		disposition = "attachment"
+	}
+	setContentDispositionHeader(w, name, disposition)
+
+	// Set remaining headers
+	modtime := addCacheControlHeaders(w, r, contentPath, blockCid)
+	if rem := resolvedPath.Remainder(); rem != "" {
+		// different paths within the same block return different data
+		w.Header().Set("Etag", fmt.Sprintf("%s.%x\"", strings.TrimSuffix(getEtag(r, blockCid), `"`), xxhash.Sum64String(rem)))
+	}
+	w.Header().Set("Content-Type", responseFormat)
+	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+
+	// ServeContent will take care of
+	// If-None-Match+Etag, Content-Length and range requests
+	_, dataSent, _ := ServeContent(w, r, name, modtime, bytes.NewReader(data))
+
+	if dataSent {
+		// Update metrics
+		i.codecGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	}
+}
+
+// serveCodecHTML renders a human-readable view of the node at the resolved
+// path, with links to the other formats it can be downloaded as.
+func (i *gatewayHandler) serveCodecHTML(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path) {
+	node, err := i.resolveCodecNode(ctx, resolvedPath)
+	if err != nil {
+		webError(w, "ipfs dag get "+debugStr(contentPath.String()), err, http.StatusInternalServerError)
+		return
+	}
+
+	encoder, err := multicodec.LookupEncoder(uint64(mc.DagJson))
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	var buf, indented bytes.Buffer
+	if err := encoder(node, &buf); err != nil {
+		webError(w, "failed to encode as dag-json", err, http.StatusInternalServerError)
+		return
+	}
+	if err := json.Indent(&indented, buf.Bytes(), "", "  "); err != nil {
+		webError(w, "failed to encode as dag-json", err, http.StatusInternalServerError)
+		return
+	}
+
+	nodeLinks, err := traversal.SelectLinks(node)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	var links []string
+	for _, l := range nodeLinks {
+		if cl, ok := l.(cidlink.Link); ok {
+			links = append(links, cl.Cid.String())
+		}
+	}
+
+	blockCid := resolvedPath.Cid()
+	// The HTML is generated, it is not the content behind the CID itself
+	w.Header().Set("Etag", getDagHTMLEtag(blockCid, resolvedPath.Remainder()))
+	if r.Header.Get("If-None-Match") == w.Header().Get("Etag") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+
+	if err := dagTemplate.Execute(w, dagTemplateData{
+		Path:  contentPath.String(),
+		CID:   blockCid.String(),
+		Codec: mc.Code(blockCid.Prefix().Codec).String(),
+		Links: links,
+		Data:  indented.String(),
+	}); err != nil {
+		internalWebError(w, err)
+	}
+}
+
+// resolveCodecNode returns the node behind the resolved path, following the
+// remainder of the path within the block, just like 'ipfs dag get'.
+func (i *gatewayHandler) resolveCodecNode(ctx context.Context, resolvedPath ipath.Resolved) (ipld.Node, error) {
+	obj, err := i.api.Dag().Get(ctx, resolvedPath.Cid())
+	if err != nil {
+		return nil, err
+	}
+	universal, ok := obj.(ipldlegacy.UniversalNode)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a valid IPLD node", obj)
+	}
+	node := universal.(ipld.Node)
+	if rem := resolvedPath.Remainder(); rem != "" {
+		return traversal.Get(node, ipld.ParsePath(rem))
+	}
+	return node, nil
+}
+
+// getDagHTMLEtag returns the Etag of the generated HTML view, which is
+// different from the Etag of the node served in its own format.
+func getDagHTMLEtag(c cid.Cid, remainder string) string {
+	if remainder == "" {
+		return `"DagIndex-` + c.String() + `"`
+	}
+	return fmt.Sprintf(`"DagIndex-%s.%x"`, c.String(), xxhash.Sum64String(remainder))
+}
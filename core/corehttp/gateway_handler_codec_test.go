@@ -0,0 +1,114 @@
+package corehttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+)
+
+func TestGatewayCodec(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	file := addCarTestDir(t, ctx, api)
+
+	dagJSON := `{"data":{"greeting":"hello"},"link":{"/":"` + file.Cid().String() + `"}}`
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, strings.NewReader(dagJSON)); err != nil {
+		t.Fatal(err)
+	}
+	var dagCBOR bytes.Buffer
+	if err := dagcbor.Encode(nb.Build(), &dagCBOR); err != nil {
+		t.Fatal(err)
+	}
+	p, err := api.Block().Put(ctx, bytes.NewReader(dagCBOR.Bytes()), options.Block.Format("cbor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := "/ipfs/" + p.Path().Cid().String()
+
+	get := func(urlPath, accept string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d for %s: %s", res.StatusCode, urlPath, body)
+		}
+		return res, body
+	}
+
+	// served as-is
+	res, body := get(root, "")
+	if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.dag-cbor" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if !bytes.Equal(body, dagCBOR.Bytes()) {
+		t.Errorf("expected the block as-is, got %x", body)
+	}
+	res, body = get(root, "application/cbor")
+	if ct := res.Header.Get("Content-Type"); ct != "application/cbor" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if !bytes.Equal(body, dagCBOR.Bytes()) {
+		t.Errorf("expected the block as-is, got %x", body)
+	}
+
+	// transcoded to dag-json
+	res, body = get(root+"?format=dag-json", "")
+	if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.dag-json" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if string(body) != dagJSON {
+		t.Errorf("unexpected dag-json: %s", body)
+	}
+	_, body = get(root, "application/json")
+	if string(body) != dagJSON {
+		t.Errorf("unexpected json: %s", body)
+	}
+
+	// path inside of the block
+	res, body = get(root+"/data", "application/vnd.ipld.dag-json")
+	if string(body) != `{"greeting":"hello"}` {
+		t.Errorf("unexpected node at path: %s", body)
+	}
+	if etag := res.Header.Get("Etag"); !strings.HasPrefix(etag, `"`+p.Path().Cid().String()+`.dag-json.`) {
+		t.Errorf("expected a path-specific Etag, got %s", etag)
+	}
+
+	// UnixFS is transcoded on explicit request only
+	_, body = get(file.String()+"?format=dag-json", "")
+	if !bytes.Contains(body, []byte(`"Links"`)) {
+		t.Errorf("expected a dag-pb node as dag-json, got %s", body)
+	}
+	res, _ = get(file.String()+"/sub/file", "application/json")
+	if ct := res.Header.Get("Content-Type"); strings.HasPrefix(ct, "application/json") {
+		t.Errorf("expected the UnixFS file, got Content-Type %q", ct)
+	}
+
+	// HTML view for browsers
+	res, body = get(root, "text/html,application/xhtml+xml,*/*;q=0.8")
+	if ct := res.Header.Get("Content-Type"); ct != "text/html" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if !bytes.Contains(body, []byte(file.Cid().String())) || !bytes.Contains(body, []byte("?format=dag-cbor")) {
+		t.Errorf("expected links in the HTML view, got %s", body)
+	}
+}
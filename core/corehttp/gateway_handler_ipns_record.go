@@ -0,0 +1,91 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs/tracing"
+	ipns "github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serveIpnsRecord returns the signed IPNS record of /ipns/{key}, as found in
+// the routing system, without resolving it. The record is cached for as long as
+// its TTL allows, but not past its validity.
+func (i *gatewayHandler) serveIpnsRecord(ctx context.Context, w http.ResponseWriter, r *http.Request, contentPath ipath.Path, begin time.Time) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeIpnsRecord", trace.WithAttributes(attribute.String("path", contentPath.String())))
+	defer span.End()
+
+	segments := strings.Split(strings.Trim(contentPath.String(), "/"), "/")
+	if contentPath.Namespace() != "ipns" || len(segments) != 2 {
+		err := fmt.Errorf("%s is not an IPNS name", contentPath.String())
+		webError(w, "ipns-record is only supported for /ipns/{key}", err, http.StatusBadRequest)
+		return
+	}
+	key := segments[1]
+	pid, err := peer.Decode(key)
+	if err != nil {
+		webError(w, "ipns-record is only supported for IPNS keys, not DNSLink", err, http.StatusBadRequest)
+		return
+	}
+	if i.routing == nil {
+		webError(w, "ipns-record", fmt.Errorf("routing is not available"), http.StatusNotImplemented)
+		return
+	}
+
+	rawRecord, err := i.routing.GetValue(ctx, ipns.RecordKey(pid))
+	if err == datastore.ErrNotFound {
+		// offline routing looks up records in the local datastore
+		err = routing.ErrNotFound
+	}
+	if err != nil {
+		webError(w, "ipfs dht get /ipns/"+debugStr(key), err, http.StatusInternalServerError)
+		return
+	}
+	var record ipns_pb.IpnsEntry
+	if err := record.Unmarshal(rawRecord); err != nil {
+		webError(w, "invalid IPNS record for "+debugStr(key), err, http.StatusInternalServerError)
+		return
+	}
+
+	// Cache for the TTL of the record, but never past its end of life
+	maxAge := time.Duration(record.GetTtl())
+	if eol, err := ipns.GetEOL(&record); err == nil {
+		if untilEOL := time.Until(eol); untilEOL < maxAge {
+			maxAge = untilEOL
+		}
+	}
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+
+	// The same name may point at different records over time
+	w.Header().Set("Etag", fmt.Sprintf(`"%s.ipns-record.%x"`, key, xxhash.Sum64(rawRecord)))
+
+	name := key + ".ipns-record"
+	setContentDispositionHeader(w, name, "attachment")
+	w.Header().Set("Content-Type", "application/vnd.ipfs.ipns-record")
+	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+	i.addUserHeaders(w)
+
+	// ServeContent will take care of
+	// If-None-Match+Etag, Content-Length and range requests
+	_, dataSent, _ := ServeContent(w, r, name, noModtime, bytes.NewReader(rawRecord))
+
+	if dataSent {
+		// Update metrics
+		i.ipnsRecordGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	}
+}
@@ -0,0 +1,123 @@
+package corehttp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ipns "github.com/ipfs/go-ipns"
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestGatewayIpnsRecord(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, HostnameOption(), GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sk, pk, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := ipns.Create(sk, []byte("/ipfs/bafkqaaa"), 1, time.Now().Add(time.Hour), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawRecord, err := record.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Routing.PutValue(n.Context(), ipns.RecordKey(pid), rawRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		accept string
+	}{
+		{"/ipns/" + pid.String() + "?format=ipns-record", ""},
+		{"/ipns/" + peer.ToCid(pid).String(), "application/vnd.ipfs.ipns-record"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d: %s", test.path, res.StatusCode, body)
+		}
+		if !bytes.Equal(body, rawRecord) {
+			t.Errorf("%s: expected the signed record", test.path)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipfs.ipns-record" {
+			t.Errorf("%s: unexpected Content-Type %q", test.path, ct)
+		}
+		if cc := res.Header.Get("Cache-Control"); cc != "public, max-age=300" {
+			t.Errorf("%s: expected caching for the TTL of the record, got %q", test.path, cc)
+		}
+		if etag := res.Header.Get("Etag"); !strings.Contains(etag, ".ipns-record.") {
+			t.Errorf("%s: unexpected Etag %q", test.path, etag)
+		}
+	}
+
+	for _, invalid := range []string{
+		"/ipns/" + pid.String() + "/sub/path?format=ipns-record",
+		"/ipns/example.com?format=ipns-record",
+		"/ipfs/bafkqaaa?format=ipns-record",
+	} {
+		res, err := http.Get(ts.URL + invalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", invalid, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+
+	// unknown keys
+	_, otherPk, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := peer.IDFromPublicKey(otherPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Get(ts.URL + "/ipns/" + other.String() + "?format=ipns-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown key, got %d", http.StatusNotFound, res.StatusCode)
+	}
+}
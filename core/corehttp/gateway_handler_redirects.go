@@ -0,0 +1,250 @@
+package corehttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	gopath "path"
+	"sort"
+	"strconv"
+	"strings"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"go.uber.org/zap"
+)
+
+const (
+	redirectsFileName = "_redirects"
+
+	// maxRedirectsFileSize limits the size of _redirects files, which are
+	// parsed on every request for a missing path
+	maxRedirectsFileSize = 64 << 10
+)
+
+// redirectRule is a single line of a _redirects file:
+//
+//   /from/:placeholder/*  /to/:placeholder/:splat  301
+type redirectRule struct {
+	from   string
+	to     string
+	status int
+}
+
+// hasOriginIsolation returns true for requests made to a subdomain or DNSLink
+// hostname, where the content root is the root of the origin.
+func hasOriginIsolation(r *http.Request) bool {
+	_, ok := r.Context().Value("gw-hostname").(string)
+	return ok
+}
+
+// handleRedirectsFile applies the rules from the _redirects file at the root
+// of an origin-isolated website to a path which could not be resolved.
+// When the response was written, handled is true. A 200 rule returns the
+// rewritten path, which should be served instead of the requested one.
+func (i *gatewayHandler) handleRedirectsFile(w http.ResponseWriter, r *http.Request, contentPath ipath.Path, logger *zap.SugaredLogger) (rewrittenPath ipath.Path, handled bool) {
+	if !hasOriginIsolation(r) {
+		return nil, false
+	}
+	segments := strings.Split(strings.TrimPrefix(contentPath.String(), "/"), "/")
+	if len(segments) < 2 {
+		return nil, false
+	}
+	rootPath := "/" + segments[0] + "/" + segments[1]
+	urlPath := "/" + strings.Join(segments[2:], "/")
+
+	rules, err := i.getRedirectRules(r, rootPath)
+	if err != nil {
+		if err == errNoRedirectsFile {
+			return nil, false
+		}
+		internalWebError(w, err)
+		return nil, true
+	}
+
+	for _, rule := range rules {
+		to, ok := rule.match(urlPath)
+		if !ok {
+			continue
+		}
+		logger.Debugw("applying _redirects rule", "from", rule.from, "to", to, "status", rule.status)
+
+		switch rule.status {
+		case http.StatusOK:
+			return ipath.New(rootPath + stripQuery(to)), false
+		case http.StatusNotFound:
+			if i.serveRedirectsNotFound(w, r, ipath.New(rootPath+stripQuery(to))) {
+				return nil, true
+			}
+			// the custom 404 page does not exist, try the next rule
+		default:
+			http.Redirect(w, r, to, rule.status)
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+var errNoRedirectsFile = fmt.Errorf("no %s file", redirectsFileName)
+
+// getRedirectRules reads and parses the _redirects file at rootPath
+func (i *gatewayHandler) getRedirectRules(r *http.Request, rootPath string) ([]redirectRule, error) {
+	redirectsPath, err := i.api.ResolvePath(r.Context(), ipath.New(gopath.Join(rootPath, redirectsFileName)))
+	if err != nil {
+		return nil, errNoRedirectsFile
+	}
+	node, err := i.api.Unixfs().Get(r.Context(), redirectsPath)
+	if err != nil {
+		return nil, errNoRedirectsFile
+	}
+	defer node.Close()
+
+	f, ok := node.(files.File)
+	if !ok {
+		return nil, errNoRedirectsFile
+	}
+	size, err := f.Size()
+	if err != nil {
+		return nil, err
+	}
+	if size > maxRedirectsFileSize {
+		return nil, fmt.Errorf("%s file is over the %d bytes limit", redirectsFileName, maxRedirectsFileSize)
+	}
+	return parseRedirectsFile(f)
+}
+
+// parseRedirectsFile parses a Netlify-style _redirects file. Every line is a
+// rule made of the path to match, its destination and an optional status
+// code, which defaults to 301. Empty lines and lines starting with # are
+// ignored.
+func parseRedirectsFile(r io.Reader) ([]redirectRule, error) {
+	var rules []redirectRule
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s line %d: expected 'from to [status]'", redirectsFileName, lineNum)
+		}
+
+		rule := redirectRule{from: fields[0], to: fields[1], status: http.StatusMovedPermanently}
+		if !strings.HasPrefix(rule.from, "/") {
+			return nil, fmt.Errorf("%s line %d: path %q must start with /", redirectsFileName, lineNum, rule.from)
+		}
+		if !strings.HasPrefix(rule.to, "/") && !strings.HasPrefix(rule.to, "http://") && !strings.HasPrefix(rule.to, "https://") {
+			return nil, fmt.Errorf("%s line %d: destination %q must be a path or an http(s) URL", redirectsFileName, lineNum, rule.to)
+		}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: invalid status %q", redirectsFileName, lineNum, fields[2])
+			}
+			rule.status = status
+		}
+		switch rule.status {
+		case http.StatusOK, http.StatusNotFound:
+			if !strings.HasPrefix(rule.to, "/") {
+				return nil, fmt.Errorf("%s line %d: status %d requires a path destination", redirectsFileName, lineNum, rule.status)
+			}
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("%s line %d: unsupported status %d", redirectsFileName, lineNum, rule.status)
+		}
+		rules = append(rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// match returns the destination of the rule with its placeholders filled in,
+// if urlPath matches the rule. Placeholders like :name match a single path
+// segment, and a trailing * matches the rest of the path, available as :splat.
+func (rule redirectRule) match(urlPath string) (string, bool) {
+	pattern := strings.Split(strings.Trim(rule.from, "/"), "/")
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+
+	values := map[string]string{}
+	for n, p := range pattern {
+		if p == "*" && n == len(pattern)-1 {
+			values["splat"] = strings.Join(segments[n:], "/")
+			segments = segments[:n]
+			break
+		}
+		if n >= len(segments) {
+			return "", false
+		}
+		if strings.HasPrefix(p, ":") && len(p) > 1 {
+			if segments[n] == "" {
+				return "", false
+			}
+			values[p[1:]] = segments[n]
+			continue
+		}
+		if p != segments[n] {
+			return "", false
+		}
+	}
+	if _, ok := values["splat"]; !ok && len(segments) != len(pattern) {
+		return "", false
+	}
+
+	// Replace longer names first, so that :id does not replace the
+	// beginning of :identity
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool { return len(names[a]) > len(names[b]) })
+	to := rule.to
+	for _, name := range names {
+		to = strings.ReplaceAll(to, ":"+name, values[name])
+	}
+	return to, true
+}
+
+// serveRedirectsNotFound serves the file at notFoundPath with a 404 status
+func (i *gatewayHandler) serveRedirectsNotFound(w http.ResponseWriter, r *http.Request, notFoundPath ipath.Path) bool {
+	resolvedPath, err := i.api.ResolvePath(r.Context(), notFoundPath)
+	if err != nil {
+		return false
+	}
+	node, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
+	if err != nil {
+		return false
+	}
+	defer node.Close()
+
+	f, ok := node.(files.File)
+	if !ok {
+		return false
+	}
+	size, err := f.Size()
+	if err != nil {
+		return false
+	}
+
+	ctype := mime.TypeByExtension(gopath.Ext(notFoundPath.String()))
+	if ctype == "" {
+		ctype = "text/html"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusNotFound)
+	_, err = io.CopyN(w, f, size)
+	return err == nil
+}
+
+func stripQuery(p string) string {
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		return p[:i]
+	}
+	return p
+}
@@ -0,0 +1,55 @@
+package corehttp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRedirectsFile(t *testing.T) {
+	for _, invalid := range []string{
+		"/only-from",
+		"/a /b 301 extra",
+		"a /b",
+		"/a b",
+		"/a /b nope",
+		"/a /b 500",
+		"/a https://example.org 200",
+	} {
+		if _, err := parseRedirectsFile(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+
+	rules, err := parseRedirectsFile(strings.NewReader("\n# comment\n/a /b\n/c/:id /d/:id 302\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].status != 301 || rules[1].status != 302 {
+		t.Fatalf("unexpected rules: %v", rules)
+	}
+}
+
+func TestRedirectRuleMatch(t *testing.T) {
+	for _, test := range []struct {
+		from, to, path string
+		expected       string
+		ok             bool
+	}{
+		{"/a", "/b", "/a", "/b", true},
+		{"/a", "/b", "/a/", "/b", true},
+		{"/a", "/b", "/a/c", "", false},
+		{"/a/:id", "/b/:id", "/a/1", "/b/1", true},
+		{"/a/:id", "/b/:id", "/a", "", false},
+		{"/:id/:identity", "/:identity/:id", "/1/2", "/2/1", true},
+		{"/a/*", "/b/:splat", "/a/c/d", "/b/c/d", true},
+		{"/a/*", "/b/:splat", "/a", "/b/", true},
+		{"/*", "/index.html", "/anything/at/all", "/index.html", true},
+		{"/a/*", "/b", "/c", "", false},
+	} {
+		rule := redirectRule{from: test.from, to: test.to}
+		to, ok := rule.match(test.path)
+		if ok != test.ok || to != test.expected {
+			t.Errorf("%s → %s for %s: got (%q, %t), expected (%q, %t)", test.from, test.to, test.path, to, ok, test.expected, test.ok)
+		}
+	}
+}
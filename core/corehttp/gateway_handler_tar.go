@@ -0,0 +1,206 @@
+package corehttp
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	gopath "path"
+	"strings"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-ipfs/tracing"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serveTAR returns a TAR stream of a UnixFS directory, gzipped when the client
+// accepts it. Entries with names or symlink targets that would escape the
+// extraction directory abort the stream.
+func (i *gatewayHandler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, begin time.Time) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeTAR", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
+	defer span.End()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if isServedAsCodec(resolvedPath.Cid()) {
+		err := fmt.Errorf("%s is not a UnixFS directory", resolvedPath.Cid())
+		webError(w, "tar is only supported for UnixFS directories", err, http.StatusBadRequest)
+		return
+	}
+	nd, err := i.api.Unixfs().Get(ctx, resolvedPath)
+	if err != nil {
+		webError(w, "ipfs get "+html.EscapeString(contentPath.String()), err, http.StatusNotFound)
+		return
+	}
+	defer nd.Close()
+	dir, ok := nd.(files.Directory)
+	if !ok {
+		err := fmt.Errorf("%s is not a UnixFS directory", contentPath.String())
+		webError(w, "tar is only supported for UnixFS directories", err, http.StatusBadRequest)
+		return
+	}
+
+	// Name the archive and its root directory after the last path segment,
+	// or the CID when requested by CID only
+	name := resolvedPath.Cid().String()
+	segments := strings.Split(strings.Trim(contentPath.String(), "/"), "/")
+	if base := segments[len(segments)-1]; (len(segments) > 2 || contentPath.Namespace() == "ipns") && validTarName(base) {
+		name = base
+	}
+
+	gz := acceptsGzip(r)
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	// Weak Etag W/ because the tar headers include the time of the request
+	etag := `W/` + getEtag(r, resolvedPath.Cid())
+	if gz {
+		etag = strings.TrimSuffix(etag, `"`) + `.gz"`
+	}
+	w.Header().Set("Etag", etag)
+
+	// Finish early if Etag match
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	setContentDispositionHeader(w, name+".tar", "attachment")
+
+	// Make it clear we don't support range-requests over a tar stream
+	w.Header().Set("Accept-Ranges", "none")
+
+	// Explicit Cache-Control to ensure fresh stream on retry.
+	w.Header().Set("Cache-Control", "no-cache, no-transform")
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+	if gz {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	i.addUserHeaders(w)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	var out io.Writer = w
+	var gzw *gzip.Writer
+	if gz {
+		gzw = gzip.NewWriter(w)
+		out = gzw
+	}
+	if err := writeTar(out, name, dir); err != nil {
+		// We return error as a trailer, however it is not something browsers can access
+		// (https://github.com/mdn/browser-compat-data/issues/14703)
+		w.Header().Set("X-Stream-Error", err.Error())
+		return
+	}
+	if gzw != nil {
+		if err := gzw.Close(); err != nil {
+			w.Header().Set("X-Stream-Error", err.Error())
+			return
+		}
+	}
+
+	// Update metrics
+	i.tarStreamGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+}
+
+// writeTar writes dir as a TAR archive rooted at name, the same way
+// `ipfs get --archive` does
+func writeTar(w io.Writer, name string, dir files.Directory) error {
+	tw, err := files.NewTarWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteFile(&tarSafeDirectory{Directory: dir}, name); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// tarSafeDirectory validates the entries of a UnixFS directory as they are
+// written to a TAR archive. UnixFS does not restrict link names, so a
+// malicious DAG could otherwise produce entries like "../../etc/passwd".
+type tarSafeDirectory struct {
+	files.Directory
+	// path of the directory relative to the root of the archive
+	path string
+}
+
+func (d *tarSafeDirectory) Entries() files.DirIterator {
+	return &tarSafeIterator{DirIterator: d.Directory.Entries(), dir: d.path}
+}
+
+type tarSafeIterator struct {
+	files.DirIterator
+	dir string
+	err error
+}
+
+func (it *tarSafeIterator) Next() bool {
+	if it.err != nil || !it.DirIterator.Next() {
+		return false
+	}
+	name := it.DirIterator.Name()
+	if !validTarName(name) {
+		it.err = fmt.Errorf("invalid entry name %q in %q", name, "/"+it.dir)
+		return false
+	}
+	if link, ok := it.DirIterator.Node().(*files.Symlink); ok && !validTarSymlink(it.dir, link.Target) {
+		it.err = fmt.Errorf("symlink %q points outside of the archive: %q", gopath.Join("/", it.dir, name), link.Target)
+		return false
+	}
+	return true
+}
+
+func (it *tarSafeIterator) Node() files.Node {
+	nd := it.DirIterator.Node()
+	if dir, ok := nd.(files.Directory); ok {
+		return &tarSafeDirectory{Directory: dir, path: gopath.Join(it.dir, it.DirIterator.Name())}
+	}
+	return nd
+}
+
+func (it *tarSafeIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.DirIterator.Err()
+}
+
+// validTarName returns true for names that can't change the directory an
+// entry is extracted to
+func validTarName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// validTarSymlink returns true for relative symlinks in dir that point within
+// the archive
+func validTarSymlink(dir string, target string) bool {
+	if target == "" || strings.HasPrefix(target, "/") || strings.ContainsAny(target, "\\\x00") {
+		return false
+	}
+	resolved := gopath.Join(dir, target)
+	return resolved != ".." && !strings.HasPrefix(resolved, "../")
+}
+
+// acceptsGzip returns true when the client accepts gzip content encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, encoding := range strings.Split(header, ",") {
+			encoding = strings.TrimSpace(encoding)
+			mediatype, params, err := mime.ParseMediaType(encoding)
+			if err == nil && mediatype == "gzip" && params["q"] != "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package corehttp
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+type tarEntry struct {
+	*tar.Header
+	data string
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]tarEntry {
+	t.Helper()
+	entries := map[string]tarEntry{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = tarEntry{hdr, string(data)}
+	}
+}
+
+func TestGatewayTar(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	root, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"hello.txt": files.NewBytesFile([]byte("hello")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"world.txt": files.NewBytesFile([]byte("world")),
+			"link":      files.NewLinkFile("../hello.txt", nil),
+		}),
+		"docs": files.NewMapDirectory(map[string]files.Node{
+			"readme.txt": files.NewBytesFile([]byte("readme")),
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCid := root.Cid().String()
+
+	for _, test := range []struct {
+		url, accept string
+		gzip        bool
+	}{
+		{ts.URL + "/ipfs/" + rootCid + "?format=tar", "", false},
+		{ts.URL + "/ipfs/" + rootCid, "application/x-tar", false},
+		{ts.URL + "/ipfs/" + rootCid + "?format=tar", "", true},
+	} {
+		req, err := http.NewRequest(http.MethodGet, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		if test.gzip {
+			req.Header.Set("Accept-Encoding", "gzip, deflate")
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			t.Fatalf("%s: unexpected status %d: %s", test.url, res.StatusCode, body)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/x-tar" {
+			t.Errorf("%s: unexpected Content-Type %q", test.url, ct)
+		}
+		if etag := res.Header.Get("Etag"); etag[:2] != "W/" {
+			t.Errorf("%s: expected a weak Etag, got %q", test.url, etag)
+		}
+
+		var body io.Reader = res.Body
+		if test.gzip {
+			if ce := res.Header.Get("Content-Encoding"); ce != "gzip" {
+				t.Fatalf("%s: expected gzip Content-Encoding, got %q", test.url, ce)
+			}
+			gzr, err := gzip.NewReader(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = gzr
+		} else if ce := res.Header.Get("Content-Encoding"); ce != "" {
+			t.Errorf("%s: unexpected Content-Encoding %q", test.url, ce)
+		}
+		entries := readTar(t, body)
+		res.Body.Close()
+
+		if hdr, ok := entries[rootCid]; !ok || hdr.Typeflag != tar.TypeDir {
+			t.Errorf("%s: expected the root directory to be named after the CID", test.url)
+		}
+		if hdr, ok := entries[rootCid+"/hello.txt"]; !ok || hdr.data != "hello" {
+			t.Errorf("%s: missing hello.txt", test.url)
+		}
+		if hdr, ok := entries[rootCid+"/sub/world.txt"]; !ok || hdr.data != "world" {
+			t.Errorf("%s: missing sub/world.txt", test.url)
+		}
+		if hdr, ok := entries[rootCid+"/sub/link"]; !ok || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "../hello.txt" {
+			t.Errorf("%s: missing sub/link", test.url)
+		}
+	}
+
+	// subdirectories are named after the last path segment
+	res, err := http.Get(ts.URL + "/ipfs/" + rootCid + "/docs?format=tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := readTar(t, res.Body)
+	res.Body.Close()
+	if hdr, ok := entries["docs/readme.txt"]; !ok || hdr.data != "readme" {
+		t.Errorf("expected entries under docs/, got %v", entries)
+	}
+
+	// files are not directories
+	res, err = http.Get(ts.URL + "/ipfs/" + rootCid + "/hello.txt?format=tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for a file, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestGatewayTarSanitizesPaths(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	file, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("evil")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileNode, err := api.Dag().Get(ctx, file.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	evilName := ft.EmptyDirNode()
+	if err := evilName.AddNodeLink("../../evil.txt", fileNode); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Dag().Add(ctx, evilName); err != nil {
+		t.Fatal(err)
+	}
+
+	evilLink, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"link": files.NewLinkFile("../../etc/passwd", nil),
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []string{evilName.Cid().String(), evilLink.Cid().String()} {
+		res, err := http.Get(ts.URL + "/ipfs/" + c + "?format=tar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The error is only known once the stream has started, so the
+		// archive is truncated before the malicious entry
+		tr := tar.NewReader(res.Body)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if hdr.Name != c && hdr.Name != c+"/sub" {
+				t.Errorf("%s: unexpected entry %q", c, hdr.Name)
+			}
+		}
+		res.Body.Close()
+	}
+
+	for _, test := range []struct {
+		dir, target string
+		valid       bool
+	}{
+		{"", "file", true},
+		{"a/b", "../../file", true},
+		{"a/b", "../../../file", false},
+		{"", "../file", false},
+		{"", "/etc/passwd", false},
+		{"a", "b/../../..", false},
+	} {
+		if valid := validTarSymlink(test.dir, test.target); valid != test.valid {
+			t.Errorf("symlink %q in %q: got %t, expected %t", test.target, test.dir, valid, test.valid)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`, "a\x00"} {
+		if validTarName(name) {
+			t.Errorf("expected %q to be an invalid name", name)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package corehttp
 
 import (
+	"bytes"
 	"context"
 	"net/http"
 	"net/url"
@@ -108,6 +109,17 @@ func (i *gatewayHandler) serveDirectory(ctx context.Context, w http.ResponseWrit
 		return
 	}
 
+	// The listing depends on the URL it is rendered for, on top of the CID
+	gwHostname, _ := r.Context().Value("gw-hostname").(string)
+	listingKey := strings.Join([]string{resolvedPath.Cid().String(), contentPath.String(), originalUrlPath, gwHostname, assets.AssetHash}, "\x00")
+	if listing, ok := i.cache.listing(listingKey); ok {
+		logger.Debugw("serving cached directory listing", "path", contentPath)
+		if _, err := w.Write(listing); err == nil {
+			i.unixfsGenDirGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+		}
+		return
+	}
+
 	// storage for directory listing
 	var dirListing []directoryItem
 	dirit := dir.Entries()
@@ -198,9 +210,21 @@ func (i *gatewayHandler) serveDirectory(ctx context.Context, w http.ResponseWrit
 
 	logger.Debugw("request processed", "tplDataDNSLink", dnslink, "tplDataSize", size, "tplDataBackLink", backLink, "tplDataHash", hash)
 
-	if err := listingTemplate.Execute(w, tplData); err != nil {
-		internalWebError(w, err)
-		return
+	if i.cache == nil {
+		if err := listingTemplate.Execute(w, tplData); err != nil {
+			internalWebError(w, err)
+			return
+		}
+	} else {
+		var listing bytes.Buffer
+		if err := listingTemplate.Execute(&listing, tplData); err != nil {
+			internalWebError(w, err)
+			return
+		}
+		i.cache.addListing(listingKey, listing.Bytes())
+		if _, err := w.Write(listing.Bytes()); err != nil {
+			return
+		}
 	}
 
 	// Update metrics
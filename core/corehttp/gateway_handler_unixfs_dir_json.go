@@ -0,0 +1,204 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-ipfs/tracing"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// The maximum (and default) number of entries in a page of a JSON directory
+// listing
+const dirListingJSONPageSize = 1000
+
+// dirListingJSON is the JSON representation of a UnixFS directory
+type dirListingJSON struct {
+	Cid     string
+	Path    string
+	Entries []dirListingJSONEntry
+	// Cursor of the next page, if any
+	Next string `json:",omitempty"`
+}
+
+type dirListingJSONEntry struct {
+	Name string
+	Cid  string
+	Size uint64
+	Type string
+}
+
+// isUnixFSDirectory returns true when the resolved path is a UnixFS directory,
+// including HAMT-sharded directories
+func (i *gatewayHandler) isUnixFSDirectory(ctx context.Context, resolvedPath ipath.Resolved) bool {
+	if isServedAsCodec(resolvedPath.Cid()) {
+		return false
+	}
+	nd, err := i.api.Unixfs().Get(ctx, resolvedPath)
+	if err != nil {
+		return false
+	}
+	defer nd.Close()
+	_, ok := nd.(files.Directory)
+	return ok
+}
+
+// serveDirectoryJSON returns a page of the entries of a UnixFS directory as
+// JSON. Pages start after the entry named by the opaque cursor parameter, so
+// the listing of large HAMT-sharded directories can be fetched incrementally.
+//
+// The directory is enumerated from its start up to the cursor for every page,
+// so reaching the page k loads the blocks holding the k*limit entries before
+// it: the children are not fetched, but the shards of a HAMT are.
+func (i *gatewayHandler) serveDirectoryJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, begin time.Time, logger *zap.SugaredLogger) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeDirectoryJSON", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
+	defer span.End()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := r.URL.Query()
+	limit := dirListingJSONPageSize
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > dirListingJSONPageSize {
+			err := fmt.Errorf("limit has to be between 1 and %d", dirListingJSONPageSize)
+			webError(w, "invalid directory listing parameters", err, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var after string
+	cursor := query.Get("cursor")
+	if cursor != "" {
+		name, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(name) == 0 {
+			err := fmt.Errorf("invalid cursor %q", cursor)
+			webError(w, "invalid directory listing parameters", err, http.StatusBadRequest)
+			return
+		}
+		after = string(name)
+	}
+
+	// The listing of an immutable directory only changes with the page
+	etag := getEtag(r, resolvedPath.Cid())
+	if after != "" || limit != dirListingJSONPageSize {
+		suffix := fmt.Sprintf("%s&%d", after, limit)
+		etag = fmt.Sprintf("%s.%x\"", strings.TrimSuffix(etag, `"`), xxhash.Sum64String(suffix))
+	}
+	w.Header().Set("Etag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if !contentPath.Mutable() {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	}
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+	i.addUserHeaders(w)
+
+	if r.Method == http.MethodHead {
+		logger.Debug("return as request's HTTP method is HEAD")
+		return
+	}
+
+	// Names and CIDs come from the directory blocks alone. Only the entries
+	// of the page are resolved for their size and type.
+	links, err := i.api.Unixfs().Ls(ctx, resolvedPath, options.Unixfs.ResolveChildren(false))
+	if err != nil {
+		webError(w, "ipfs ls "+html.EscapeString(contentPath.String()), err, http.StatusNotFound)
+		return
+	}
+	listing := dirListingJSON{
+		Cid:     resolvedPath.Cid().String(),
+		Path:    contentPath.String(),
+		Entries: []dirListingJSONEntry{},
+	}
+	var page []coreiface.DirEntry
+	skipping := after != ""
+	for link := range links {
+		if link.Err != nil {
+			internalWebError(w, link.Err)
+			return
+		}
+		if skipping {
+			skipping = link.Name != after
+			continue
+		}
+		if len(page) == limit {
+			// there is at least one more entry
+			listing.Next = base64.RawURLEncoding.EncodeToString([]byte(page[len(page)-1].Name))
+			break
+		}
+		page = append(page, link)
+	}
+	cancel()
+	if skipping {
+		w.Header().Del("Etag")
+		w.Header().Del("Cache-Control")
+		err := fmt.Errorf("cursor %q names no entry of the directory", cursor)
+		webError(w, "invalid directory listing parameters", err, http.StatusBadRequest)
+		return
+	}
+
+	for _, link := range page {
+		entry, err := i.dirListingJSONEntry(r.Context(), link)
+		if err != nil {
+			internalWebError(w, err)
+			return
+		}
+		listing.Entries = append(listing.Entries, entry)
+	}
+
+	if err := json.NewEncoder(w).Encode(listing); err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	// Update metrics
+	i.unixfsGenDirGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+}
+
+func (i *gatewayHandler) dirListingJSONEntry(ctx context.Context, link coreiface.DirEntry) (dirListingJSONEntry, error) {
+	entry := dirListingJSONEntry{
+		Name: link.Name,
+		Cid:  link.Cid.String(),
+		Type: coreiface.TUnknown.String(),
+	}
+	if isServedAsCodec(link.Cid) {
+		return entry, nil
+	}
+	nd, err := i.api.Unixfs().Get(ctx, ipath.IpfsPath(link.Cid))
+	if err != nil {
+		return entry, err
+	}
+	defer nd.Close()
+	switch nd.(type) {
+	case *files.Symlink:
+		entry.Type = coreiface.TSymlink.String()
+	case files.File:
+		entry.Type = coreiface.TFile.String()
+	case files.Directory:
+		entry.Type = coreiface.TDirectory.String()
+	}
+	// Size may not be defined/supported. Continue anyways.
+	if size, err := nd.Size(); err == nil {
+		entry.Size = uint64(size)
+	}
+	return entry, nil
+}
@@ -0,0 +1,176 @@
+package corehttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	files "github.com/ipfs/go-ipfs-files"
+	uio "github.com/ipfs/go-unixfs/io"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+func getDirListingJSON(t *testing.T, u string, accept string) (*http.Response, dirListingJSON) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var listing dirListingJSON
+	if res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(&listing); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res, listing
+}
+
+func TestGatewayDirListingJSON(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	root, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"file.txt": files.NewBytesFile([]byte("hello")),
+		"sub":      files.NewMapDirectory(map[string]files.Node{}),
+		"link":     files.NewLinkFile("file.txt", nil),
+		// index.html is only served to browsers
+		"index.html": files.NewBytesFile([]byte("<html></html>")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := api.ResolvePath(ctx, ipath.Join(root, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path, accept string
+	}{
+		{"/ipfs/" + root.Cid().String(), "application/json"},
+		{"/ipfs/" + root.Cid().String() + "?format=json", ""},
+	} {
+		res, listing := getDirListingJSON(t, ts.URL+test.path, test.accept)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", test.path, res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: unexpected Content-Type %q", test.path, ct)
+		}
+		if listing.Cid != root.Cid().String() || listing.Next != "" || len(listing.Entries) != 4 {
+			t.Fatalf("%s: unexpected listing %+v", test.path, listing)
+		}
+		types := map[string]string{}
+		for _, e := range listing.Entries {
+			types[e.Name] = e.Type
+			if e.Name == "file.txt" && (e.Cid != file.Cid().String() || e.Size != 5) {
+				t.Errorf("%s: unexpected file entry %+v", test.path, e)
+			}
+		}
+		if types["file.txt"] != "file" || types["sub"] != "directory" || types["link"] != "symlink" {
+			t.Errorf("%s: unexpected entry types %v", test.path, types)
+		}
+	}
+
+	// JSON files in UnixFS are returned as-is
+	jsonFile, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte(`{"a": 1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/ipfs/"+jsonFile.Cid().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(body) != `{"a": 1}` {
+		t.Errorf("unexpected response for a JSON file: %d %s", res.StatusCode, body)
+	}
+
+	for _, invalid := range []string{"limit=0", "limit=1001", "limit=a", "cursor=%21%21"} {
+		res, _ := getDirListingJSON(t, ts.URL+"/ipfs/"+root.Cid().String()+"?format=json&"+invalid, "")
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", invalid, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
+
+func TestGatewayDirListingJSONPagination(t *testing.T) {
+	prevVal := uio.HAMTShardingSize
+	uio.HAMTShardingSize = 1
+	t.Cleanup(func() { uio.HAMTShardingSize = prevVal })
+
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	entries := map[string]files.Node{}
+	for i := 0; i < 25; i++ {
+		entries[fmt.Sprintf("file-%d", i)] = files.NewBytesFile([]byte(fmt.Sprint(i)))
+	}
+	root, err := api.Unixfs().Add(ctx, files.NewMapDirectory(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	var cursor string
+	var etags []string
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("too many pages")
+		}
+		u := ts.URL + "/ipfs/" + root.Cid().String() + "?limit=10&cursor=" + url.QueryEscape(cursor)
+		res, listing := getDirListingJSON(t, u, "application/json")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", res.StatusCode)
+		}
+		etags = append(etags, res.Header.Get("Etag"))
+		for _, e := range listing.Entries {
+			if seen[e.Name] {
+				t.Fatalf("entry %s listed twice", e.Name)
+			}
+			seen[e.Name] = true
+		}
+		if listing.Next == "" {
+			if len(listing.Entries) != 5 {
+				t.Errorf("expected 5 entries on the last page, got %d", len(listing.Entries))
+			}
+			break
+		}
+		if len(listing.Entries) != 10 {
+			t.Errorf("expected 10 entries per page, got %d", len(listing.Entries))
+		}
+		cursor = listing.Next
+	}
+	if len(seen) != len(entries) {
+		t.Errorf("expected %d entries, got %d", len(entries), len(seen))
+	}
+	if len(etags) != 3 || etags[0] == etags[1] || etags[1] == etags[2] {
+		t.Errorf("expected a different Etag per page, got %v", etags)
+	}
+
+	// a cursor naming no entry is rejected, not an empty last page
+	u := ts.URL + "/ipfs/" + root.Cid().String() + "?cursor=" + base64.RawURLEncoding.EncodeToString([]byte("missing"))
+	res, _ := getDirListingJSON(t, u, "application/json")
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown cursor, got %d", res.StatusCode)
+	}
+}
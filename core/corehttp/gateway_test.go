@@ -656,3 +656,154 @@ func TestVersion(t *testing.T) {
 		t.Fatalf("response doesn't contain protocol version:\n%s", s)
 	}
 }
+
+func TestRedirectsFile(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+
+	f1 := files.NewMapDirectory(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte(`# comment
+/old-page       /new-page
+/moved/:id/*    /articles/:id/:splat  302
+/external       https://example.org/
+/app/*          /index.html           200
+/missing/*      /not-found.html       404
+`)),
+		"index.html":     files.NewBytesFile([]byte("SPA")),
+		"new-page":       files.NewBytesFile([]byte("New page")),
+		"not-found.html": files.NewBytesFile([]byte("Custom not found")),
+	})
+
+	k, err := api.Unixfs().Add(ctx, f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := "example.net"
+	ns["/ipns/"+host] = path.FromString(k.String())
+
+	for _, test := range []struct {
+		host     string
+		path     string
+		status   int
+		location string
+		text     string
+	}{
+		{host, "/new-page", http.StatusOK, "", "New page"},
+		{host, "/old-page", http.StatusMovedPermanently, "/new-page", ""},
+		{host, "/moved/42/some/thing", http.StatusFound, "/articles/42/some/thing", ""},
+		{host, "/moved/42", http.StatusFound, "/articles/42/", ""},
+		{host, "/external", http.StatusMovedPermanently, "https://example.org/", ""},
+		{host, "/app/users/1", http.StatusOK, "", "SPA"},
+		{host, "/missing/thing", http.StatusNotFound, "", "Custom not found"},
+		{host, "/nope", http.StatusNotFound, "", ""},
+		// rules only apply to origin-isolated requests
+		{"", k.String() + "/old-page", http.StatusNotFound, "", ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.host != "" {
+			req.Host = test.host
+		}
+		resp, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatalf("error requesting %s: %s", test.path, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != test.status {
+			t.Errorf("got %d, expected %d, from %s", resp.StatusCode, test.status, test.path)
+		}
+		if location := resp.Header.Get("Location"); location != test.location {
+			t.Errorf("got location %q, expected %q, from %s", location, test.location, test.path)
+		}
+		if test.text == "" {
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("error reading response from %s: %s", test.path, err)
+		}
+		if string(body) != test.text {
+			t.Errorf("unexpected response body from %s: got %q, expected %q", test.path, body, test.text)
+		}
+	}
+}
+
+func TestTrustlessOnly(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Gateway.TrustlessOnly = true
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, HostnameOption(), GatewayOption(true, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("<script>alert(1)</script>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method string
+		host   string
+		path   string
+		accept string
+		status int
+	}{
+		{http.MethodGet, "", k.String(), "", http.StatusNotAcceptable},
+		{http.MethodGet, "", k.String(), "text/html", http.StatusNotAcceptable},
+		{http.MethodGet, "", k.String() + "?format=dag-json", "", http.StatusNotAcceptable},
+		{http.MethodGet, "", k.String() + "?format=raw", "", http.StatusOK},
+		{http.MethodGet, "", k.String(), "application/vnd.ipld.car", http.StatusOK},
+		{http.MethodHead, "", k.String(), "application/vnd.ipld.raw", http.StatusOK},
+		{http.MethodGet, "", k.String() + "/nope?format=raw", "", http.StatusNotFound},
+		// no redirect to a subdomain
+		{http.MethodGet, "localhost", k.String() + "?format=raw", "", http.StatusOK},
+		{http.MethodGet, k.Cid().String() + ".ipfs.localhost", "/?format=raw", "", http.StatusOK},
+		// writable methods are disabled
+		{http.MethodPost, "", "/ipfs/", "", http.StatusMethodNotAllowed},
+		{http.MethodPut, "", k.String() + "/file", "", http.StatusMethodNotAllowed},
+	} {
+		req, err := http.NewRequest(test.method, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.host != "" {
+			req.Host = test.host
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Errorf("%s %s%s (%s): got status %d, expected %d", test.method, test.host, test.path, test.accept, res.StatusCode, test.status)
+		}
+		if location := res.Header.Get("Location"); location != "" {
+			t.Errorf("%s %s%s: unexpected redirect to %s", test.method, test.host, test.path, location)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// gatewayTimeoutError is returned when a deadline of Gateway.Timeouts is hit,
+// and results in a 504 Gateway Timeout
+type gatewayTimeoutError struct {
+	stage   string // the Gateway.Timeouts setting
+	timeout time.Duration
+}
+
+func (e *gatewayTimeoutError) Error() string {
+	return fmt.Sprintf("no response within %s (Gateway.Timeouts.%s)", e.timeout, e.stage)
+}
+
+// withTimeout returns ctx with the deadline d, unless it is disabled
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// timeoutError returns a gatewayTimeoutError when ctx, derived from the
+// request context with the deadline of stage, has expired. Deadlines of the
+// whole request take precedence.
+func (i *gatewayHandler) timeoutError(requestCtx context.Context, ctx context.Context, stage string, timeout time.Duration) error {
+	if requestCtx.Err() == context.DeadlineExceeded && i.config.TotalTimeout > 0 {
+		return &gatewayTimeoutError{stage: "Total", timeout: i.config.TotalTimeout}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return &gatewayTimeoutError{stage: stage, timeout: timeout}
+	}
+	return nil
+}
+
+// resolvePath resolves p within Gateway.Timeouts.Resolution, or returns its
+// cached resolution
+func (i *gatewayHandler) resolvePath(ctx context.Context, p ipath.Path) (ipath.Resolved, error) {
+	if resolved, ok := i.cache.resolvedPath(p); ok {
+		return resolved, nil
+	}
+	rctx, cancel := withTimeout(ctx, i.config.ResolutionTimeout)
+	defer cancel()
+	resolved, err := i.api.ResolvePath(rctx, p)
+	if err != nil {
+		if terr := i.timeoutError(ctx, rctx, "Resolution", i.config.ResolutionTimeout); terr != nil {
+			return nil, terr
+		}
+		return nil, err
+	}
+	i.cache.addResolvedPath(p, resolved)
+	return resolved, nil
+}
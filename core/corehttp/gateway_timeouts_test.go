@@ -0,0 +1,101 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	path "github.com/ipfs/go-path"
+	nsopts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
+)
+
+// slowNamesys never resolves names before the deadline of the request
+type slowNamesys struct {
+	mockNamesys
+}
+
+func (slowNamesys) Resolve(ctx context.Context, name string, opts ...nsopts.ResolveOpt) (path.Path, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestGatewayTimeouts(t *testing.T) {
+	for _, test := range []struct {
+		timeouts string
+		setting  string
+	}{
+		{`{"Resolution": "50ms"}`, "Gateway.Timeouts.Resolution"},
+		{`{"Resolution": "10s", "Total": "50ms"}`, "Gateway.Timeouts.Total"},
+	} {
+		n, err := newNodeWithMockNamesys(mockNamesys{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Namesys = slowNamesys{}
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(test.timeouts), &cfg.Gateway.Timeouts); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Repo.SetConfig(cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		dh := &delegatedHandler{}
+		ts := httptest.NewServer(dh)
+		dh.Handler, err = makeHandler(n, ts.Listener, HostnameOption(), GatewayOption(false, "/ipfs", "/ipns"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		res, err := http.Get(ts.URL + "/ipns/example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		ts.Close()
+		n.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("%s: expected status %d, got %d: %s", test.timeouts, http.StatusGatewayTimeout, res.StatusCode, body)
+		}
+		if !strings.Contains(string(body), test.setting) {
+			t.Errorf("%s: expected the body to name %s, got %q", test.timeouts, test.setting, body)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: request took %s", test.timeouts, elapsed)
+		}
+	}
+}
+
+func TestGatewayTimeoutError(t *testing.T) {
+	i := &gatewayHandler{config: GatewayConfig{FirstBlockTimeout: time.Millisecond, TotalTimeout: time.Hour}}
+
+	requestCtx, cancelRequest := context.WithCancel(context.Background())
+	defer cancelRequest()
+	ctx, cancel := withTimeout(requestCtx, i.config.FirstBlockTimeout)
+	defer cancel()
+	<-ctx.Done()
+	err := i.timeoutError(requestCtx, ctx, "FirstBlock", i.config.FirstBlockTimeout)
+	if terr, ok := err.(*gatewayTimeoutError); !ok || terr.stage != "FirstBlock" {
+		t.Errorf("expected a FirstBlock timeout, got %v", err)
+	}
+
+	// canceled requests are not timeouts
+	ctx, cancel = withTimeout(requestCtx, 0)
+	cancel()
+	if err := i.timeoutError(requestCtx, ctx, "FirstBlock", 0); err != nil {
+		t.Errorf("expected no timeout for a canceled context, got %v", err)
+	}
+}
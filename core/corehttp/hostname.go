@@ -38,8 +38,19 @@ var defaultKnownGateways = map[string]*config.GatewaySpec{
 // Label's max length in DNS (https://tools.ietf.org/html/rfc1034#page-7)
 const dnsLabelMaxLength int = 63
 
-// HostnameOption rewrites an incoming request based on the Host header.
+// HostnameOption rewrites an incoming request based on the Host header,
+// without the denylist of a GatewayState.
 func HostnameOption() ServeOption {
+	return hostnameOption(nil)
+}
+
+// HostnameOption rewrites an incoming request based on the Host header, with
+// the denylist of the state.
+func (s *GatewayState) HostnameOption() ServeOption {
+	return hostnameOption(s)
+}
+
+func hostnameOption(state *GatewayState) ServeOption {
 	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
 		childMux := http.NewServeMux()
 
@@ -55,6 +66,11 @@ func HostnameOption() ServeOption {
 
 		knownGateways := prepareKnownGateways(cfg.Gateway.PublicGateways)
 
+		var denylist *denylist
+		if state != nil {
+			denylist = state.denylist
+		}
+
 		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			// Unfortunately, many (well, ipfs.io) gateways use
 			// DNSLink so if we blindly rewrite with DNSLink, we'll
@@ -80,8 +96,15 @@ func HostnameOption() ServeOption {
 				if hasPrefix(r.URL.Path, gw.Paths...) {
 					// It does.
 
+					// Denied content is not redirected to a subdomain
+					if denylist.serveIfDenied(w, r.URL.Path) {
+						return
+					}
+
 					// Should this gateway use subdomains instead of paths?
-					if gw.UseSubdomains {
+					// (a trustless gateway does not need origin isolation,
+					// as it never serves deserialized content)
+					if gw.UseSubdomains && !cfg.Gateway.TrustlessOnly {
 						// Yes, redirect if applicable
 						// Example: dweb.link/ipfs/{cid} → {cid}.ipfs.dweb.link
 						newURL, err := toSubdomainURL(host, r.URL.Path, r, coreAPI)
@@ -110,6 +133,9 @@ func HostnameOption() ServeOption {
 				// Not a whitelisted path
 
 				// Try DNSLink, if it was not explicitly disabled for the hostname
+				if !gw.NoDNSLink && denylist.serveIfDenied(w, "/ipns/"+stripPort(host)+r.URL.Path) {
+					return
+				}
 				if !gw.NoDNSLink && isDNSLinkName(r.Context(), coreAPI, host) {
 					// rewrite path and handle as DNSLink
 					r.URL.Path = "/ipns/" + stripPort(host) + r.URL.Path
@@ -139,6 +165,10 @@ func HostnameOption() ServeOption {
 					return
 				}
 
+				if denylist.serveIfDenied(w, pathPrefix+r.URL.Path) {
+					return
+				}
+
 				// Check if rootID is a valid CID
 				if rootCID, err := cid.Decode(rootID); err == nil {
 					// Do we need to redirect root CID to a canonical DNS representation?
@@ -147,7 +177,8 @@ func HostnameOption() ServeOption {
 						http.Error(w, err.Error(), http.StatusBadRequest)
 						return
 					}
-					if !strings.HasPrefix(r.Host, dnsCID) {
+					// (trustless gateways serve any representation as-is)
+					if !strings.HasPrefix(r.Host, dnsCID) && !cfg.Gateway.TrustlessOnly {
 						dnsPrefix := "/" + ns + "/" + dnsCID
 						newURL, err := toSubdomainURL(gwHostname, dnsPrefix+r.URL.Path, r, coreAPI)
 						if err != nil {
@@ -163,7 +194,7 @@ func HostnameOption() ServeOption {
 					}
 
 					// Do we need to fix multicodec in PeerID represented as CIDv1?
-					if isPeerIDNamespace(ns) {
+					if isPeerIDNamespace(ns) && !cfg.Gateway.TrustlessOnly {
 						if rootCID.Type() != cid.Libp2pKey {
 							newURL, err := toSubdomainURL(gwHostname, pathPrefix+r.URL.Path, r, coreAPI)
 							if err != nil {
@@ -218,6 +249,9 @@ func HostnameOption() ServeOption {
 			// 1. is wildcard DNSLink enabled (Gateway.NoDNSLink=false)?
 			// 2. does Host header include a fully qualified domain name (FQDN)?
 			// 3. does DNSLink record exist in DNS?
+			if !cfg.Gateway.NoDNSLink && denylist.serveIfDenied(w, "/ipns/"+stripPort(host)+r.URL.Path) {
+				return
+			}
 			if !cfg.Gateway.NoDNSLink && isDNSLinkName(r.Context(), coreAPI, host) {
 				// rewrite path and handle as DNSLink
 				r.URL.Path = "/ipns/" + stripPort(host) + r.URL.Path
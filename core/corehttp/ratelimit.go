@@ -0,0 +1,311 @@
+package corehttp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/ipfs/go-ipfs/config"
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// idle clients are forgotten after this long, which is longer than it takes
+// to refill any of their limits
+const rateLimitClientTTL = 2 * time.Minute
+
+// rateLimiter enforces the per-client limits of Gateway.RateLimit. Clients are
+// identified by the IP address of the connection, or by the last untrusted hop
+// of X-Forwarded-For when the connection comes from one of the TrustedProxies.
+type rateLimiter struct {
+	rate          float64 // tokens per second
+	burst         float64
+	maxConcurrent int64
+	maxBytes      int64 // per minute
+	proxies       []*net.IPNet
+
+	now func() time.Time
+
+	lk        sync.Mutex
+	clients   map[string]*rateLimitClient
+	lastSweep time.Time
+
+	limitedMetric  *prometheus.CounterVec
+	inFlightMetric prometheus.Gauge
+}
+
+type rateLimitClient struct {
+	tokens     float64
+	lastRefill time.Time
+
+	inFlight int64
+
+	windowStart time.Time
+	windowBytes int64
+}
+
+// newRateLimiter returns nil when none of the limits are enabled
+func newRateLimiter(cfg config.GatewayRateLimit) (*rateLimiter, error) {
+	rate := cfg.RequestsPerSecond.WithDefault(0)
+	burst := cfg.Burst.WithDefault(rate)
+	maxConcurrent := cfg.MaxConcurrentRequests.WithDefault(0)
+	maxBytes := cfg.MaxBytesPerMinute.WithDefault(0)
+	if rate < 0 || burst < 0 || maxConcurrent < 0 || maxBytes < 0 {
+		return nil, fmt.Errorf("Gateway.RateLimit values can't be negative")
+	}
+	if rate > 0 && burst < 1 {
+		return nil, fmt.Errorf("Gateway.RateLimit.Burst has to be at least 1")
+	}
+	if rate == 0 && maxConcurrent == 0 && maxBytes == 0 {
+		return nil, nil
+	}
+
+	var proxies []*net.IPNet
+	for _, p := range cfg.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Gateway.RateLimit.TrustedProxies entry %q: %w", p, err)
+		}
+		proxies = append(proxies, ipnet)
+	}
+
+	return &rateLimiter{
+		rate:          float64(rate),
+		burst:         float64(burst),
+		maxConcurrent: maxConcurrent,
+		maxBytes:      maxBytes,
+		proxies:       proxies,
+		now:           time.Now,
+		clients:       map[string]*rateLimitClient{},
+		limitedMetric: newGatewayCounterMetric(
+			"gw_rate_limited_requests_total",
+			"The number of gateway requests rejected by the per-client limits, by limit.",
+			"limit",
+		),
+		inFlightMetric: newGatewayGaugeMetric(
+			"gw_rate_limit_in_flight_requests",
+			"The number of gateway requests in flight, as tracked by the per-client limits.",
+		),
+	}, nil
+}
+
+// wrap returns a handler enforcing the limits before calling next
+func (l *rateLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := l.clientIP(r)
+		retryAfter, limit := l.acquire(client)
+		if limit != "" {
+			l.limitedMetric.WithLabelValues(limit).Inc()
+			log.Debugw("rate limited", "client", client, "limit", limit)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "429 Too Many Requests: "+limit+" limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		l.inFlightMetric.Inc()
+
+		cw := &countingResponseWriter{ResponseWriter: w, limiter: l, client: client}
+		defer func() {
+			l.inFlightMetric.Dec()
+			l.release(client)
+		}()
+		next.ServeHTTP(cw, r)
+		if cw.cutOff {
+			l.limitedMetric.WithLabelValues("bytes").Inc()
+			log.Debugw("response cut off", "client", client, "limit", "bytes")
+			// close the connection, so that the client can tell the
+			// response is incomplete
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+// acquire takes a token and an in-flight slot for the client, or returns the
+// limit preventing it and the number of seconds after which to retry.
+func (l *rateLimiter) acquire(client string) (retryAfter int, limit string) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &rateLimitClient{tokens: l.burst, lastRefill: now, windowStart: now}
+		l.clients[client] = c
+	}
+
+	if l.maxConcurrent > 0 && c.inFlight >= l.maxConcurrent {
+		return 1, "concurrency"
+	}
+
+	if l.maxBytes > 0 {
+		if now.Sub(c.windowStart) >= time.Minute {
+			c.windowStart = now
+			c.windowBytes = 0
+		}
+		if c.windowBytes >= l.maxBytes {
+			return retrySeconds(c.windowStart.Add(time.Minute).Sub(now)), "bytes"
+		}
+	}
+
+	if l.rate > 0 {
+		c.tokens = math.Min(l.burst, c.tokens+now.Sub(c.lastRefill).Seconds()*l.rate)
+		c.lastRefill = now
+		if c.tokens < 1 {
+			missing := time.Duration((1 - c.tokens) / l.rate * float64(time.Second))
+			return retrySeconds(missing), "rate"
+		}
+		c.tokens--
+	}
+
+	c.inFlight++
+	return 0, ""
+}
+
+// release frees the in-flight slot of the client
+func (l *rateLimiter) release(client string) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	c, ok := l.clients[client]
+	if !ok {
+		return
+	}
+	c.inFlight--
+}
+
+// charge accounts n bytes sent to the client, and returns how many of them
+// fit in what is left of its bytes for the current minute
+func (l *rateLimiter) charge(client string, n int64) int64 {
+	if l.maxBytes == 0 {
+		return n
+	}
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	c, ok := l.clients[client]
+	if !ok {
+		return n
+	}
+	now := l.now()
+	if now.Sub(c.windowStart) >= time.Minute {
+		c.windowStart = now
+		c.windowBytes = 0
+	}
+	left := l.maxBytes - c.windowBytes
+	if left < 0 {
+		left = 0
+	}
+	if n > left {
+		n = left
+	}
+	c.windowBytes += n
+	return n
+}
+
+// sweep forgets idle clients, at most once per TTL
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitClientTTL {
+		return
+	}
+	l.lastSweep = now
+	for client, c := range l.clients {
+		if c.inFlight == 0 && now.Sub(c.lastRefill) > rateLimitClientTTL && now.Sub(c.windowStart) > rateLimitClientTTL {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// clientIP returns the IP address identifying the client of the request
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !l.isTrustedProxy(host) {
+		return host
+	}
+
+	// Walk X-Forwarded-For from the closest hop, skipping trusted proxies
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// an invalid hop can't be trusted to identify anyone further
+			break
+		}
+		host = hop
+		if !l.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func (l *rateLimiter) isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, p := range l.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func retrySeconds(d time.Duration) int {
+	s := int(math.Ceil(d.Seconds()))
+	if s < 1 {
+		return 1
+	}
+	return s
+}
+
+var errRateLimitBytes = errors.New("bytes limit exceeded")
+
+// countingResponseWriter charges the bytes of the response body to the
+// client, and cuts the response off once the client is out of bytes
+type countingResponseWriter struct {
+	http.ResponseWriter
+	limiter *rateLimiter
+	client  string
+	cutOff  bool
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	if w.cutOff {
+		return 0, errRateLimitBytes
+	}
+	allowed := w.limiter.charge(w.client, int64(len(p)))
+	n, err := w.ResponseWriter.Write(p[:allowed])
+	if err == nil && allowed < int64(len(p)) {
+		// send the bytes allowed before the connection is closed
+		w.Flush()
+		w.cutOff = true
+		err = errRateLimitBytes
+	}
+	return n, err
+}
+
+func (w *countingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,213 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/ipfs/go-ipfs/config"
+)
+
+func newTestRateLimiter(t *testing.T, cfgJSON string) (*rateLimiter, *time.Time) {
+	var cfg config.GatewayRateLimit
+	if err := json.Unmarshal([]byte(cfgJSON), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	l, err := newRateLimiter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func doRateLimited(h http.Handler, remoteAddr string, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/bafkqaaa", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	l, err := newRateLimiter(config.GatewayRateLimit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l != nil {
+		t.Fatal("expected no rate limiter without limits")
+	}
+	for _, invalid := range []string{
+		`{"RequestsPerSecond": -1}`,
+		`{"RequestsPerSecond": 1, "Burst": 0}`,
+		`{"RequestsPerSecond": 1, "TrustedProxies": ["nope"]}`,
+	} {
+		var cfg config.GatewayRateLimit
+		if err := json.Unmarshal([]byte(invalid), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newRateLimiter(cfg); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestRateLimitRequests(t *testing.T) {
+	l, now := newTestRateLimiter(t, `{"RequestsPerSecond": 1, "Burst": 2}`)
+	h := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 2; i++ {
+		if rec := doRateLimited(h, "1.2.3.4:1234", ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, rec.Code)
+		}
+	}
+	rec := doRateLimited(h, "1.2.3.4:1234", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", rec.Code)
+	}
+	if ra := rec.Header().Get("Retry-After"); ra != "1" {
+		t.Errorf("expected Retry-After 1, got %q", ra)
+	}
+
+	// other clients have their own bucket
+	if rec := doRateLimited(h, "5.6.7.8:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for another client, got %d", rec.Code)
+	}
+
+	*now = now.Add(time.Second)
+	if rec := doRateLimited(h, "1.2.3.4:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 after refill, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitConcurrency(t *testing.T) {
+	l, _ := newTestRateLimiter(t, `{"MaxConcurrentRequests": 1}`)
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	h := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-unblock
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		doRateLimited(h, "1.2.3.4:1234", "")
+		close(done)
+	}()
+	<-started
+	if rec := doRateLimited(h, "1.2.3.4:1234", ""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 with a request in flight, got %d", rec.Code)
+	}
+	close(unblock)
+	<-done
+
+	started = make(chan struct{})
+	unblock = make(chan struct{})
+	close(unblock)
+	if rec := doRateLimited(h, "1.2.3.4:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 after the first request finished, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitBytes(t *testing.T) {
+	l, now := newTestRateLimiter(t, `{"MaxBytesPerMinute": 12}`)
+	h := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 6)))
+	}))
+
+	for i := 0; i < 2; i++ {
+		if rec := doRateLimited(h, "1.2.3.4:1234", ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, rec.Code)
+		}
+	}
+	*now = now.Add(20 * time.Second)
+	rec := doRateLimited(h, "1.2.3.4:1234", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", rec.Code)
+	}
+	if ra := rec.Header().Get("Retry-After"); ra != "40" {
+		t.Errorf("expected Retry-After 40, got %q", ra)
+	}
+
+	*now = now.Add(40 * time.Second)
+	if rec := doRateLimited(h, "1.2.3.4:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 in the next minute, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitBytesCutOff(t *testing.T) {
+	l, _ := newTestRateLimiter(t, `{"MaxBytesPerMinute": 10}`)
+	type result struct {
+		written int
+		err     error
+	}
+	results := make(chan result, 2)
+	ts := httptest.NewServer(l.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res result
+		for i := 0; i < 4 && res.err == nil; i++ {
+			var n int
+			n, res.err = w.Write([]byte(strings.Repeat("a", 4)))
+			res.written += n
+		}
+		results <- res
+	})))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err == nil {
+		t.Error("expected the response to be cut off")
+	}
+	if r := <-results; len(body) != 10 || r.written != 10 || r.err != errRateLimitBytes {
+		t.Errorf("expected 10 bytes and %v, got %d bytes received, %d written and %v", errRateLimitBytes, len(body), r.written, r.err)
+	}
+
+	res, err = http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 after the cut off, got %d", res.StatusCode)
+	}
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	l, _ := newTestRateLimiter(t, `{"MaxConcurrentRequests": 1, "TrustedProxies": ["10.0.0.1", "192.168.0.0/16"]}`)
+
+	for _, test := range []struct {
+		remoteAddr   string
+		forwardedFor string
+		client       string
+	}{
+		{"1.2.3.4:1234", "", "1.2.3.4"},
+		// untrusted connections can't pick their identity
+		{"1.2.3.4:1234", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1:1234", "5.6.7.8", "5.6.7.8"},
+		{"10.0.0.1:1234", "9.9.9.9, 5.6.7.8, 192.168.1.1", "5.6.7.8"},
+		{"10.0.0.1:1234", "nope, 5.6.7.8", "5.6.7.8"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"[::1]:1234", "5.6.7.8", "::1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if client := l.clientIP(req); client != test.client {
+			t.Errorf("%s %q: got client %s, expected %s", test.remoteAddr, test.forwardedFor, client, test.client)
+		}
+	}
+}
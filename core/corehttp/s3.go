@@ -0,0 +1,802 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	gopath "path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	core "github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	ipld "github.com/ipfs/go-ipld-format"
+	mfs "github.com/ipfs/go-mfs"
+)
+
+const (
+	s3XMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+	// the most keys returned by a listing, and the default
+	s3MaxKeys = 1000
+)
+
+// MFS doesn't record modification times, so objects are all dated from the
+// epoch
+var s3LastModified = time.Unix(0, 0).UTC()
+
+var s3BucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// S3Option serves an S3-compatible API over the MFS root of the node, with
+// path-style addressing: buckets are the top-level directories of MFS, and
+// keys are the paths of files within them. Without S3.AccessKeys, the API is
+// read-only unless it listens on the local host.
+func S3Option() ServeOption {
+	return func(n *core.IpfsNode, lis net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		readOnly := len(cfg.S3.AccessKeys) == 0 && !IsLocalListener(lis)
+		if readOnly {
+			log.Errorf("S3 API on %s has no S3.AccessKeys: serving MFS read-only to anyone who can reach it. Set S3.AccessKeys to allow writes.", lis.Addr())
+		}
+		mux.Handle("/", &s3Handler{
+			node:       n,
+			accessKeys: cfg.S3.AccessKeys,
+			readOnly:   readOnly,
+			now:        time.Now,
+		})
+		return mux, nil
+	}
+}
+
+type s3Handler struct {
+	node       *core.IpfsNode
+	accessKeys map[string]string // access key IDs to secrets, no auth when empty
+	readOnly   bool              // rejects the requests modifying MFS
+	now        func() time.Time
+}
+
+// s3Error is an error of the S3 API, with its HTTP status and error code
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+func newS3Error(status int, code, message string) *s3Error {
+	return &s3Error{status: status, code: code, message: message}
+}
+
+func (e *s3Error) Error() string {
+	return e.code + ": " + e.message
+}
+
+var (
+	errS3NoSuchBucket   = newS3Error(http.StatusNotFound, "NoSuchBucket", "the bucket doesn't exist")
+	errS3NoSuchKey      = newS3Error(http.StatusNotFound, "NoSuchKey", "the key doesn't exist")
+	errS3NoSuchUpload   = newS3Error(http.StatusNotFound, "NoSuchUpload", "the multipart upload doesn't exist")
+	errS3InvalidKey     = newS3Error(http.StatusBadRequest, "InvalidArgument", "keys can't have empty, \".\" or \"..\" path segments")
+	errS3NotImplemented = newS3Error(http.StatusNotImplemented, "NotImplemented", "the operation is not supported")
+	errS3KeyConflict    = newS3Error(http.StatusConflict, "ObjectConflict", "a parent of the key is an object, or the key is a prefix of other objects")
+)
+
+func (h *s3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.serve(w, r); err != nil {
+		var s3err *s3Error
+		if !errors.As(err, &s3err) {
+			log.Errorf("s3: %s %s: %s", r.Method, r.URL.Path, err)
+			s3err = newS3Error(http.StatusInternalServerError, "InternalError", err.Error())
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(s3err.status)
+		if r.Method != http.MethodHead {
+			xml.NewEncoder(w).Encode(struct {
+				XMLName  xml.Name `xml:"Error"`
+				Code     string
+				Message  string
+				Resource string
+			}{Code: s3err.code, Message: s3err.message, Resource: r.URL.Path})
+		}
+	}
+}
+
+func (h *s3Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	var sig *s3Signature
+	if len(h.accessKeys) > 0 {
+		var err error
+		if sig, err = verifyS3Signature(r, h.accessKeys, h.now()); err != nil {
+			return err
+		}
+	}
+	if h.readOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return newS3Error(http.StatusForbidden, "AccessDenied", "the API is read-only without access keys")
+	}
+	body, err := s3PayloadReader(r, sig)
+	if err != nil {
+		return err
+	}
+
+	bucket, key := strings.TrimPrefix(r.URL.Path, "/"), ""
+	if i := strings.IndexByte(bucket, '/'); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	query := r.URL.Query()
+	_, uploads := query["uploads"]
+	uploadID := query.Get("uploadId")
+
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		return h.listBuckets(w, r)
+	case bucket == "":
+		return errS3NotImplemented
+	case !s3BucketName.MatchString(bucket):
+		return newS3Error(http.StatusBadRequest, "InvalidBucketName", "invalid bucket name")
+	case key == "":
+		switch r.Method {
+		case http.MethodGet:
+			if _, ok := query["location"]; ok {
+				return writeS3XML(w, struct {
+					XMLName xml.Name `xml:"LocationConstraint"`
+					XMLNS   string   `xml:"xmlns,attr"`
+				}{XMLNS: s3XMLNamespace})
+			}
+			if uploads {
+				return errS3NotImplemented
+			}
+			return h.listObjects(w, r, bucket)
+		case http.MethodHead:
+			_, err := h.bucketDir(bucket)
+			return err
+		case http.MethodPut:
+			return h.createBucket(w, r, bucket)
+		case http.MethodDelete:
+			return h.deleteBucket(w, r, bucket)
+		case http.MethodPost:
+			if _, ok := query["delete"]; ok {
+				return h.deleteObjects(w, r, bucket, body)
+			}
+		}
+	case !validS3Key(key):
+		return errS3InvalidKey
+	default:
+		switch {
+		case r.Method == http.MethodPut && uploadID != "":
+			if r.Header.Get("X-Amz-Copy-Source") != "" {
+				return errS3NotImplemented
+			}
+			return h.uploadPart(w, r, bucket, key, uploadID, body)
+		case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+			return h.copyObject(w, r, bucket, key)
+		case r.Method == http.MethodPut:
+			return h.putObject(w, r, bucket, key, body)
+		case (r.Method == http.MethodGet || r.Method == http.MethodHead) && uploadID == "":
+			return h.getObject(w, r, bucket, key)
+		case r.Method == http.MethodDelete && uploadID != "":
+			return h.abortMultipartUpload(w, r, bucket, key, uploadID)
+		case r.Method == http.MethodDelete:
+			return h.deleteObject(w, r, bucket, key)
+		case r.Method == http.MethodPost && uploads:
+			return h.createMultipartUpload(w, r, bucket, key)
+		case r.Method == http.MethodPost && uploadID != "":
+			return h.completeMultipartUpload(w, r, bucket, key, uploadID, body)
+		}
+	}
+	return errS3NotImplemented
+}
+
+// validS3Key checks that a key maps to an MFS path, a trailing slash
+// denotes a directory
+func validS3Key(key string) bool {
+	for _, segment := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func writeS3XML(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/xml")
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func s3ETag(nd ipld.Node) string {
+	return `"` + nd.Cid().String() + `"`
+}
+
+// lookup looks up an MFS path, which doesn't exist when one of its parents is
+// a file
+func (h *s3Handler) lookup(p string) (mfs.FSNode, error) {
+	var fsn mfs.FSNode = h.node.FilesRoot.GetDirectory()
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		dir, ok := fsn.(*mfs.Directory)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		child, err := dir.Child(name)
+		if err != nil {
+			return nil, err
+		}
+		fsn = child
+	}
+	return fsn, nil
+}
+
+// mkdirAll creates the MFS directory p and its parents, like
+// `ipfs files mkdir -p`, unless one of them is a file
+func (h *s3Handler) mkdirAll(p string) (*mfs.Directory, error) {
+	dir := h.node.FilesRoot.GetDirectory()
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		child, err := dir.Child(name)
+		if err == os.ErrNotExist {
+			child, err = dir.Mkdir(name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if dir, ok = child.(*mfs.Directory); !ok {
+			return nil, errS3KeyConflict
+		}
+	}
+	return dir, nil
+}
+
+func (h *s3Handler) bucketDir(bucket string) (*mfs.Directory, error) {
+	fsn, err := h.lookup(bucket)
+	if err == os.ErrNotExist {
+		return nil, errS3NoSuchBucket
+	}
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, errS3NoSuchBucket
+	}
+	return dir, nil
+}
+
+// lookupObject returns the file of key, or the directory of keys with a
+// trailing slash
+func (h *s3Handler) lookupObject(bucket, key string) (mfs.FSNode, error) {
+	if _, err := h.bucketDir(bucket); err != nil {
+		return nil, err
+	}
+	fsn, err := h.lookup(gopath.Join(bucket, key))
+	if err == os.ErrNotExist {
+		return nil, errS3NoSuchKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, isDir := fsn.(*mfs.Directory); isDir != strings.HasSuffix(key, "/") {
+		return nil, errS3NoSuchKey
+	}
+	return fsn, nil
+}
+
+func (h *s3Handler) listBuckets(w http.ResponseWriter, r *http.Request) error {
+	type bucket struct {
+		Name         string
+		CreationDate time.Time
+	}
+	var buckets []bucket
+	err := h.node.FilesRoot.GetDirectory().ForEachEntry(r.Context(), func(nl mfs.NodeListing) error {
+		if nl.Type == int(mfs.TDir) && s3BucketName.MatchString(nl.Name) {
+			buckets = append(buckets, bucket{Name: nl.Name, CreationDate: s3LastModified})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	return writeS3XML(w, struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		Owner   struct {
+			ID          string
+			DisplayName string
+		}
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{
+		XMLNS: s3XMLNamespace,
+		Owner: struct {
+			ID          string
+			DisplayName string
+		}{h.node.Identity.String(), h.node.Identity.String()},
+		Buckets: buckets,
+	})
+}
+
+func (h *s3Handler) createBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	err := mfs.Mkdir(h.node.FilesRoot, "/"+bucket, mfs.MkdirOpts{Flush: true})
+	if err == os.ErrExist {
+		return newS3Error(http.StatusConflict, "BucketAlreadyOwnedByYou", "the bucket already exists")
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/"+bucket)
+	return nil
+}
+
+func (h *s3Handler) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	dir, err := h.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+	names, err := dir.ListNames(r.Context())
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return newS3Error(http.StatusConflict, "BucketNotEmpty", "the bucket is not empty")
+	}
+	root := h.node.FilesRoot.GetDirectory()
+	if err := root.Unlink(bucket); err != nil {
+		return err
+	}
+	if err := root.Flush(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// addFile adds the content of body like `ipfs add`, and links it at the MFS
+// path p. The blocks are protected from GC until they are linked.
+func (h *s3Handler) addFile(ctx context.Context, p string, body io.Reader) (ipld.Node, error) {
+	defer h.node.Blockstore.PinLock(ctx).Unlock(ctx)
+
+	adder, err := coreunix.NewAdder(ctx, h.node.Pinning, h.node.Blockstore, h.node.DAG)
+	if err != nil {
+		return nil, err
+	}
+	adder.Pin = false
+	nd, err := adder.AddAllAndPin(ctx, files.NewReaderFile(body))
+	if err != nil {
+		return nil, err
+	}
+	return nd, h.putNode(ctx, p, nd)
+}
+
+// putNode links nd at the MFS path p, like `ipfs files cp`, replacing the
+// file at p and creating its parent directories
+func (h *s3Handler) putNode(ctx context.Context, p string, nd ipld.Node) error {
+	dir, err := h.mkdirAll(gopath.Dir(p))
+	if err != nil {
+		return err
+	}
+	name := gopath.Base(p)
+	switch child, err := dir.Child(name); {
+	case err == os.ErrNotExist:
+	case err != nil:
+		return err
+	default:
+		if _, isDir := child.(*mfs.Directory); isDir {
+			return errS3KeyConflict
+		}
+		if err := dir.Unlink(name); err != nil {
+			return err
+		}
+	}
+	if err := dir.AddChild(name, nd); err != nil {
+		return err
+	}
+	_, err = mfs.FlushPath(ctx, h.node.FilesRoot, p)
+	return err
+}
+
+func (h *s3Handler) putObject(w http.ResponseWriter, r *http.Request, bucket, key string, body io.Reader) error {
+	if _, err := h.bucketDir(bucket); err != nil {
+		return err
+	}
+	p := gopath.Join("/", bucket, key)
+
+	// keys with a trailing slash are directories, like the "folders" of S3
+	// consoles
+	if strings.HasSuffix(key, "/") {
+		if n, err := io.Copy(io.Discard, body); err != nil || n > 0 {
+			if err != nil {
+				return err
+			}
+			return newS3Error(http.StatusBadRequest, "InvalidArgument", "keys ending with a slash are directories, without content")
+		}
+		if _, err := h.mkdirAll(p); err != nil {
+			return err
+		}
+		nd, err := mfs.FlushPath(r.Context(), h.node.FilesRoot, p)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", s3ETag(nd))
+		return nil
+	}
+
+	nd, err := h.addFile(r.Context(), p, body)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", s3ETag(nd))
+	return nil
+}
+
+func (h *s3Handler) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	srcBucket, srcKey, err := s3CopySource(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return newS3Error(http.StatusBadRequest, "InvalidArgument", err.Error())
+	}
+	if strings.HasSuffix(key, "/") || strings.HasSuffix(srcKey, "/") || !validS3Key(srcKey) {
+		return errS3InvalidKey
+	}
+	src, err := h.lookupObject(srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+	if _, err := h.bucketDir(bucket); err != nil {
+		return err
+	}
+	nd, err := src.GetNode()
+	if err != nil {
+		return err
+	}
+	if err := h.putNode(r.Context(), gopath.Join("/", bucket, key), nd); err != nil {
+		return err
+	}
+	return writeS3XML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		XMLNS        string   `xml:"xmlns,attr"`
+		ETag         string
+		LastModified time.Time
+	}{XMLNS: s3XMLNamespace, ETag: s3ETag(nd), LastModified: s3LastModified})
+}
+
+// s3CopySource parses X-Amz-Copy-Source into a bucket and key
+func s3CopySource(header string) (string, string, error) {
+	source, err := url.PathUnescape(strings.SplitN(header, "?", 2)[0])
+	if err != nil {
+		return "", "", errors.New("invalid X-Amz-Copy-Source")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", errors.New("invalid X-Amz-Copy-Source")
+	}
+	return parts[0], parts[1], nil
+}
+
+func (h *s3Handler) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	fsn, err := h.lookupObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", s3ETag(nd))
+	w.Header().Set("Last-Modified", s3LastModified.Format(http.TimeFormat))
+
+	var content io.ReadSeeker = strings.NewReader("")
+	if file, ok := fsn.(*mfs.File); ok {
+		fd, err := file.Open(mfs.Flags{Read: true})
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		content = fd
+	}
+	http.ServeContent(w, r, gopath.Base(key), time.Time{}, content)
+	return nil
+}
+
+func (h *s3Handler) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if err := h.removeObject(r.Context(), bucket, key); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// removeObject removes the file of key, like `ipfs files rm`, and the
+// directories left empty up to the bucket. Directories of keys with a trailing
+// slash are only removed when empty. Missing keys are not an error.
+func (h *s3Handler) removeObject(ctx context.Context, bucket, key string) error {
+	fsn, err := h.lookupObject(bucket, key)
+	if err == errS3NoSuchKey {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dir, ok := fsn.(*mfs.Directory); ok {
+		names, err := dir.ListNames(ctx)
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			return nil
+		}
+	}
+
+	p := gopath.Join("/", bucket, key)
+	for p != "/"+bucket {
+		parent, err := h.lookup(gopath.Dir(p))
+		if err != nil {
+			return err
+		}
+		pdir := parent.(*mfs.Directory)
+		if err := pdir.Unlink(gopath.Base(p)); err != nil && err != os.ErrNotExist {
+			return err
+		}
+		if err := pdir.Flush(); err != nil {
+			return err
+		}
+		names, err := pdir.ListNames(ctx)
+		if err != nil || len(names) > 0 {
+			return err
+		}
+		p = gopath.Dir(p)
+	}
+	return nil
+}
+
+func (h *s3Handler) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string, body io.Reader) error {
+	var req struct {
+		Quiet   bool
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&req); err != nil {
+		return newS3Error(http.StatusBadRequest, "MalformedXML", err.Error())
+	}
+	if len(req.Objects) > s3MaxKeys {
+		return newS3Error(http.StatusBadRequest, "MalformedXML", "too many objects")
+	}
+	if _, err := h.bucketDir(bucket); err != nil {
+		return err
+	}
+
+	type deleteError struct {
+		Key     string
+		Code    string
+		Message string
+	}
+	var res struct {
+		XMLName xml.Name      `xml:"DeleteResult"`
+		XMLNS   string        `xml:"xmlns,attr"`
+		Deleted []string      `xml:"Deleted>Key"`
+		Errors  []deleteError `xml:"Error"`
+	}
+	res.XMLNS = s3XMLNamespace
+	for _, o := range req.Objects {
+		var err error = errS3InvalidKey
+		if validS3Key(o.Key) {
+			err = h.removeObject(r.Context(), bucket, o.Key)
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, deleteError{Key: o.Key, Code: "InternalError", Message: err.Error()})
+			continue
+		}
+		if !req.Quiet {
+			res.Deleted = append(res.Deleted, o.Key)
+		}
+	}
+	return writeS3XML(w, res)
+}
+
+type s3Object struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+// walkObjects returns the objects of a bucket with the given prefix: files,
+// and empty directories as keys with a trailing slash
+func (h *s3Handler) walkObjects(ctx context.Context, dir *mfs.Directory, keyPrefix, prefix string, objects []s3Object) ([]s3Object, error) {
+	names, err := dir.ListNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 && keyPrefix != "" && strings.HasPrefix(keyPrefix, prefix) {
+		nd, err := dir.GetNode()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, s3Object{Key: keyPrefix, ETag: s3ETag(nd), LastModified: s3LastModified, StorageClass: "STANDARD"})
+	}
+	for _, name := range names {
+		key := keyPrefix + name
+		child, err := dir.Child(name)
+		if err != nil {
+			return nil, err
+		}
+		switch child := child.(type) {
+		case *mfs.Directory:
+			if strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/") {
+				if objects, err = h.walkObjects(ctx, child, key+"/", prefix, objects); err != nil {
+					return nil, err
+				}
+			}
+		case *mfs.File:
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			nd, err := child.GetNode()
+			if err != nil {
+				return nil, err
+			}
+			size, err := child.Size()
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, s3Object{Key: key, ETag: s3ETag(nd), Size: size, LastModified: s3LastModified, StorageClass: "STANDARD"})
+		}
+	}
+	return objects, nil
+}
+
+// listObjects implements ListObjectsV2, and ListObjects (v1) which only
+// differs by its parameters. Every listing walks the bucket, from the
+// directories matching the prefix.
+func (h *s3Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	query := r.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	encodeURL := query.Get("encoding-type") == "url"
+
+	maxKeys := s3MaxKeys
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return newS3Error(http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	var after string
+	switch {
+	case !v2:
+		after = query.Get("marker")
+	case query.Get("continuation-token") != "":
+		token, err := base64.RawURLEncoding.DecodeString(query.Get("continuation-token"))
+		if err != nil {
+			return newS3Error(http.StatusBadRequest, "InvalidArgument", "invalid continuation-token")
+		}
+		after = string(token)
+	default:
+		after = query.Get("start-after")
+	}
+
+	dir, err := h.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+	objects, err := h.walkObjects(r.Context(), dir, "", prefix, nil)
+	if err != nil {
+		return err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	var contents []s3Object
+	var commonPrefixes []string
+	var last string
+	truncated := false
+	for _, o := range objects {
+		if o.Key <= after {
+			continue
+		}
+		item, isPrefix := o.Key, false
+		if delimiter != "" {
+			if i := strings.Index(o.Key[len(prefix):], delimiter); i >= 0 {
+				item, isPrefix = o.Key[:len(prefix)+i+len(delimiter)], true
+				if item <= after || item == last {
+					continue
+				}
+			}
+		}
+		if len(contents)+len(commonPrefixes) == maxKeys {
+			truncated = true
+			break
+		}
+		if isPrefix {
+			commonPrefixes = append(commonPrefixes, item)
+		} else {
+			contents = append(contents, o)
+		}
+		last = item
+	}
+
+	encode := func(s string) string {
+		if encodeURL {
+			return s3URIEncode(s, false)
+		}
+		return s
+	}
+	for i := range contents {
+		contents[i].Key = encode(contents[i].Key)
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	cps := make([]commonPrefix, len(commonPrefixes))
+	for i, p := range commonPrefixes {
+		cps[i].Prefix = encode(p)
+	}
+
+	res := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		XMLNS                 string   `xml:"xmlns,attr"`
+		Name                  string
+		Prefix                string
+		Marker                *string `xml:",omitempty"`
+		NextMarker            string  `xml:",omitempty"`
+		StartAfter            string  `xml:",omitempty"`
+		ContinuationToken     string  `xml:",omitempty"`
+		NextContinuationToken string  `xml:",omitempty"`
+		KeyCount              *int    `xml:",omitempty"`
+		MaxKeys               int
+		Delimiter             string `xml:",omitempty"`
+		EncodingType          string `xml:",omitempty"`
+		IsTruncated           bool
+		Contents              []s3Object
+		CommonPrefixes        []commonPrefix
+	}{
+		XMLNS:          s3XMLNamespace,
+		Name:           bucket,
+		Prefix:         encode(prefix),
+		MaxKeys:        maxKeys,
+		Delimiter:      encode(delimiter),
+		EncodingType:   query.Get("encoding-type"),
+		IsTruncated:    truncated,
+		Contents:       contents,
+		CommonPrefixes: cps,
+	}
+	if v2 {
+		keyCount := len(contents) + len(cps)
+		res.KeyCount = &keyCount
+		res.StartAfter = encode(query.Get("start-after"))
+		res.ContinuationToken = query.Get("continuation-token")
+		if truncated {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		marker := encode(after)
+		res.Marker = &marker
+		if truncated {
+			res.NextMarker = encode(last)
+		}
+	}
+	return writeS3XML(w, res)
+}
+
+// checkS3Part returns the 1-based part number of a multipart upload
+func checkS3Part(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 10000 {
+		return 0, newS3Error(http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("invalid part number %q", s))
+	}
+	return n, nil
+}
@@ -0,0 +1,357 @@
+package corehttp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3SignatureAlgorithm = "AWS4-HMAC-SHA256"
+	s3TimeFormat         = "20060102T150405Z"
+
+	// requests signed further away from the local time are rejected
+	s3MaxClockSkew = 15 * time.Minute
+
+	// larger chunks of aws-chunked payloads are rejected
+	s3MaxChunkSize = 16 << 20
+
+	s3UnsignedPayload          = "UNSIGNED-PAYLOAD"
+	s3StreamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	s3StreamingUnsignedPayload = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// the SHA-256 of an empty payload
+var s3EmptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// s3Signature is a verified AWS Signature Version 4 of a request, which also
+// signs the chunks of streaming payloads
+type s3Signature struct {
+	key       []byte
+	amzDate   string
+	scope     string
+	signature string
+}
+
+// verifyS3Signature checks the Authorization header of r against the secret
+// of its access key
+func verifyS3Signature(r *http.Request, accessKeys map[string]string, now time.Time) (*s3Signature, error) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return nil, newS3Error(http.StatusForbidden, "AccessDenied", "anonymous requests are not allowed")
+	}
+	if !strings.HasPrefix(auth, s3SignatureAlgorithm+" ") {
+		return nil, newS3Error(http.StatusBadRequest, "InvalidRequest", "only AWS Signature Version 4 is supported")
+	}
+	var credential, signedHeaders, signature string
+	for _, field := range strings.Split(strings.TrimPrefix(auth, s3SignatureAlgorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "Credential":
+			credential = kv[1]
+		case "SignedHeaders":
+			signedHeaders = kv[1]
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+	cred := strings.Split(credential, "/")
+	if len(cred) != 5 || cred[3] != "s3" || cred[4] != "aws4_request" || signedHeaders == "" || signature == "" {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", "malformed Authorization header")
+	}
+	secret, ok := accessKeys[cred[0]]
+	if !ok {
+		return nil, newS3Error(http.StatusForbidden, "InvalidAccessKeyId", "unknown access key")
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse(s3TimeFormat, amzDate)
+	if err != nil {
+		return nil, newS3Error(http.StatusForbidden, "AccessDenied", "missing or invalid X-Amz-Date header")
+	}
+	if t.Sub(now) > s3MaxClockSkew || now.Sub(t) > s3MaxClockSkew {
+		return nil, newS3Error(http.StatusForbidden, "RequestTimeTooSkewed", "the request time is too far from the server time")
+	}
+	if cred[1] != amzDate[:8] {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", "the credential date doesn't match X-Amz-Date")
+	}
+
+	headers := strings.Split(signedHeaders, ";")
+	if i := sort.SearchStrings(headers, "host"); !sort.StringsAreSorted(headers) || i == len(headers) || headers[i] != "host" {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", "SignedHeaders has to be sorted and include host")
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = s3UnsignedPayload
+	}
+	sig := &s3Signature{
+		key:     s3SigningKey(secret, cred[1], cred[2]),
+		amzDate: amzDate,
+		scope:   strings.Join(cred[1:], "/"),
+	}
+	sig.signature = sig.sign(s3CanonicalRequest(r, headers, payloadHash))
+	if !hmac.Equal([]byte(sig.signature), []byte(signature)) {
+		return nil, newS3Error(http.StatusForbidden, "SignatureDoesNotMatch", "the request signature doesn't match")
+	}
+	return sig, nil
+}
+
+func s3SigningKey(secret, date, region string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, s := range []string{date, region, "s3", "aws4_request"} {
+		key = s3HMAC(key, s)
+	}
+	return key
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func s3SHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign returns the signature of a canonical request
+func (s *s3Signature) sign(canonicalRequest string) string {
+	return s.signString(strings.Join([]string{
+		s3SignatureAlgorithm,
+		s.amzDate,
+		s.scope,
+		s3SHA256([]byte(canonicalRequest)),
+	}, "\n"))
+}
+
+// signChunk returns the signature of a chunk of a streaming payload, which
+// chains the signature of the previous chunk
+func (s *s3Signature) signChunk(prevSignature string, chunk []byte) string {
+	return s.signString(strings.Join([]string{
+		s3SignatureAlgorithm + "-PAYLOAD",
+		s.amzDate,
+		s.scope,
+		prevSignature,
+		s3EmptySHA256,
+		s3SHA256(chunk),
+	}, "\n"))
+}
+
+func (s *s3Signature) signString(stringToSign string) string {
+	return hex.EncodeToString(s3HMAC(s.key, stringToSign))
+}
+
+// s3CanonicalRequest returns the canonical form of r signed by AWS Signature
+// Version 4
+func s3CanonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(s3URIEncode(r.URL.Path, false) + "\n")
+
+	query := r.URL.Query()
+	params := make([]string, 0, len(query))
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, s3URIEncode(k, true)+"="+s3URIEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	b.WriteString(strings.Join(params, "&") + "\n")
+
+	for _, h := range signedHeaders {
+		var value string
+		switch h {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			var values []string
+			for _, v := range r.Header.Values(h) {
+				values = append(values, strings.Join(strings.Fields(v), " "))
+			}
+			value = strings.Join(values, ",")
+		}
+		b.WriteString(h + ":" + value + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(strings.Join(signedHeaders, ";") + "\n")
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+// s3URIEncode escapes everything but the unreserved characters of RFC 3986,
+// and slashes unless encodeSlash is set
+func s3URIEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+// s3PayloadReader decodes the body of r according to X-Amz-Content-Sha256,
+// and fails at the end of the body if it doesn't match its hashes, chunk
+// signatures or Content-MD5. Chunk signatures are only verified when sig is
+// set.
+func s3PayloadReader(r *http.Request, sig *s3Signature) (io.Reader, error) {
+	var body io.Reader = r.Body
+	switch payloadHash := r.Header.Get("X-Amz-Content-Sha256"); payloadHash {
+	case "", s3UnsignedPayload:
+	case s3StreamingPayload:
+		body = &s3ChunkedReader{r: bufio.NewReader(r.Body), sig: sig, prevSignature: sig.seedSignature()}
+	case s3StreamingUnsignedPayload:
+		body = &s3ChunkedReader{r: bufio.NewReader(r.Body), trailer: true}
+	default:
+		sum, err := hex.DecodeString(payloadHash)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, newS3Error(http.StatusBadRequest, "InvalidArgument", "unsupported X-Amz-Content-Sha256")
+		}
+		body = &s3DigestReader{r: body, h: sha256.New(), sum: sum,
+			err: newS3Error(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "the payload doesn't match X-Amz-Content-Sha256")}
+	}
+
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		sum, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(sum) != md5.Size {
+			return nil, newS3Error(http.StatusBadRequest, "InvalidDigest", "invalid Content-MD5")
+		}
+		body = &s3DigestReader{r: body, h: md5.New(), sum: sum,
+			err: newS3Error(http.StatusBadRequest, "BadDigest", "the payload doesn't match Content-MD5")}
+	}
+	return body, nil
+}
+
+func (s *s3Signature) seedSignature() string {
+	if s == nil {
+		return ""
+	}
+	return s.signature
+}
+
+// s3DigestReader fails with err at EOF when the digest of what was read
+// isn't sum
+type s3DigestReader struct {
+	r   io.Reader
+	h   hash.Hash
+	sum []byte
+	err error
+}
+
+func (d *s3DigestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(d.h.Sum(nil), d.sum) {
+		return n, d.err
+	}
+	return n, err
+}
+
+// s3ChunkedReader decodes aws-chunked payloads: signed chunks, or unsigned
+// chunks followed by trailing headers, which are ignored
+type s3ChunkedReader struct {
+	r             *bufio.Reader
+	sig           *s3Signature // nil when the chunks aren't verified
+	prevSignature string
+	trailer       bool
+
+	chunk []byte // unread data of the current chunk
+	err   error
+}
+
+var errS3ChunkedEncoding = newS3Error(http.StatusBadRequest, "IncompleteBody", "invalid aws-chunked payload")
+
+func (c *s3ChunkedReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.chunk, c.err = c.readChunk()
+	}
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// readChunk returns the data of the next chunk, and io.EOF after the last one
+func (c *s3ChunkedReader) readChunk() ([]byte, error) {
+	header, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	sizeHex, ext := header, ""
+	if i := strings.IndexByte(header, ';'); i >= 0 {
+		sizeHex, ext = header[:i], header[i+1:]
+	}
+	size, err := strconv.ParseUint(sizeHex, 16, 32)
+	if err != nil || size > s3MaxChunkSize {
+		return nil, errS3ChunkedEncoding
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, errS3ChunkedEncoding
+	}
+
+	if c.sig != nil {
+		signature := strings.TrimPrefix(ext, "chunk-signature=")
+		expected := c.sig.signChunk(c.prevSignature, data)
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil, newS3Error(http.StatusForbidden, "SignatureDoesNotMatch", "the chunk signature doesn't match")
+		}
+		c.prevSignature = expected
+	}
+
+	if size == 0 && c.trailer {
+		// trailing headers, up to an empty line
+		for {
+			line, err := c.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if line == "" {
+				return nil, io.EOF
+			}
+		}
+	}
+	if line, err := c.readLine(); err != nil || line != "" {
+		return nil, errS3ChunkedEncoding
+	}
+	if size == 0 {
+		return nil, io.EOF
+	}
+	return data, nil
+}
+
+func (c *s3ChunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil || !strings.HasSuffix(line, "\r\n") {
+		return "", errS3ChunkedEncoding
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
@@ -0,0 +1,226 @@
+package corehttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	gopath "path"
+	"regexp"
+	"strings"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	mfs "github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+// Parts of multipart uploads are stored in MFS until the upload completes, so
+// that they survive restarts and GC. The directory is not a valid bucket name.
+const (
+	s3UploadsDir       = "/.s3-uploads"
+	s3UploadTargetFile = "target"
+)
+
+var s3UploadID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func s3PartName(n int) string {
+	return fmt.Sprintf("part-%05d", n)
+}
+
+func (h *s3Handler) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if strings.HasSuffix(key, "/") {
+		return errS3InvalidKey
+	}
+	if _, err := h.bucketDir(bucket); err != nil {
+		return err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(id)
+	dir, err := h.mkdirAll(gopath.Join(s3UploadsDir, uploadID))
+	if err != nil {
+		return err
+	}
+	target := bucket + "/" + key
+	if err := dir.AddChild(s3UploadTargetFile, dag.NodeWithData(ft.FilePBData([]byte(target), uint64(len(target))))); err != nil {
+		return err
+	}
+	if err := dir.Flush(); err != nil {
+		return err
+	}
+
+	return writeS3XML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		XMLNS    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{XMLNS: s3XMLNamespace, Bucket: bucket, Key: key, UploadId: uploadID})
+}
+
+// uploadDir returns the directory of an upload to the given key
+func (h *s3Handler) uploadDir(bucket, key, uploadID string) (*mfs.Directory, error) {
+	if !s3UploadID.MatchString(uploadID) {
+		return nil, errS3NoSuchUpload
+	}
+	fsn, err := h.lookup(gopath.Join(s3UploadsDir, uploadID, s3UploadTargetFile))
+	if err != nil {
+		return nil, errS3NoSuchUpload
+	}
+	file, ok := fsn.(*mfs.File)
+	if !ok {
+		return nil, errS3NoSuchUpload
+	}
+	fd, err := file.Open(mfs.Flags{Read: true})
+	if err != nil {
+		return nil, err
+	}
+	target, err := ioutil.ReadAll(fd)
+	fd.Close()
+	if err != nil {
+		return nil, err
+	}
+	if string(target) != bucket+"/"+key {
+		return nil, errS3NoSuchUpload
+	}
+	dir, err := h.lookup(gopath.Join(s3UploadsDir, uploadID))
+	if err != nil {
+		return nil, err
+	}
+	return dir.(*mfs.Directory), nil
+}
+
+func (h *s3Handler) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string, body io.Reader) error {
+	n, err := checkS3Part(r.URL.Query().Get("partNumber"))
+	if err != nil {
+		return err
+	}
+	if _, err := h.uploadDir(bucket, key, uploadID); err != nil {
+		return err
+	}
+	nd, err := h.addFile(r.Context(), gopath.Join(s3UploadsDir, uploadID, s3PartName(n)), body)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", s3ETag(nd))
+	return nil
+}
+
+// completeMultipartUpload links the file made of the given parts at the key.
+// The parts are not copied: the file links their DAGs.
+func (h *s3Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string, body io.Reader) error {
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&req); err != nil {
+		return newS3Error(http.StatusBadRequest, "MalformedXML", err.Error())
+	}
+	if len(req.Parts) == 0 {
+		return newS3Error(http.StatusBadRequest, "MalformedXML", "no parts")
+	}
+
+	ctx := r.Context()
+	defer h.node.Blockstore.PinLock(ctx).Unlock(ctx)
+
+	dir, err := h.uploadDir(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	var nodes []ipld.Node
+	fsn := ft.NewFSNode(ft.TFile)
+	for i, part := range req.Parts {
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			return newS3Error(http.StatusBadRequest, "InvalidPartOrder", "the parts have to be in ascending order")
+		}
+		errInvalidPart := newS3Error(http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was not uploaded, or its ETag doesn't match", part.PartNumber))
+		child, err := dir.Child(s3PartName(part.PartNumber))
+		if err != nil {
+			return errInvalidPart
+		}
+		file, ok := child.(*mfs.File)
+		if !ok {
+			return errInvalidPart
+		}
+		nd, err := file.GetNode()
+		if err != nil {
+			return err
+		}
+		if strings.Trim(part.ETag, `"`) != nd.Cid().String() {
+			return errInvalidPart
+		}
+		size, err := file.Size()
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, nd)
+		fsn.AddBlockSize(uint64(size))
+	}
+
+	nd := nodes[0]
+	if len(nodes) > 1 {
+		data, err := fsn.GetBytes()
+		if err != nil {
+			return err
+		}
+		file := dag.NodeWithData(data)
+		for _, part := range nodes {
+			if err := file.AddNodeLink("", part); err != nil {
+				return err
+			}
+		}
+		if err := h.node.DAG.Add(ctx, file); err != nil {
+			return err
+		}
+		nd = file
+	}
+
+	if err := h.putNode(ctx, gopath.Join("/", bucket, key), nd); err != nil {
+		return err
+	}
+	if err := h.removeUpload(uploadID); err != nil {
+		return err
+	}
+
+	return writeS3XML(w, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		XMLNS    string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{XMLNS: s3XMLNamespace, Location: "/" + bucket + "/" + key, Bucket: bucket, Key: key, ETag: s3ETag(nd)})
+}
+
+func (h *s3Handler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) error {
+	if _, err := h.uploadDir(bucket, key, uploadID); err != nil {
+		return err
+	}
+	if err := h.removeUpload(uploadID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *s3Handler) removeUpload(uploadID string) error {
+	fsn, err := h.lookup(s3UploadsDir)
+	if err != nil {
+		return err
+	}
+	uploads := fsn.(*mfs.Directory)
+	if err := uploads.Unlink(uploadID); err != nil {
+		return err
+	}
+	return uploads.Flush()
+}
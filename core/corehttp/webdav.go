@@ -0,0 +1,470 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	gopath "path"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	core "github.com/ipfs/go-ipfs/core"
+	dag "github.com/ipfs/go-merkledag"
+	mfs "github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+	"golang.org/x/net/webdav"
+)
+
+var errWebDAVIsDir = errors.New("is a directory")
+
+// WebDAVOption serves the MFS root of the node (`ipfs files`) over WebDAV, as
+// configured by the WebDAV section of the config. Without WebDAV.Users, the
+// server is read-only unless it listens on the local host.
+func WebDAVOption() ServeOption {
+	return func(n *core.IpfsNode, lis net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		base := gopath.Clean("/" + cfg.WebDAV.Root.WithDefault("/"))
+		nd, err := mfs.Lookup(n.FilesRoot, base)
+		if err != nil {
+			return nil, fmt.Errorf("WebDAV.Root %q: %w", base, err)
+		}
+		if _, ok := nd.(*mfs.Directory); !ok {
+			return nil, fmt.Errorf("WebDAV.Root %q is not a directory", base)
+		}
+
+		readOnly := cfg.WebDAV.ReadOnly.WithDefault(false)
+		if len(cfg.WebDAV.Users) == 0 && !IsLocalListener(lis) && !readOnly {
+			log.Errorf("WebDAV server on %s has no WebDAV.Users: serving MFS read-only to anyone who can reach it. Set WebDAV.Users to allow writes.", lis.Addr())
+			readOnly = true
+		}
+
+		fs := &mfsFileSystem{root: n.FilesRoot, base: base}
+		mux.Handle("/", &webdavHandler{
+			fs: fs,
+			handler: &webdav.Handler{
+				FileSystem: fs,
+				LockSystem: webdav.NewMemLS(),
+				Logger: func(r *http.Request, err error) {
+					if err != nil {
+						log.Debugf("webdav: %s %s: %s", r.Method, r.URL.Path, err)
+					}
+				},
+			},
+			readOnly: readOnly,
+			users:    cfg.WebDAV.Users,
+		})
+		return mux, nil
+	}
+}
+
+type webdavHandler struct {
+	fs       *mfsFileSystem
+	handler  *webdav.Handler
+	readOnly bool
+	users    map[string]string
+}
+
+func (h *webdavHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="IPFS MFS"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+	default:
+		if h.readOnly {
+			http.Error(w, "WebDAV server is read-only", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Copies of files made by the webdav package are read and written again
+	// byte by byte. Link the source DAG instead, like `ipfs files cp`, unless
+	// the client holds locks: those are only checked by the webdav package.
+	if r.Method == "COPY" && r.Header.Get("If") == "" {
+		status, err := h.serveCopy(r)
+		w.WriteHeader(status)
+		if status != http.StatusNoContent {
+			w.Write([]byte(webdav.StatusText(status)))
+		}
+		h.handler.Logger(r, err)
+		return
+	}
+
+	h.handler.ServeHTTP(w, r)
+}
+
+// authorized checks the basic auth credentials against WebDAV.Users, when set
+func (h *webdavHandler) authorized(r *http.Request) bool {
+	if len(h.users) == 0 {
+		return true
+	}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	expected, ok := h.users[user]
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+// serveCopy implements COPY (RFC 4918, section 9.8) with mfs.PutNode
+func (h *webdavHandler) serveCopy(r *http.Request) (int, error) {
+	hdr := r.Header.Get("Destination")
+	if hdr == "" {
+		return http.StatusBadRequest, errors.New("webdav: missing Destination header")
+	}
+	u, err := url.Parse(hdr)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return http.StatusBadGateway, errors.New("webdav: Destination on another host")
+	}
+	src, dst := gopath.Clean("/"+r.URL.Path), gopath.Clean("/"+u.Path)
+	if src == dst {
+		return http.StatusForbidden, errors.New("webdav: Destination equals the source")
+	}
+
+	deep := true
+	switch r.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		deep = false
+	default:
+		return http.StatusBadRequest, errors.New("webdav: invalid Depth header")
+	}
+
+	// fail like the webdav package when another client locked the destination
+	now := time.Now()
+	token, err := h.handler.LockSystem.Create(now, webdav.LockDetails{Root: dst, Duration: -1, ZeroDepth: true})
+	if err == webdav.ErrLocked {
+		return http.StatusLocked, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer h.handler.LockSystem.Unlock(now, token)
+
+	srcNode, err := mfs.Lookup(h.fs.root, h.fs.path(src))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	created := true
+	if _, err := h.fs.Stat(r.Context(), dst); err == nil {
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, os.ErrExist
+		}
+		if err := h.fs.RemoveAll(r.Context(), dst); err != nil {
+			return http.StatusForbidden, err
+		}
+		created = false
+	} else if !os.IsNotExist(err) {
+		return http.StatusForbidden, err
+	}
+
+	if _, ok := srcNode.(*mfs.Directory); ok && !deep {
+		err = h.fs.Mkdir(r.Context(), dst, 0)
+	} else {
+		err = h.fs.putNode(r.Context(), dst, srcNode)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusConflict, err
+		}
+		return http.StatusForbidden, err
+	}
+
+	if created {
+		return http.StatusCreated, nil
+	}
+	return http.StatusNoContent, nil
+}
+
+// mfsFileSystem implements webdav.FileSystem over the base directory of MFS
+type mfsFileSystem struct {
+	root *mfs.Root
+	base string
+}
+
+var _ webdav.FileSystem = (*mfsFileSystem)(nil)
+
+// path returns the MFS path of a WebDAV name
+func (fs *mfsFileSystem) path(name string) string {
+	return gopath.Join(fs.base, gopath.Clean("/"+name))
+}
+
+func (fs *mfsFileSystem) parentDir(p string) (*mfs.Directory, error) {
+	nd, err := mfs.Lookup(fs.root, gopath.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+	pdir, ok := nd.(*mfs.Directory)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return pdir, nil
+}
+
+func (fs *mfsFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p := fs.path(name)
+	if p == fs.base {
+		return os.ErrExist
+	}
+	return mfs.Mkdir(fs.root, p, mfs.MkdirOpts{Flush: true})
+}
+
+func (fs *mfsFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := fs.path(name)
+	nd, err := mfs.Lookup(fs.root, p)
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, os.ErrExist
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		nd, err = fs.createFile(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch nd := nd.(type) {
+	case *mfs.Directory:
+		if write {
+			return nil, errWebDAVIsDir
+		}
+		return &mfsWebDAVDir{name: gopath.Base(p), dir: nd}, nil
+	case *mfs.File:
+		fd, err := nd.Open(mfs.Flags{Read: flag&os.O_WRONLY == 0, Write: write, Sync: true})
+		if err != nil {
+			return nil, err
+		}
+		if flag&os.O_TRUNC != 0 {
+			if err := fd.Truncate(0); err != nil {
+				fd.Close()
+				return nil, err
+			}
+		}
+		return &mfsWebDAVFile{name: gopath.Base(p), file: nd, fd: fd, write: write}, nil
+	default:
+		return nil, fmt.Errorf("unrecognized MFS node type %T", nd)
+	}
+}
+
+// createFile creates an empty file, like `ipfs files write --create`
+func (fs *mfsFileSystem) createFile(p string) (mfs.FSNode, error) {
+	pdir, err := fs.parentDir(p)
+	if err != nil {
+		return nil, err
+	}
+	nd := dag.NodeWithData(ft.FilePBData(nil, 0))
+	nd.SetCidBuilder(pdir.GetCidBuilder())
+	if err := pdir.AddChild(gopath.Base(p), nd); err != nil {
+		return nil, err
+	}
+	return pdir.Child(gopath.Base(p))
+}
+
+// RemoveAll removes name, like `ipfs files rm -r`
+func (fs *mfsFileSystem) RemoveAll(ctx context.Context, name string) error {
+	p := fs.path(name)
+	if p == fs.base {
+		return os.ErrPermission
+	}
+	pdir, err := fs.parentDir(p)
+	if err != nil {
+		return err
+	}
+	if err := pdir.Unlink(gopath.Base(p)); err != nil {
+		return err
+	}
+	return pdir.Flush()
+}
+
+// Rename moves oldName to newName, like `ipfs files mv`
+func (fs *mfsFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	src, dst := fs.path(oldName), fs.path(newName)
+	if src == fs.base || dst == fs.base {
+		return os.ErrPermission
+	}
+	if err := mfs.Mv(fs.root, src, dst); err != nil {
+		return err
+	}
+	_, err := mfs.FlushPath(ctx, fs.root, "/")
+	return err
+}
+
+func (fs *mfsFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	nd, err := mfs.Lookup(fs.root, fs.path(name))
+	if err != nil {
+		return nil, err
+	}
+	return newMFSFileInfo(gopath.Base(name), nd)
+}
+
+// putNode links the DAG of nd at name, like `ipfs files cp`
+func (fs *mfsFileSystem) putNode(ctx context.Context, name string, nd mfs.FSNode) error {
+	p := fs.path(name)
+	if _, err := fs.parentDir(p); err != nil {
+		return err
+	}
+	dagNode, err := nd.GetNode()
+	if err != nil {
+		return err
+	}
+	if err := mfs.PutNode(fs.root, p, dagNode); err != nil {
+		return err
+	}
+	_, err = mfs.FlushPath(ctx, fs.root, p)
+	return err
+}
+
+// mfsFileInfo implements os.FileInfo and webdav.ETager, with the CID of the
+// node as the ETag
+type mfsFileInfo struct {
+	name string
+	size int64
+	dir  bool
+	cid  cid.Cid
+}
+
+func newMFSFileInfo(name string, nd mfs.FSNode) (*mfsFileInfo, error) {
+	dagNode, err := nd.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	fi := &mfsFileInfo{name: name, cid: dagNode.Cid()}
+	switch nd := nd.(type) {
+	case *mfs.Directory:
+		fi.dir = true
+	case *mfs.File:
+		if fi.size, err = nd.Size(); err != nil {
+			return nil, err
+		}
+	}
+	return fi, nil
+}
+
+func (fi *mfsFileInfo) Name() string     { return fi.name }
+func (fi *mfsFileInfo) Size() int64      { return fi.size }
+func (fi *mfsFileInfo) IsDir() bool      { return fi.dir }
+func (fi *mfsFileInfo) Sys() interface{} { return nil }
+
+// ModTime is unknown, MFS doesn't record modification times
+func (fi *mfsFileInfo) ModTime() time.Time { return time.Time{} }
+
+func (fi *mfsFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi *mfsFileInfo) ETag(ctx context.Context) (string, error) {
+	return `"` + fi.cid.String() + `"`, nil
+}
+
+// mfsWebDAVFile is a webdav.File over an MFS file descriptor
+type mfsWebDAVFile struct {
+	name  string
+	file  *mfs.File
+	fd    mfs.FileDescriptor
+	write bool
+}
+
+func (f *mfsWebDAVFile) Read(p []byte) (int, error)  { return f.fd.Read(p) }
+func (f *mfsWebDAVFile) Write(p []byte) (int, error) { return f.fd.Write(p) }
+func (f *mfsWebDAVFile) Seek(offset int64, whence int) (int64, error) {
+	return f.fd.Seek(offset, whence)
+}
+func (f *mfsWebDAVFile) Close() error { return f.fd.Close() }
+
+func (f *mfsWebDAVFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+// Stat flushes pending writes, so that the ETag is the CID of the content
+func (f *mfsWebDAVFile) Stat() (os.FileInfo, error) {
+	if f.write {
+		if err := f.fd.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	size, err := f.fd.Size()
+	if err != nil {
+		return nil, err
+	}
+	nd, err := f.file.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	return &mfsFileInfo{name: f.name, size: size, cid: nd.Cid()}, nil
+}
+
+// mfsWebDAVDir is a webdav.File over an MFS directory
+type mfsWebDAVDir struct {
+	name    string
+	dir     *mfs.Directory
+	entries []os.FileInfo // nil until read
+}
+
+func (d *mfsWebDAVDir) Read(p []byte) (int, error)                   { return 0, errWebDAVIsDir }
+func (d *mfsWebDAVDir) Write(p []byte) (int, error)                  { return 0, errWebDAVIsDir }
+func (d *mfsWebDAVDir) Seek(offset int64, whence int) (int64, error) { return 0, errWebDAVIsDir }
+func (d *mfsWebDAVDir) Close() error                                 { return nil }
+
+func (d *mfsWebDAVDir) Stat() (os.FileInfo, error) {
+	return newMFSFileInfo(d.name, d.dir)
+}
+
+// Readdir follows the semantics of os.File.Readdir
+func (d *mfsWebDAVDir) Readdir(count int) ([]os.FileInfo, error) {
+	if d.entries == nil {
+		names, err := d.dir.ListNames(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		d.entries = make([]os.FileInfo, 0, len(names))
+		for _, name := range names {
+			child, err := d.dir.Child(name)
+			if err != nil {
+				return nil, err
+			}
+			fi, err := newMFSFileInfo(name, child)
+			if err != nil {
+				return nil, err
+			}
+			d.entries = append(d.entries, fi)
+		}
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = d.entries[len(d.entries):]
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
@@ -0,0 +1,262 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	core "github.com/ipfs/go-ipfs/core"
+	mfs "github.com/ipfs/go-mfs"
+)
+
+// publicListener is a listener reporting a public address, to test the
+// options which depend on the address they listen on.
+type publicListener struct {
+	net.Listener
+}
+
+func (publicListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8080}
+}
+
+func newWebDAVTestServer(t *testing.T, webdavConfig string) (*httptest.Server, *core.IpfsNode) {
+	return newWebDAVTestServerOn(t, webdavConfig, false)
+}
+
+// newWebDAVTestServerOn starts the WebDAV test server, as if it listened on a
+// public address if public is true.
+func newWebDAVTestServerOn(t *testing.T, webdavConfig string, public bool) (*httptest.Server, *core.IpfsNode) {
+	t.Helper()
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(webdavConfig), &cfg.WebDAV); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	var lis net.Listener = ts.Listener
+	if public {
+		lis = publicListener{lis}
+	}
+	dh.Handler, err = makeHandler(n, lis, WebDAVOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, n
+}
+
+func webdavRequest(t *testing.T, ts *httptest.Server, method, urlPath string, body io.Reader, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+urlPath, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(data)
+}
+
+func mfsCid(t *testing.T, n *core.IpfsNode, p string) string {
+	t.Helper()
+	fsn, err := mfs.Lookup(n.FilesRoot, p)
+	if err != nil {
+		t.Fatalf("%s: %s", p, err)
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nd.Cid().String()
+}
+
+func TestWebDAV(t *testing.T) {
+	ts, n := newWebDAVTestServer(t, `{}`)
+
+	for _, step := range []struct {
+		method, path, body string
+		header             map[string]string
+		status             int
+	}{
+		{"MKCOL", "/dir", "", nil, http.StatusCreated},
+		{"MKCOL", "/missing/dir", "", nil, http.StatusConflict},
+		{"PUT", "/dir/a.txt", "hello", nil, http.StatusCreated},
+		{"PUT", "/dir/a.txt", "hello world", nil, http.StatusCreated},
+		{"COPY", "/dir/a.txt", "", map[string]string{"Destination": ts.URL + "/dir/b.txt"}, http.StatusCreated},
+		{"COPY", "/dir/a.txt", "", map[string]string{"Destination": ts.URL + "/dir/b.txt", "Overwrite": "F"}, http.StatusPreconditionFailed},
+		{"COPY", "/dir", "", map[string]string{"Destination": ts.URL + "/copy"}, http.StatusCreated},
+		{"MOVE", "/dir/b.txt", "", map[string]string{"Destination": ts.URL + "/c.txt"}, http.StatusCreated},
+		{"DELETE", "/copy", "", nil, http.StatusNoContent},
+	} {
+		res, body := webdavRequest(t, ts, step.method, step.path, strings.NewReader(step.body), step.header)
+		if res.StatusCode != step.status {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", step.method, step.path, step.status, res.StatusCode, body)
+		}
+	}
+
+	res, body := webdavRequest(t, ts, http.MethodGet, "/dir/a.txt", nil, nil)
+	if res.StatusCode != http.StatusOK || body != "hello world" {
+		t.Fatalf("unexpected response %d: %q", res.StatusCode, body)
+	}
+	fileCid := mfsCid(t, n, "/dir/a.txt")
+	if etag := res.Header.Get("Etag"); etag != `"`+fileCid+`"` {
+		t.Errorf("expected the CID as Etag, got %s", etag)
+	}
+	// copies and moves link the same DAG
+	if c := mfsCid(t, n, "/c.txt"); c != fileCid {
+		t.Errorf("expected the copy to have the CID %s, got %s", fileCid, c)
+	}
+	for _, p := range []string{"/dir/b.txt", "/copy"} {
+		if _, err := mfs.Lookup(n.FilesRoot, p); err != os.ErrNotExist {
+			t.Errorf("expected %s to be removed, got %v", p, err)
+		}
+	}
+
+	res, body = webdavRequest(t, ts, "PROPFIND", "/dir", nil, map[string]string{"Depth": "1"})
+	if res.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND: unexpected status %d: %s", res.StatusCode, body)
+	}
+	if !strings.Contains(body, "/dir/a.txt") || !strings.Contains(body, fileCid) {
+		t.Errorf("PROPFIND: expected a.txt and its CID in %s", body)
+	}
+}
+
+func TestWebDAVAuthReadOnly(t *testing.T) {
+	ts, n := newWebDAVTestServer(t, `{"ReadOnly": true, "Users": {"alice": "secret"}}`)
+	if err := mfs.Mkdir(n.FilesRoot, "/dir", mfs.MkdirOpts{Flush: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method, user, password string
+		status                 int
+	}{
+		{"PROPFIND", "", "", http.StatusUnauthorized},
+		{"PROPFIND", "alice", "wrong", http.StatusUnauthorized},
+		{"PROPFIND", "bob", "secret", http.StatusUnauthorized},
+		{"PROPFIND", "alice", "secret", http.StatusMultiStatus},
+		{"MKCOL", "alice", "secret", http.StatusForbidden},
+		{"DELETE", "alice", "secret", http.StatusForbidden},
+	} {
+		req, err := http.NewRequest(test.method, ts.URL+"/dir", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.user != "" {
+			req.SetBasicAuth(test.user, test.password)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Errorf("%s as %q: expected status %d, got %d", test.method, test.user, test.status, res.StatusCode)
+		}
+	}
+	if _, err := mfs.Lookup(n.FilesRoot, "/dir"); err != nil {
+		t.Errorf("expected /dir to be kept: %s", err)
+	}
+}
+
+func TestWebDAVPublicWithoutUsers(t *testing.T) {
+	for _, test := range []struct {
+		config string
+		public bool
+		status int
+	}{
+		{`{}`, false, http.StatusCreated},
+		{`{}`, true, http.StatusForbidden},
+		{`{"Users": {"alice": "secret"}}`, true, http.StatusCreated},
+	} {
+		ts, _ := newWebDAVTestServerOn(t, test.config, test.public)
+		req, err := http.NewRequest("MKCOL", ts.URL+"/dir", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("alice", "secret")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Errorf("%s, public %t: expected status %d, got %d", test.config, test.public, test.status, res.StatusCode)
+		}
+	}
+}
+
+func TestWebDAVRoot(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if err := mfs.Mkdir(n.FilesRoot, "/sub", mfs.MkdirOpts{Flush: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	for _, test := range []struct {
+		root string
+		ok   bool
+	}{
+		{"/missing", false},
+		{"/sub", true},
+	} {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(`{"Root": "`+test.root+`"}`), &cfg.WebDAV); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Repo.SetConfig(cfg); err != nil {
+			t.Fatal(err)
+		}
+		dh.Handler, err = makeHandler(n, ts.Listener, WebDAVOption())
+		if (err == nil) != test.ok {
+			t.Fatalf("%s: unexpected error %v", test.root, err)
+		}
+	}
+
+	if res, body := webdavRequest(t, ts, "PUT", "/a.txt", strings.NewReader("a"), nil); res.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status %d: %s", res.StatusCode, body)
+	}
+	if _, err := mfs.Lookup(n.FilesRoot, "/sub/a.txt"); err != nil {
+		t.Errorf("expected the file to be written in WebDAV.Root: %s", err)
+	}
+	if res, _ := webdavRequest(t, ts, "DELETE", "/", nil, nil); res.StatusCode < 400 {
+		t.Errorf("expected WebDAV.Root not to be removable, got status %d", res.StatusCode)
+	}
+}
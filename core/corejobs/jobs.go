@@ -0,0 +1,493 @@
+// Package corejobs runs long commands detached from the requests which
+// submitted them, and persists their state and output in the datastore.
+package corejobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("core/jobs")
+
+// ErrNotFound is returned for unknown job IDs.
+var ErrNotFound = errors.New("job not found")
+
+// Status is the state of a job.
+type Status string
+
+const (
+	StatusRunning  Status = "running"
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+	// StatusInterrupted is the status of the jobs that were running when the
+	// daemon stopped. They are not restarted.
+	StatusInterrupted Status = "interrupted"
+)
+
+const (
+	// MaxLogEntries is the number of outputs kept for each job.
+	MaxLogEntries = 1000
+
+	// Retention is how long finished jobs are kept.
+	Retention = 7 * 24 * time.Hour
+
+	// PruneInterval is how often the jobs finished for longer than the
+	// Retention are removed.
+	PruneInterval = time.Hour
+
+	// saveInterval throttles the writes of the outputs of running jobs.
+	saveInterval = time.Second
+)
+
+var (
+	statePrefix = datastore.NewKey("/jobs/state")
+	logsPrefix  = datastore.NewKey("/jobs/logs")
+
+	jobID = regexp.MustCompile("^[0-9a-f]{16}$")
+)
+
+// Job is the state of a job.
+type Job struct {
+	ID        string
+	Command   string
+	Arguments []string            `json:",omitempty"`
+	Options   map[string][]string `json:",omitempty"`
+
+	Status   Status
+	Error    string `json:",omitempty"`
+	Created  time.Time
+	Finished *time.Time `json:",omitempty"`
+
+	// Outputs is the number of values output by the command, and Progress
+	// the last one.
+	Outputs  uint64
+	Progress json.RawMessage `json:",omitempty"`
+}
+
+// LogEntry is a value output by the command of a job, encoded to JSON.
+type LogEntry struct {
+	Seq   uint64
+	Time  time.Time
+	Value json.RawMessage
+}
+
+// RunFunc runs the command of a job, passing the values it outputs to emit.
+type RunFunc func(ctx context.Context, emit func(interface{}) error) error
+
+// Manager runs jobs, and keeps track of them in the datastore.
+type Manager struct {
+	ds datastore.Datastore
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]*runningJob
+	closed  bool
+}
+
+type runningJob struct {
+	mu       sync.Mutex
+	job      Job
+	logs     []LogEntry
+	cancel   context.CancelFunc
+	canceled bool
+	lastSave time.Time
+
+	// updated is closed and replaced when the job outputs a value or
+	// finishes
+	updated chan struct{}
+}
+
+// NewManager returns a manager storing the jobs in the datastore. The jobs
+// which were running when the previous manager stopped are marked as
+// interrupted, and the jobs finished for longer than the Retention are
+// removed every PruneInterval until the manager is closed.
+func NewManager(ds datastore.Datastore) (*Manager, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		ds:      ds,
+		ctx:     ctx,
+		cancel:  cancel,
+		running: make(map[string]*runningJob),
+	}
+
+	jobs, err := m.load()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	now := time.Now()
+	for _, job := range jobs {
+		if job.Status != StatusRunning {
+			continue
+		}
+		job.Status = StatusInterrupted
+		job.Error = "the daemon stopped while the job was running"
+		job.Finished = &now
+		if err := m.saveState(job); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	if err := m.removeFinished(now); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	m.wg.Add(1)
+	go m.removeFinishedPeriodically()
+	return m, nil
+}
+
+// removeFinished removes the jobs finished for longer than the Retention.
+func (m *Manager) removeFinished(now time.Time) error {
+	jobs, err := m.load()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.Finished != nil && now.Sub(*job.Finished) > Retention {
+			if err := m.remove(job.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Manager) removeFinishedPeriodically() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(PruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := m.removeFinished(now); err != nil {
+				log.Errorf("removing the finished jobs: %s", err)
+			}
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// Submit starts a job running the given command. The command and its
+// arguments and options are only recorded, run does the work.
+func (m *Manager) Submit(command string, args []string, opts map[string][]string, run RunFunc) (Job, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Job{}, err
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	rj := &runningJob{
+		job: Job{
+			ID:        hex.EncodeToString(id),
+			Command:   command,
+			Arguments: args,
+			Options:   opts,
+			Status:    StatusRunning,
+			Created:   time.Now(),
+		},
+		cancel:  cancel,
+		updated: make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		cancel()
+		return Job{}, errors.New("the job manager is closed")
+	}
+	if err := m.saveState(rj.job); err != nil {
+		cancel()
+		return Job{}, err
+	}
+	m.running[rj.job.ID] = rj
+	job := rj.job
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+		err := run(ctx, func(v interface{}) error {
+			return m.output(rj, v)
+		})
+		m.finish(rj, err)
+	}()
+
+	return job, nil
+}
+
+// output records a value output by a running job.
+func (m *Manager) output(rj *runningJob, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		value, _ = json.Marshal(fmt.Sprint(v))
+	}
+
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+	now := time.Now()
+	rj.logs = append(rj.logs, LogEntry{Seq: rj.job.Outputs, Time: now, Value: value})
+	if len(rj.logs) > 2*MaxLogEntries {
+		rj.logs = append([]LogEntry(nil), rj.tail()...)
+	}
+	rj.job.Outputs++
+	rj.job.Progress = value
+	rj.notify()
+
+	if now.Sub(rj.lastSave) < saveInterval {
+		return nil
+	}
+	rj.lastSave = now
+	if err := m.save(rj); err != nil {
+		log.Errorf("saving job %s: %s", rj.job.ID, err)
+	}
+	return nil
+}
+
+// finish records the final status of a job, once its command returned.
+func (m *Manager) finish(rj *runningJob, err error) {
+	m.mu.Lock()
+	closed := m.closed
+	m.mu.Unlock()
+
+	rj.mu.Lock()
+	now := time.Now()
+	rj.job.Finished = &now
+	switch {
+	case rj.canceled:
+		rj.job.Status = StatusCanceled
+	case closed:
+		rj.job.Status = StatusInterrupted
+		rj.job.Error = "the daemon stopped while the job was running"
+	case err != nil:
+		rj.job.Status = StatusFailed
+		rj.job.Error = err.Error()
+	default:
+		rj.job.Status = StatusDone
+	}
+	if err := m.save(rj); err != nil {
+		log.Errorf("saving job %s: %s", rj.job.ID, err)
+	}
+	rj.notify()
+	rj.mu.Unlock()
+
+	// the state is saved first, so that the outputs can be read from the
+	// datastore once the job isn't running anymore
+	m.mu.Lock()
+	delete(m.running, rj.job.ID)
+	m.mu.Unlock()
+}
+
+// tail returns the last MaxLogEntries outputs of the job.
+func (rj *runningJob) tail() []LogEntry {
+	if len(rj.logs) > MaxLogEntries {
+		return rj.logs[len(rj.logs)-MaxLogEntries:]
+	}
+	return rj.logs
+}
+
+func (rj *runningJob) notify() {
+	close(rj.updated)
+	rj.updated = make(chan struct{})
+}
+
+// Get returns the state of a job.
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	rj, ok := m.running[id]
+	m.mu.Unlock()
+	if ok {
+		rj.mu.Lock()
+		defer rj.mu.Unlock()
+		return rj.job, nil
+	}
+
+	if !jobID.MatchString(id) {
+		return Job{}, ErrNotFound
+	}
+	data, err := m.ds.Get(context.Background(), statePrefix.ChildString(id))
+	if err == datastore.ErrNotFound {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	var job Job
+	err = json.Unmarshal(data, &job)
+	return job, err
+}
+
+// List returns all the jobs, oldest first.
+func (m *Manager) List() ([]Job, error) {
+	jobs, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	for i, job := range jobs {
+		if rj, ok := m.running[job.ID]; ok {
+			rj.mu.Lock()
+			jobs[i] = rj.job
+			rj.mu.Unlock()
+		}
+	}
+	m.mu.Unlock()
+	return jobs, nil
+}
+
+// Cancel cancels a running job.
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	rj, ok := m.running[id]
+	m.mu.Unlock()
+	if !ok {
+		job, err := m.Get(id)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("job %s is not running: %s", id, job.Status)
+	}
+
+	rj.mu.Lock()
+	rj.canceled = true
+	rj.mu.Unlock()
+	rj.cancel()
+	return nil
+}
+
+// Logs passes the outputs of a job to emit, starting with the first one
+// still kept. With follow, it waits for the outputs of a running job until
+// it finishes.
+func (m *Manager) Logs(ctx context.Context, id string, follow bool, emit func(LogEntry) error) error {
+	var next uint64
+	for {
+		m.mu.Lock()
+		rj, ok := m.running[id]
+		m.mu.Unlock()
+
+		var (
+			logs    []LogEntry
+			updated chan struct{}
+		)
+		if ok {
+			rj.mu.Lock()
+			logs = append(logs, rj.tail()...)
+			updated = rj.updated
+			rj.mu.Unlock()
+		} else {
+			if _, err := m.Get(id); err != nil {
+				return err
+			}
+			data, err := m.ds.Get(ctx, logsPrefix.ChildString(id))
+			if err != nil && err != datastore.ErrNotFound {
+				return err
+			}
+			if err == nil {
+				if err := json.Unmarshal(data, &logs); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, entry := range logs {
+			if entry.Seq < next {
+				continue
+			}
+			if err := emit(entry); err != nil {
+				return err
+			}
+			next = entry.Seq + 1
+		}
+
+		if !ok || !follow {
+			return nil
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close cancels the running jobs, and waits for them to return. They are
+// marked as interrupted.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.cancel()
+	m.wg.Wait()
+	return nil
+}
+
+// save stores the state and the outputs of a running job, which has to be
+// locked.
+func (m *Manager) save(rj *runningJob) error {
+	logs, err := json.Marshal(rj.tail())
+	if err != nil {
+		return err
+	}
+	if err := m.ds.Put(context.Background(), logsPrefix.ChildString(rj.job.ID), logs); err != nil {
+		return err
+	}
+	return m.saveState(rj.job)
+}
+
+func (m *Manager) saveState(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return m.ds.Put(context.Background(), statePrefix.ChildString(job.ID), data)
+}
+
+func (m *Manager) remove(id string) error {
+	if err := m.ds.Delete(context.Background(), logsPrefix.ChildString(id)); err != nil {
+		return err
+	}
+	return m.ds.Delete(context.Background(), statePrefix.ChildString(id))
+}
+
+// load returns the jobs stored in the datastore, oldest first.
+func (m *Manager) load() ([]Job, error) {
+	results, err := m.ds.Query(context.Background(), query.Query{Prefix: statePrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var jobs []Job
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var job Job
+		if err := json.Unmarshal(r.Value, &job); err != nil {
+			log.Errorf("invalid job %s: %s", r.Key, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	return jobs, nil
+}
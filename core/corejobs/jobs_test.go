@@ -0,0 +1,193 @@
+package corejobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func waitFor(t *testing.T, m *Manager, id string, status Status) Job {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s never got the status %s", id, status)
+	return Job{}
+}
+
+func TestJobs(t *testing.T) {
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	m, err := NewManager(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	release := make(chan struct{})
+	job, err := m.Submit("test/count", []string{"arg"}, nil, func(ctx context.Context, emit func(interface{}) error) error {
+		for i := 0; i < 3; i++ {
+			if err := emit(map[string]int{"Count": i}); err != nil {
+				return err
+			}
+		}
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusRunning {
+		t.Fatalf("expected the job to be running, got %s", job.Status)
+	}
+
+	// follow the outputs until the job finishes
+	followed := make(chan []uint64)
+	go func() {
+		var seqs []uint64
+		err := m.Logs(context.Background(), job.ID, true, func(entry LogEntry) error {
+			seqs = append(seqs, entry.Seq)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		followed <- seqs
+	}()
+
+	close(release)
+	job = waitFor(t, m, job.ID, StatusDone)
+	if job.Outputs != 3 || string(job.Progress) != `{"Count":2}` || job.Finished == nil {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if seqs := <-followed; len(seqs) != 3 || seqs[2] != 2 {
+		t.Errorf("unexpected outputs %v", seqs)
+	}
+
+	failed, err := m.Submit("test/fail", nil, nil, func(ctx context.Context, emit func(interface{}) error) error {
+		return errors.New("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := waitFor(t, m, failed.ID, StatusFailed); job.Error != "boom" {
+		t.Errorf("unexpected error %q", job.Error)
+	}
+
+	canceled, err := m.Submit("test/wait", nil, nil, func(ctx context.Context, emit func(interface{}) error) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Cancel(canceled.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, m, canceled.ID, StatusCanceled)
+	if err := m.Cancel(canceled.ID); err == nil {
+		t.Error("expected finished jobs not to be cancelable")
+	}
+	if _, err := m.Get("../logs/" + job.ID); err != ErrNotFound {
+		t.Errorf("expected invalid IDs not to be found, got %v", err)
+	}
+
+	jobs, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 3 || jobs[0].ID != job.ID || jobs[2].ID != canceled.ID {
+		t.Errorf("unexpected jobs %+v", jobs)
+	}
+}
+
+func TestJobsRestart(t *testing.T) {
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	m, err := NewManager(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := m.Submit("test/wait", nil, nil, func(ctx context.Context, emit func(interface{}) error) error {
+		if err := emit("started"); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, m, job.ID, StatusRunning)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if job := waitFor(t, m, job.ID, StatusInterrupted); job.Outputs != 1 {
+		t.Errorf("expected the outputs to be kept, got %+v", job)
+	}
+
+	// a job left running by a crash, and an expired one
+	crashed := Job{ID: "0000000000000001", Command: "test/crash", Status: StatusRunning, Created: time.Now()}
+	finished := time.Now().Add(-Retention - time.Hour)
+	expired := Job{ID: "0000000000000002", Command: "test/old", Status: StatusDone, Created: finished, Finished: &finished}
+	for _, job := range []Job{crashed, expired} {
+		data, err := json.Marshal(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.Put(context.Background(), statePrefix.ChildString(job.ID), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err = NewManager(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	waitFor(t, m, crashed.ID, StatusInterrupted)
+	if _, err := m.Get(expired.ID); err != ErrNotFound {
+		t.Errorf("expected the expired job to be removed, got %v", err)
+	}
+
+	// the jobs expiring while the manager runs are removed too
+	expired.ID = "0000000000000003"
+	data, err := json.Marshal(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Put(context.Background(), statePrefix.ChildString(expired.ID), data); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.removeFinished(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get(expired.ID); err != ErrNotFound {
+		t.Errorf("expected the job expired while running to be removed, got %v", err)
+	}
+	if _, err := m.Get(job.ID); err != nil {
+		t.Errorf("expected the recent job to be kept, got %v", err)
+	}
+
+	var values []string
+	err = m.Logs(context.Background(), job.ID, true, func(entry LogEntry) error {
+		values = append(values, string(entry.Value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0] != `"started"` {
+		t.Errorf("unexpected outputs %v", values)
+	}
+}
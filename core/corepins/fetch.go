@@ -0,0 +1,427 @@
+package corepins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var fetchPrefix = datastore.NewKey("/local/pinfetches")
+
+// FetchConcurrency is how many blocks of the DAG of a recursive pin are
+// fetched at the same time.
+const FetchConcurrency = 32
+
+// FetchSaveInterval is how often the progress of the fetches is saved.
+var FetchSaveInterval = 10 * time.Second
+
+// FetchState is the progress of the fetch of the DAG of a recursive pin, saved
+// in the datastore until the DAG is pinned.
+type FetchState struct {
+	Cid cid.Cid
+	// Info is the info given to the pin, if any.
+	Info    *Info `json:",omitempty"`
+	Started time.Time
+	// Blocks and Bytes count what was fetched, including before restarts.
+	Blocks uint64
+	Bytes  uint64
+	// Frontier are the roots of the subtrees not fetched yet.
+	Frontier []cid.Cid
+}
+
+// FetchStatus is the status of the fetch of the DAG of a recursive pin.
+type FetchStatus struct {
+	FetchState
+	// Running is false when the fetch failed, or the node stopped during
+	// it.
+	Running bool
+	// Providers are the peers which sent blocks since the fetch started.
+	Providers []peer.ID
+}
+
+// ErrFetcherClosed is returned by the fetches started once the node stops.
+var ErrFetcherClosed = errors.New("the node is shutting down")
+
+// Fetcher fetches the DAGs of the recursive pins, saving their frontier in
+// the datastore so that the fetches interrupted by the node stopping resume
+// where they stopped.
+type Fetcher struct {
+	ds datastore.Datastore
+	// recv returns the bytes received from each peer, if known.
+	recv func() map[peer.ID]uint64
+
+	lk      sync.Mutex
+	running map[cid.Cid]*fetch
+	closed  bool
+}
+
+// NewFetcher returns the fetcher saving its progress in the datastore. recv
+// returns the bytes received from each peer, to tell the providers of the
+// fetches, and may be nil.
+func NewFetcher(ds datastore.Datastore, recv func() map[peer.ID]uint64) *Fetcher {
+	return &Fetcher{
+		ds:      ds,
+		recv:    recv,
+		running: make(map[cid.Cid]*fetch),
+	}
+}
+
+type fetch struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+	// recv are the bytes received from each peer when the fetch started.
+	recv map[peer.ID]uint64
+
+	lk    sync.Mutex
+	state FetchState
+	// pending is the frontier: the nodes not fetched yet, including the
+	// ones being fetched.
+	pending map[cid.Cid]struct{}
+}
+
+func fetchKey(c cid.Cid) datastore.Key {
+	return fetchPrefix.ChildString(c.String())
+}
+
+// Fetch fetches the DAG of the CID with the node getter, resuming the fetch
+// saved, if any. The progress counts the blocks fetched, if not nil. The
+// state of the fetch is kept, even when it succeeds, until Done is called
+// once the DAG is pinned, unless the context is canceled before the node
+// stops: the caller gave up on the pin then.
+func (f *Fetcher) Fetch(ctx context.Context, c cid.Cid, ng ipld.NodeGetter, info *Info, progress *merkledag.ProgressTracker) error {
+	for {
+		f.lk.Lock()
+		if f.closed {
+			f.lk.Unlock()
+			return ErrFetcherClosed
+		}
+		other, ok := f.running[c]
+		if !ok {
+			break
+		}
+		f.lk.Unlock()
+
+		// wait for the fetch already running, and take over if it failed
+		select {
+		case <-other.done:
+			if other.err == nil {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	state, err := f.load(ctx, c)
+	if err != nil {
+		f.lk.Unlock()
+		return err
+	}
+	if state == nil {
+		state = &FetchState{Cid: c, Started: time.Now().UTC(), Frontier: []cid.Cid{c}}
+	}
+	if info != nil {
+		state.Info = info
+	}
+	fetchCtx, cancel := context.WithCancel(ctx)
+	fe := &fetch{
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		recv:    f.received(),
+		state:   *state,
+		pending: make(map[cid.Cid]struct{}, len(state.Frontier)),
+	}
+	for _, c := range state.Frontier {
+		fe.pending[c] = struct{}{}
+	}
+	f.running[c] = fe
+	f.lk.Unlock()
+
+	err = f.run(fetchCtx, fe, ng, progress)
+	cancel()
+
+	f.lk.Lock()
+	abandoned := err != nil && ctx.Err() != nil && !f.closed
+	f.lk.Unlock()
+	if abandoned {
+		// not resumed when the node starts again
+		if derr := f.Done(context.Background(), c); derr != nil {
+			log.Errorf("deleting the fetch of %s: %s", c, derr)
+		}
+	}
+
+	f.lk.Lock()
+	delete(f.running, c)
+	fe.err = err
+	close(fe.done)
+	f.lk.Unlock()
+	return err
+}
+
+func (f *Fetcher) run(ctx context.Context, fe *fetch, ng ipld.NodeGetter, progress *merkledag.ProgressTracker) error {
+	if err := f.save(ctx, fe); err != nil {
+		return err
+	}
+	err := f.walk(ctx, fe, ng, progress)
+	if err != nil {
+		// save the frontier reached to resume from it, even if the
+		// context is done
+		if serr := f.save(context.Background(), fe); serr != nil {
+			log.Errorf("saving the fetch of %s: %s", fe.state.Cid, serr)
+		}
+		return err
+	}
+	return f.save(ctx, fe)
+}
+
+// walk fetches the nodes of the frontier and their descendants, depth first
+// to keep the frontier small, moving the frontier as they are fetched.
+func (f *Fetcher) walk(ctx context.Context, fe *fetch, ng ipld.NodeGetter, progress *merkledag.ProgressTracker) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	type result struct {
+		c   cid.Cid
+		nd  ipld.Node
+		err error
+	}
+	todo := make(chan cid.Cid)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < FetchConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range todo {
+				nd, err := ng.Get(ctx, c)
+				select {
+				case results <- result{c, nd, err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	defer func() {
+		close(todo)
+		cancel()
+		wg.Wait()
+	}()
+
+	fe.lk.Lock()
+	stack := append([]cid.Cid(nil), fe.state.Frontier...)
+	fe.lk.Unlock()
+	visited := cid.NewSet()
+	for _, c := range stack {
+		visited.Add(c)
+	}
+
+	ticker := time.NewTicker(FetchSaveInterval)
+	defer ticker.Stop()
+
+	var fetching int
+	for len(stack) > 0 || fetching > 0 {
+		var next cid.Cid
+		var send chan<- cid.Cid
+		if len(stack) > 0 && fetching < FetchConcurrency {
+			next, send = stack[len(stack)-1], todo
+		}
+
+		select {
+		case send <- next:
+			stack = stack[:len(stack)-1]
+			fetching++
+		case r := <-results:
+			fetching--
+			if r.err != nil {
+				return r.err
+			}
+			links := r.nd.Links()
+			fe.lk.Lock()
+			delete(fe.pending, r.c)
+			fe.state.Blocks++
+			fe.state.Bytes += uint64(len(r.nd.RawData()))
+			// in reverse, to fetch the first links first
+			for i := len(links) - 1; i >= 0; i-- {
+				if visited.Visit(links[i].Cid) {
+					fe.pending[links[i].Cid] = struct{}{}
+					stack = append(stack, links[i].Cid)
+				}
+			}
+			fe.lk.Unlock()
+			if progress != nil {
+				progress.Increment()
+			}
+		case <-ticker.C:
+			if err := f.save(ctx, fe); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// snapshot returns the current state of the fetch.
+func (fe *fetch) snapshot() FetchState {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	state := fe.state
+	state.Frontier = make([]cid.Cid, 0, len(fe.pending))
+	for c := range fe.pending {
+		state.Frontier = append(state.Frontier, c)
+	}
+	sort.Slice(state.Frontier, func(i, j int) bool {
+		return state.Frontier[i].KeyString() < state.Frontier[j].KeyString()
+	})
+	return state
+}
+
+func (f *Fetcher) save(ctx context.Context, fe *fetch) error {
+	// the blocks fetched must be on disk before the frontier moves past them
+	if err := f.ds.Sync(ctx, blockstore.BlockPrefix); err != nil {
+		return err
+	}
+	state := fe.snapshot()
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+	key := fetchKey(state.Cid)
+	if err := f.ds.Put(ctx, key, data); err != nil {
+		return err
+	}
+	return f.ds.Sync(ctx, key)
+}
+
+func (f *Fetcher) load(ctx context.Context, c cid.Cid) (*FetchState, error) {
+	data, err := f.ds.Get(ctx, fetchKey(c))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := new(FetchState)
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Done deletes the state of the fetch of the CID, once its DAG is pinned.
+func (f *Fetcher) Done(ctx context.Context, c cid.Cid) error {
+	return f.ds.Delete(ctx, fetchKey(c))
+}
+
+// Remove stops the fetch of the CID, if running, and deletes its state. It
+// returns whether there was a fetch.
+func (f *Fetcher) Remove(ctx context.Context, c cid.Cid) (bool, error) {
+	f.lk.Lock()
+	fe, running := f.running[c]
+	f.lk.Unlock()
+	if running {
+		fe.cancel()
+		<-fe.done
+	}
+
+	found, err := f.ds.Has(ctx, fetchKey(c))
+	if err != nil || !found {
+		return running, err
+	}
+	return true, f.Done(ctx, c)
+}
+
+// Close stops the fetches running and keeps their state, to resume them when
+// the node starts again.
+func (f *Fetcher) Close() {
+	f.lk.Lock()
+	f.closed = true
+	running := make([]*fetch, 0, len(f.running))
+	for _, fe := range f.running {
+		running = append(running, fe)
+	}
+	f.lk.Unlock()
+
+	for _, fe := range running {
+		fe.cancel()
+		<-fe.done
+	}
+}
+
+// Pending returns the state saved of the fetches not done, running or
+// interrupted.
+func (f *Fetcher) Pending(ctx context.Context) ([]FetchState, error) {
+	results, err := f.ds.Query(ctx, query.Query{Prefix: fetchPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var states []FetchState
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var state FetchState
+		if err := json.Unmarshal(r.Value, &state); err != nil {
+			log.Errorf("invalid pin fetch %s: %s", r.Key, err)
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Status returns the status of the fetch of the CID, or nil if it isn't
+// being fetched.
+func (f *Fetcher) Status(ctx context.Context, c cid.Cid) (*FetchStatus, error) {
+	f.lk.Lock()
+	fe, running := f.running[c]
+	f.lk.Unlock()
+	if running {
+		return &FetchStatus{
+			FetchState: fe.snapshot(),
+			Running:    true,
+			Providers:  f.providers(fe),
+		}, nil
+	}
+
+	state, err := f.load(ctx, c)
+	if err != nil || state == nil {
+		return nil, err
+	}
+	return &FetchStatus{FetchState: *state}, nil
+}
+
+func (f *Fetcher) received() map[peer.ID]uint64 {
+	if f.recv == nil {
+		return nil
+	}
+	return f.recv()
+}
+
+// providers returns the peers which sent blocks since the fetch started:
+// the exchange doesn't tell which fetch the blocks were for.
+func (f *Fetcher) providers(fe *fetch) []peer.ID {
+	var providers []peer.ID
+	for p, recv := range f.received() {
+		if recv > fe.recv[p] {
+			providers = append(providers, p)
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i] < providers[j] })
+	return providers
+}
@@ -0,0 +1,177 @@
+package corepins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	mdutils "github.com/ipfs/go-merkledag/test"
+)
+
+// failingGetter fails to get the failing CID, and counts the gets.
+type failingGetter struct {
+	ipld.NodeGetter
+	failing cid.Cid
+
+	lk   sync.Mutex
+	gets map[cid.Cid]int
+}
+
+func (g *failingGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	g.lk.Lock()
+	g.gets[c]++
+	g.lk.Unlock()
+	if c.Equals(g.failing) {
+		return nil, errors.New("no providers")
+	}
+	return g.NodeGetter.Get(ctx, c)
+}
+
+func TestFetchResumes(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdutils.Mock()
+
+	// a root with 3 children with 3 leaves each
+	root := dag.NodeWithData([]byte("root"))
+	var children []*dag.ProtoNode
+	for i := 0; i < 3; i++ {
+		child := dag.NodeWithData([]byte(fmt.Sprintf("child %d", i)))
+		for j := 0; j < 3; j++ {
+			leaf := dag.NodeWithData([]byte(fmt.Sprintf("leaf %d/%d", i, j)))
+			if err := dserv.Add(ctx, leaf); err != nil {
+				t.Fatal(err)
+			}
+			if err := child.AddNodeLink("leaf", leaf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := dserv.Add(ctx, child); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink("child", child); err != nil {
+			t.Fatal(err)
+		}
+		children = append(children, child)
+	}
+	if err := dserv.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFetcher(syncds.MutexWrap(datastore.NewMapDatastore()), nil)
+	info := &Info{Name: "dataset"}
+
+	// the last child can't be fetched
+	g := &failingGetter{NodeGetter: dserv, failing: children[2].Cid(), gets: make(map[cid.Cid]int)}
+	if err := f.Fetch(ctx, root.Cid(), g, info, nil); err == nil {
+		t.Fatal("expected the fetch to fail")
+	}
+	status, err := f.Status(ctx, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Running || status.Info == nil || status.Info.Name != "dataset" {
+		t.Fatalf("expected the fetch interrupted to be saved, got %+v", status)
+	}
+	var missing bool
+	for _, c := range status.Frontier {
+		if c.Equals(root.Cid()) {
+			t.Errorf("expected the root to be fetched, got the frontier %v", status.Frontier)
+		}
+		missing = missing || c.Equals(children[2].Cid())
+	}
+	if !missing {
+		t.Errorf("expected the failing child in the frontier, got %v", status.Frontier)
+	}
+
+	// the fetch resumes from the frontier
+	g = &failingGetter{NodeGetter: dserv, gets: make(map[cid.Cid]int)}
+	if err := f.Fetch(ctx, root.Cid(), g, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if g.gets[root.Cid()] != 0 {
+		t.Error("expected the root not to be fetched again")
+	}
+	status, err = f.Status(ctx, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Blocks != 13 || len(status.Frontier) != 0 || status.Info == nil {
+		t.Errorf("expected the 13 blocks fetched once and the info kept, got %+v", status.FetchState)
+	}
+
+	// the state is kept until the pin is done
+	if err := f.Done(ctx, root.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := f.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending fetch, got %v", pending)
+	}
+}
+
+// blockingGetter calls blocked when getting the blocking CID, which blocks
+// until the context is done.
+type blockingGetter struct {
+	ipld.NodeGetter
+	blocking cid.Cid
+	blocked  func()
+}
+
+func (g *blockingGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if c.Equals(g.blocking) {
+		g.blocked()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return g.NodeGetter.Get(ctx, c)
+}
+
+func TestFetchStopped(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdutils.Mock()
+	root := dag.NodeWithData([]byte("root"))
+	child := dag.NodeWithData([]byte("child"))
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddMany(ctx, []ipld.Node{root, child}); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFetcher(syncds.MutexWrap(datastore.NewMapDatastore()), nil)
+
+	// the caller gives up on the pin: it isn't resumed
+	cctx, cancel := context.WithCancel(ctx)
+	g := &blockingGetter{NodeGetter: dserv, blocking: child.Cid(), blocked: cancel}
+	if err := f.Fetch(cctx, root.Cid(), g, nil, nil); err == nil {
+		t.Fatal("expected the fetch to be canceled")
+	}
+	if status, err := f.Status(ctx, root.Cid()); err != nil || status != nil {
+		t.Fatalf("expected the fetch abandoned to be deleted, got %+v (%v)", status, err)
+	}
+
+	// the node stops: the fetch is kept to resume it
+	g = &blockingGetter{NodeGetter: dserv, blocking: child.Cid(), blocked: func() { go f.Close() }}
+	if err := f.Fetch(ctx, root.Cid(), g, nil, nil); err == nil {
+		t.Fatal("expected the fetch to be stopped")
+	}
+	status, err := f.Status(ctx, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Blocks != 1 {
+		t.Fatalf("expected the fetch stopped to be kept, got %+v", status)
+	}
+	if err := f.Fetch(ctx, root.Cid(), dserv, nil, nil); err != ErrFetcherClosed {
+		t.Errorf("expected %v once closed, got %v", ErrFetcherClosed, err)
+	}
+}
@@ -0,0 +1,245 @@
+package corepins
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/multiformats/go-multicodec"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// ManifestVersion is the version of the manifests written.
+const ManifestVersion = 1
+
+// Manifest lists the recursive and direct pins of a node, with their infos, to
+// restore them on another node.
+type Manifest struct {
+	Pins []ManifestPin
+}
+
+// ManifestPin is a pin of a manifest.
+type ManifestPin struct {
+	Cid cid.Cid
+	// Type is "recursive" or "direct".
+	Type string
+	Info
+}
+
+// Node returns the manifest as an IPLD node: a map with the version and the
+// list of the pins, linking to their CIDs.
+func (m *Manifest) Node() (datamodel.Node, error) {
+	return qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "Version", qp.Int(ManifestVersion))
+		qp.MapEntry(ma, "Pins", qp.List(int64(len(m.Pins)), func(la datamodel.ListAssembler) {
+			for _, p := range m.Pins {
+				qp.ListEntry(la, qp.Map(-1, func(ma datamodel.MapAssembler) {
+					qp.MapEntry(ma, "Cid", qp.Link(cidlink.Link{Cid: p.Cid}))
+					qp.MapEntry(ma, "Type", qp.String(p.Type))
+					if p.Name != "" {
+						qp.MapEntry(ma, "Name", qp.String(p.Name))
+					}
+					if len(p.Metadata) > 0 {
+						keys := make([]string, 0, len(p.Metadata))
+						for k := range p.Metadata {
+							keys = append(keys, k)
+						}
+						sort.Strings(keys)
+						qp.MapEntry(ma, "Metadata", qp.Map(int64(len(keys)), func(ma datamodel.MapAssembler) {
+							for _, k := range keys {
+								qp.MapEntry(ma, k, qp.String(p.Metadata[k]))
+							}
+						}))
+					}
+					if p.Expires != nil {
+						qp.MapEntry(ma, "Expires", qp.String(p.Expires.UTC().Format(time.RFC3339Nano)))
+					}
+				}))
+			}
+		}))
+	})
+}
+
+// Encode writes the manifest in the codec, dag-json or dag-cbor.
+func (m *Manifest) Encode(w io.Writer, codec multicodec.Code) error {
+	encode, err := manifestEncoder(codec)
+	if err != nil {
+		return err
+	}
+	nd, err := m.Node()
+	if err != nil {
+		return err
+	}
+	return ipld.EncodeStreaming(w, nd, encode)
+}
+
+// Block returns the manifest encoded in the codec as a block.
+func (m *Manifest) Block(codec multicodec.Code) (blocks.Block, error) {
+	var buf bytes.Buffer
+	if err := m.Encode(&buf, codec); err != nil {
+		return nil, err
+	}
+	prefix := cid.Prefix{Version: 1, Codec: uint64(codec), MhType: mh.SHA2_256, MhLength: -1}
+	c, err := prefix.Sum(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(buf.Bytes(), c)
+}
+
+func manifestEncoder(codec multicodec.Code) (ipld.Encoder, error) {
+	switch codec {
+	case multicodec.DagJson:
+		return dagjson.Encode, nil
+	case multicodec.DagCbor:
+		return dagcbor.Encode, nil
+	}
+	return nil, fmt.Errorf("unsupported manifest codec %s", codec)
+}
+
+// DecodeManifest reads a manifest in the codec, dag-json or dag-cbor.
+func DecodeManifest(r io.Reader, codec multicodec.Code) (*Manifest, error) {
+	var decode ipld.Decoder
+	switch codec {
+	case multicodec.DagJson:
+		decode = dagjson.Decode
+	case multicodec.DagCbor:
+		decode = dagcbor.Decode
+	default:
+		return nil, fmt.Errorf("unsupported manifest codec %s", codec)
+	}
+	nd, err := ipld.DecodeStreaming(r, decode)
+	if err != nil {
+		return nil, err
+	}
+	return ManifestFromNode(nd)
+}
+
+// ManifestFromNode reads a manifest from its IPLD node.
+func ManifestFromNode(nd datamodel.Node) (*Manifest, error) {
+	version, err := lookupInt(nd, "Version")
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if version != ManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", version)
+	}
+	pins, err := nd.LookupByString("Pins")
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	if pins.Kind() != datamodel.Kind_List {
+		return nil, fmt.Errorf("invalid manifest: the pins are not a list")
+	}
+
+	m := &Manifest{Pins: make([]ManifestPin, 0, pins.Length())}
+	it := pins.ListIterator()
+	for !it.Done() {
+		i, pn, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		p, err := manifestPinFromNode(pn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin %d of the manifest: %w", i, err)
+		}
+		m.Pins = append(m.Pins, p)
+	}
+	return m, nil
+}
+
+func manifestPinFromNode(nd datamodel.Node) (ManifestPin, error) {
+	var p ManifestPin
+	ln, err := nd.LookupByString("Cid")
+	if err != nil {
+		return p, err
+	}
+	l, err := ln.AsLink()
+	if err != nil {
+		return p, err
+	}
+	cl, ok := l.(cidlink.Link)
+	if !ok {
+		return p, fmt.Errorf("unsupported link %s", l)
+	}
+	p.Cid = cl.Cid
+
+	if p.Type, err = lookupString(nd, "Type"); err != nil {
+		return p, err
+	}
+	if p.Type != "recursive" && p.Type != "direct" {
+		return p, fmt.Errorf("invalid type %q, must be recursive or direct", p.Type)
+	}
+
+	if p.Name, err = lookupOptionalString(nd, "Name"); err != nil {
+		return p, err
+	}
+	if mn, err := nd.LookupByString("Metadata"); err == nil {
+		if mn.Kind() != datamodel.Kind_Map {
+			return p, fmt.Errorf("the metadata are not a map")
+		}
+		p.Metadata = make(map[string]string, mn.Length())
+		it := mn.MapIterator()
+		for !it.Done() {
+			kn, vn, err := it.Next()
+			if err != nil {
+				return p, err
+			}
+			k, err := kn.AsString()
+			if err != nil {
+				return p, err
+			}
+			if p.Metadata[k], err = vn.AsString(); err != nil {
+				return p, fmt.Errorf("metadata %s: %w", k, err)
+			}
+		}
+	}
+	expires, err := lookupOptionalString(nd, "Expires")
+	if err != nil {
+		return p, err
+	}
+	if expires != "" {
+		t, err := time.Parse(time.RFC3339Nano, expires)
+		if err != nil {
+			return p, err
+		}
+		p.Expires = &t
+	}
+	return p, nil
+}
+
+func lookupInt(nd datamodel.Node, key string) (int64, error) {
+	n, err := nd.LookupByString(key)
+	if err != nil {
+		return 0, err
+	}
+	return n.AsInt()
+}
+
+func lookupString(nd datamodel.Node, key string) (string, error) {
+	n, err := nd.LookupByString(key)
+	if err != nil {
+		return "", err
+	}
+	return n.AsString()
+}
+
+func lookupOptionalString(nd datamodel.Node, key string) (string, error) {
+	s, err := lookupString(nd, key)
+	if _, missing := err.(datamodel.ErrNotExists); missing {
+		return "", nil
+	}
+	return s, err
+}
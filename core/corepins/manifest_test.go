@@ -0,0 +1,72 @@
+package corepins
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/multiformats/go-multicodec"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	expires := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := &Manifest{Pins: []ManifestPin{
+		{Cid: dag.NodeWithData([]byte("a")).Cid(), Type: "recursive", Info: Info{
+			Name:     "site",
+			Metadata: map[string]string{"build": "1", "env": "prod"},
+			Expires:  &expires,
+		}},
+		{Cid: dag.NodeWithData([]byte("b")).Cid(), Type: "direct"},
+	}}
+
+	for _, codec := range []multicodec.Code{multicodec.DagJson, multicodec.DagCbor} {
+		var buf bytes.Buffer
+		if err := m.Encode(&buf, codec); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeManifest(&buf, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, m) {
+			t.Errorf("%s: expected %+v, got %+v", codec, m, decoded)
+		}
+
+		blk, err := m.Block(codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blk.Cid().Prefix().Codec != uint64(codec) {
+			t.Errorf("expected a %s block, got %s", codec, blk.Cid())
+		}
+	}
+
+	invalid := `{"Version":1,"Pins":[{"Cid":{"/":"` + m.Pins[0].Cid.String() + `"},"Type":"indirect"}]}`
+	if _, err := DecodeManifest(strings.NewReader(invalid), multicodec.DagJson); err == nil {
+		t.Error("expected indirect pins to be rejected")
+	}
+}
+
+func TestManifestMalformed(t *testing.T) {
+	c := dag.NodeWithData([]byte("a")).Cid().String()
+	for _, manifest := range []string{
+		`"x"`,
+		`{"Pins":[]}`,
+		`{"Version":2,"Pins":[]}`,
+		`{"Version":1}`,
+		`{"Version":1,"Pins":"x"}`,
+		`{"Version":1,"Pins":{"a":1}}`,
+		`{"Version":1,"Pins":[1]}`,
+		`{"Version":1,"Pins":[{"Cid":"x","Type":"direct"}]}`,
+		`{"Version":1,"Pins":[{"Cid":{"/":"` + c + `"},"Type":"direct","Metadata":"x"}]}`,
+		`{"Version":1,"Pins":[{"Cid":{"/":"` + c + `"},"Type":"direct","Metadata":{"a":1}}]}`,
+		`{"Version":1,"Pins":[{"Cid":{"/":"` + c + `"},"Type":"direct","Expires":"tomorrow"}]}`,
+	} {
+		if _, err := DecodeManifest(strings.NewReader(manifest), multicodec.DagJson); err == nil {
+			t.Errorf("expected %s to be rejected", manifest)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package corepins
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-merkledag"
+)
+
+// AddSettings are the settings of the PinAPI.Add of the local node which its
+// options can't express.
+type AddSettings struct {
+	// Info replaces the info of the pin, if not nil.
+	Info *Info
+	// Progress counts the blocks fetched for a recursive pin, if not nil.
+	Progress *merkledag.ProgressTracker
+}
+
+type addSettingsKey struct{}
+
+// WithAddSettings returns a context passing the settings to the PinAPI.Add of
+// the local node, like merkledag.ProgressTracker.DeriveContext passes a
+// tracker to merkledag.FetchGraph.
+func WithAddSettings(ctx context.Context, settings AddSettings) context.Context {
+	return context.WithValue(ctx, addSettingsKey{}, settings)
+}
+
+// AddSettingsFromContext returns the settings passed by WithAddSettings, which
+// are empty if there are none.
+func AddSettingsFromContext(ctx context.Context) AddSettings {
+	settings, _ := ctx.Value(addSettingsKey{}).(AddSettings)
+	return settings
+}
+
+// SetExpiresIn sets the pin to expire after the duration.
+func (i *Info) SetExpiresIn(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("invalid pin expiry %s, must be positive", d)
+	}
+	expires := time.Now().Add(d).UTC()
+	i.Expires = &expires
+	return nil
+}
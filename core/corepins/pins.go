@@ -0,0 +1,261 @@
+// Package corepins keeps the names, metadata and expiry of the local pins,
+// which the pinner doesn't store, and the progress of the recursive pins being
+// fetched, in the datastore. It also encodes the manifests of the pins moved
+// between nodes.
+package corepins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("core/pins")
+
+var infoPrefix = datastore.NewKey("/local/pins")
+
+// Info is what the node keeps about a recursive or direct pin, besides its
+// mode.
+type Info struct {
+	Name     string            `json:",omitempty"`
+	Metadata map[string]string `json:",omitempty"`
+	// Expires is when the pin is removed, if ever.
+	Expires *time.Time `json:",omitempty"`
+}
+
+// IsZero returns whether the info is empty, in which case it isn't stored.
+func (i Info) IsZero() bool {
+	return i.Name == "" && len(i.Metadata) == 0 && i.Expires == nil
+}
+
+// Expired returns whether the pin has expired at the given time.
+func (i Info) Expired(now time.Time) bool {
+	return i.Expires != nil && !i.Expires.After(now)
+}
+
+// ParseMetadata parses "key=value" metadata entries.
+func ParseMetadata(entries []string) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	metadata := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid metadata %q, expected key=value", entry)
+		}
+		metadata[kv[0]] = kv[1]
+	}
+	return metadata, nil
+}
+
+// Store stores the infos of the pins, by CID.
+type Store struct {
+	ds datastore.Datastore
+}
+
+// NewStore returns the store of the infos of the pins in the datastore.
+func NewStore(ds datastore.Datastore) *Store {
+	return &Store{ds: ds}
+}
+
+func infoKey(c cid.Cid) datastore.Key {
+	return infoPrefix.ChildString(c.String())
+}
+
+// Get returns the info of the pin of the CID, which is empty if it has none.
+func (s *Store) Get(ctx context.Context, c cid.Cid) (Info, error) {
+	var info Info
+	data, err := s.ds.Get(ctx, infoKey(c))
+	if err == datastore.ErrNotFound {
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// Put replaces the info of the pin of the CID.
+func (s *Store) Put(ctx context.Context, c cid.Cid, info Info) error {
+	if info.IsZero() {
+		return s.Delete(ctx, c)
+	}
+	data, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(ctx, infoKey(c), data)
+}
+
+// Delete removes the info of the pin of the CID.
+func (s *Store) Delete(ctx context.Context, c cid.Cid) error {
+	return s.ds.Delete(ctx, infoKey(c))
+}
+
+// All returns the infos of all the pins which have one.
+func (s *Store) All(ctx context.Context) (map[cid.Cid]Info, error) {
+	results, err := s.ds.Query(ctx, query.Query{Prefix: infoPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	infos := make(map[cid.Cid]Info)
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			log.Errorf("invalid pin info key %s: %s", r.Key, err)
+			continue
+		}
+		var info Info
+		if err := json.Unmarshal(r.Value, &info); err != nil {
+			log.Errorf("invalid pin info %s: %s", r.Key, err)
+			continue
+		}
+		infos[c] = info
+	}
+	return infos, nil
+}
+
+// NewPinner wraps the pinner to keep the infos of the store in sync with the
+// pins: the infos of the pins removed are deleted, and the infos of the pins
+// updated are moved to the new pins.
+func NewPinner(p pin.Pinner, s *Store) pin.Pinner {
+	return &pinner{Pinner: p, store: s}
+}
+
+type pinner struct {
+	pin.Pinner
+	store *Store
+}
+
+func (p *pinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if err := p.Pinner.Unpin(ctx, c, recursive); err != nil {
+		return err
+	}
+	p.deleteInfo(ctx, c)
+	return nil
+}
+
+func (p *pinner) RemovePinWithMode(c cid.Cid, mode pin.Mode) {
+	ctx := context.TODO()
+	p.Pinner.RemovePinWithMode(c, mode)
+	// the CID may still be pinned with the other mode
+	for _, other := range []pin.Mode{pin.Recursive, pin.Direct} {
+		if _, pinned, err := p.Pinner.IsPinnedWithType(ctx, c, other); err == nil && pinned {
+			return
+		}
+	}
+	p.deleteInfo(ctx, c)
+}
+
+func (p *pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	if err := p.Pinner.Update(ctx, from, to, unpin); err != nil {
+		return err
+	}
+	if from.Equals(to) {
+		return nil
+	}
+
+	info, err := p.store.Get(ctx, from)
+	if err != nil {
+		log.Errorf("reading the info of the pin of %s: %s", from, err)
+		return nil
+	}
+	if !info.IsZero() {
+		if err := p.store.Put(ctx, to, info); err != nil {
+			log.Errorf("moving the info of the pin of %s to %s: %s", from, to, err)
+			return nil
+		}
+	}
+	if unpin {
+		p.deleteInfo(ctx, from)
+	}
+	return nil
+}
+
+// deleteInfo deletes the info of a pin removed: the pin is already gone, so
+// the error is only logged.
+func (p *pinner) deleteInfo(ctx context.Context, c cid.Cid) {
+	if err := p.store.Delete(ctx, c); err != nil {
+		log.Errorf("deleting the info of the pin of %s: %s", c, err)
+	}
+}
+
+// ExpiryInterval is how often the daemon removes the expired pins.
+var ExpiryInterval = time.Minute
+
+// RemoveExpired removes the expired pins with the pinner, which must be
+// wrapped by NewPinner to delete their infos, and returns their CIDs.
+func (s *Store) RemoveExpired(ctx context.Context, p pin.Pinner, locker blockstore.GCLocker) ([]cid.Cid, error) {
+	infos, err := s.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var expired []cid.Cid
+	for c, info := range infos {
+		if info.Expired(now) {
+			expired = append(expired, c)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	// the lock keeps GC from running until the pins are flushed
+	defer locker.PinLock(ctx).Unlock(ctx)
+	var removed []cid.Cid
+	for _, c := range expired {
+		// unpinning recursively removes direct pins too
+		err = p.Unpin(ctx, c, true)
+		if err == pin.ErrNotPinned {
+			// the pin was removed without the wrapped pinner
+			err = s.Delete(ctx, c)
+		}
+		if err != nil {
+			break
+		}
+		removed = append(removed, c)
+	}
+	if ferr := p.Flush(ctx); err == nil {
+		err = ferr
+	}
+	return removed, err
+}
+
+// RemoveExpiredPeriodically removes the expired pins every ExpiryInterval,
+// until the context is done.
+func (s *Store) RemoveExpiredPeriodically(ctx context.Context, p pin.Pinner, locker blockstore.GCLocker) {
+	ticker := time.NewTicker(ExpiryInterval)
+	defer ticker.Stop()
+	for {
+		removed, err := s.RemoveExpired(ctx, p, locker)
+		for _, c := range removed {
+			log.Infof("removed the expired pin of %s", c)
+		}
+		if err != nil {
+			log.Errorf("removing the expired pins: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
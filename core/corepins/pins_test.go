@@ -0,0 +1,150 @@
+package corepins
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	dag "github.com/ipfs/go-merkledag"
+	mdutils "github.com/ipfs/go-merkledag/test"
+)
+
+func TestParseMetadata(t *testing.T) {
+	metadata, err := ParseMetadata([]string{"build=1234", "url=https://example.com/?a=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"build": "1234", "url": "https://example.com/?a=b", "empty": ""}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected %v, got %v", expected, metadata)
+	}
+	for _, invalid := range []string{"build", "=1234"} {
+		if _, err := ParseMetadata([]string{invalid}); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestPinnerKeepsInfos(t *testing.T) {
+	ctx := context.Background()
+	ds := syncds.MutexWrap(datastore.NewMapDatastore())
+	dserv := mdutils.Mock()
+	dp, err := dspinner.New(ctx, ds, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(ds)
+	p := NewPinner(dp, store)
+
+	a, b := dag.NodeWithData([]byte("a")), dag.NodeWithData([]byte("b"))
+	for _, nd := range []*dag.ProtoNode{a, b} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	info := Info{Name: "site", Metadata: map[string]string{"build": "1"}}
+	if err := store.Put(ctx, a.Cid(), info); err != nil {
+		t.Fatal(err)
+	}
+
+	// the info follows the pin updated
+	if err := p.Update(ctx, a.Cid(), b.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := store.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || !reflect.DeepEqual(infos[b.Cid()], info) {
+		t.Fatalf("expected the info to be moved to the new pin, got %v", infos)
+	}
+
+	// and is removed with it
+	if err := p.Unpin(ctx, b.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(ctx, b.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsZero() {
+		t.Errorf("expected the info to be removed with the pin, got %v", got)
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	ctx := context.Background()
+	ds := syncds.MutexWrap(datastore.NewMapDatastore())
+	dserv := mdutils.Mock()
+	dp, err := dspinner.New(ctx, ds, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(ds)
+	p := NewPinner(dp, store)
+
+	expired, kept := dag.NodeWithData([]byte("expired")), dag.NodeWithData([]byte("kept"))
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	for nd, expires := range map[*dag.ProtoNode]time.Time{expired: past, kept: future} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Pin(ctx, nd, false); err != nil {
+			t.Fatal(err)
+		}
+		expires := expires
+		if err := store.Put(ctx, nd.Cid(), Info{Expires: &expires}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := store.RemoveExpired(ctx, p, blockstore.NewGCLocker())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || !removed[0].Equals(expired.Cid()) {
+		t.Fatalf("expected only the expired pin to be removed, got %v", removed)
+	}
+	if _, pinned, _ := dp.IsPinned(ctx, expired.Cid()); pinned {
+		t.Error("expected the expired pin to be removed")
+	}
+	if _, pinned, _ := dp.IsPinned(ctx, kept.Cid()); !pinned {
+		t.Error("expected the pin to be kept until it expires")
+	}
+	infos, err := store.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := infos[expired.Cid()]; ok || len(infos) != 1 {
+		t.Errorf("expected only the info of the pin kept, got %v", infos)
+	}
+}
+
+func TestAddSettings(t *testing.T) {
+	ctx := context.Background()
+	if settings := AddSettingsFromContext(ctx); settings.Info != nil || settings.Progress != nil {
+		t.Fatalf("expected no settings, got %+v", settings)
+	}
+
+	info := &Info{Name: "preview"}
+	if err := info.SetExpiresIn(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	settings := AddSettingsFromContext(WithAddSettings(ctx, AddSettings{Info: info}))
+	if settings.Info != info || info.Expires == nil || info.Expired(time.Now()) {
+		t.Fatalf("unexpected settings %+v", settings)
+	}
+
+	if err := info.SetExpiresIn(-time.Hour); err == nil {
+		t.Error("expected negative expiries to be rejected")
+	}
+}
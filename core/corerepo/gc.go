@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreevents"
 	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/repo"
 
@@ -83,16 +84,62 @@ func BestEffortRoots(filesRoot *mfs.Root) ([]cid.Cid, error) {
 	return []cid.Cid{rootDag.Cid()}, nil
 }
 
-func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
+// gcRoots returns the best-effort roots of the node: the root of the files and
+// the DAGs of the recursive pins being fetched.
+func gcRoots(ctx context.Context, n *core.IpfsNode) ([]cid.Cid, error) {
 	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		return nil, err
+	}
+	fetches, err := n.PinFetches.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fetches {
+		roots = append(roots, f.Cid)
+	}
+	return roots, nil
+}
+
+func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
+	roots, err := gcRoots(ctx, n)
 	if err != nil {
 		return err
 	}
-	rmed := gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
+	rmed := collectGarbage(ctx, n, roots)
 
 	return CollectResult(ctx, rmed, nil)
 }
 
+// collectGarbage runs gc.GC, publishing its start and outcome on the events
+// of the node.
+func collectGarbage(ctx context.Context, n *core.IpfsNode, roots []cid.Cid) <-chan gc.Result {
+	n.Events.Publish(coreevents.GCStarted, nil)
+	start := time.Now()
+	rmed := gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
+
+	out := make(chan gc.Result, cap(rmed))
+	go func() {
+		defer close(out)
+		var data coreevents.GCData
+		// gc.GC closes its output when ctx is done
+		for res := range rmed {
+			if res.Error != nil {
+				data.Errors++
+			} else if res.KeyRemoved.Defined() {
+				data.Removed++
+			}
+			select {
+			case out <- res:
+			case <-ctx.Done():
+			}
+		}
+		data.Duration = time.Since(start)
+		n.Events.Publish(coreevents.GCFinished, &data)
+	}()
+	return out
+}
+
 // CollectResult collects the output of a garbage collection run and calls the
 // given callback for each object removed.  It also collects all errors into a
 // MultiError which is returned after the gc is completed.
@@ -148,7 +195,7 @@ func (e *MultiError) Error() string {
 }
 
 func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
-	roots, err := BestEffortRoots(n.FilesRoot)
+	roots, err := gcRoots(ctx, n)
 	if err != nil {
 		out := make(chan gc.Result)
 		out <- gc.Result{Error: err}
@@ -156,7 +203,7 @@ func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result
 		return out
 	}
 
-	return gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
+	return collectGarbage(ctx, n, roots)
 }
 
 func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/ipfs/go-bitswap"
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
@@ -23,8 +24,12 @@ import (
 	"github.com/ipld/go-ipld-prime"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"go.uber.org/fx"
 
+	"github.com/ipfs/go-ipfs/core/coreevents"
+	"github.com/ipfs/go-ipfs/core/corejobs"
+	"github.com/ipfs/go-ipfs/core/corepins"
 	"github.com/ipfs/go-ipfs/core/node/helpers"
 	"github.com/ipfs/go-ipfs/repo"
 )
@@ -42,8 +47,44 @@ func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interf
 	return bsvc
 }
 
+// PinFetches creates the fetcher of the DAGs of the recursive pins, which saves
+// their progress in the datastore until the node stops
+func PinFetches(lc fx.Lifecycle, repo repo.Repo, rem exchange.Interface) *corepins.Fetcher {
+	var recv func() map[peer.ID]uint64
+	if bs, ok := rem.(*bitswap.Bitswap); ok {
+		recv = func() map[peer.ID]uint64 {
+			st, err := bs.Stat()
+			if err != nil {
+				return nil
+			}
+			received := make(map[peer.ID]uint64, len(st.Peers))
+			for _, s := range st.Peers {
+				p, err := peer.Decode(s)
+				if err != nil {
+					continue
+				}
+				received[p] = bs.LedgerForPeer(p).Recv
+			}
+			return received
+		}
+	}
+	f := corepins.NewFetcher(repo.Datastore(), recv)
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			f.Close()
+			return nil
+		},
+	})
+	return f
+}
+
+// PinStore creates the store of the names and metadata of the pins
+func PinStore(repo repo.Repo) *corepins.Store {
+	return corepins.NewStore(repo.Datastore())
+}
+
 // Pinning creates new pinner which tells GC which blocks should be kept
-func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo) (pin.Pinner, error) {
+func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo, pins *corepins.Store, events *coreevents.Bus) (pin.Pinner, error) {
 	rootDS := repo.Datastore()
 
 	syncFn := func(ctx context.Context) error {
@@ -61,7 +102,7 @@ func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo)
 		return nil, err
 	}
 
-	return pinning, nil
+	return &eventsPinner{corepins.NewPinner(pinning, pins), events}, nil
 }
 
 var (
@@ -109,7 +150,7 @@ func Dag(bs blockservice.BlockService) format.DAGService {
 }
 
 // Files loads persisted MFS root
-func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.DAGService) (*mfs.Root, error) {
+func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.DAGService, events *coreevents.Bus) (*mfs.Root, error) {
 	dsk := datastore.NewKey("/local/filesroot")
 	pf := func(ctx context.Context, c cid.Cid) error {
 		rootDS := repo.Datastore()
@@ -123,7 +164,11 @@ func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.
 		if err := rootDS.Put(ctx, dsk, c.Bytes()); err != nil {
 			return err
 		}
-		return rootDS.Sync(ctx, dsk)
+		if err := rootDS.Sync(ctx, dsk); err != nil {
+			return err
+		}
+		events.Publish(coreevents.FilesRootChanged, &coreevents.FilesRootData{Cid: c})
+		return nil
 	}
 
 	var nd *merkledag.ProtoNode
@@ -168,3 +213,19 @@ func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.
 
 	return root, err
 }
+
+// Jobs creates the manager of the jobs submitted with `ipfs jobs`
+func Jobs(lc fx.Lifecycle, repo repo.Repo) (*corejobs.Manager, error) {
+	jobs, err := corejobs.NewManager(repo.Datastore())
+	if err != nil {
+		return nil, err
+	}
+
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return jobs.Close()
+		},
+	})
+
+	return jobs, nil
+}
@@ -0,0 +1,195 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-namesys"
+	path "github.com/ipfs/go-path"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/go-ipfs/core/coreevents"
+)
+
+// Events creates the bus of the events streamed by `ipfs events subscribe`
+func Events() *coreevents.Bus {
+	return coreevents.NewBus()
+}
+
+// PeerEvents publishes the connections and disconnections of the peers
+func PeerEvents(lc fx.Lifecycle, host host.Host, events *coreevents.Bus) {
+	notifee := &network.NotifyBundle{
+		ConnectedF: func(net network.Network, conn network.Conn) {
+			// only the first connection to the peer
+			if len(net.ConnsToPeer(conn.RemotePeer())) != 1 {
+				return
+			}
+			events.Publish(coreevents.PeerConnected, peerData(conn))
+		},
+		DisconnectedF: func(net network.Network, conn network.Conn) {
+			// only the last connection to the peer
+			if net.Connectedness(conn.RemotePeer()) == network.Connected {
+				return
+			}
+			events.Publish(coreevents.PeerDisconnected, peerData(conn))
+		},
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			host.Network().Notify(notifee)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			host.Network().StopNotify(notifee)
+			return nil
+		},
+	})
+}
+
+func peerData(conn network.Conn) *coreevents.PeerData {
+	return &coreevents.PeerData{
+		Peer:      conn.RemotePeer(),
+		Addr:      conn.RemoteMultiaddr().String(),
+		Direction: conn.Stat().Direction.String(),
+	}
+}
+
+// eventsPinner publishes the pins added and removed
+type eventsPinner struct {
+	pin.Pinner
+	events *coreevents.Bus
+}
+
+func (p *eventsPinner) published(t coreevents.Type, c cid.Cid, mode pin.Mode) {
+	m, _ := pin.ModeToString(mode)
+	p.events.Publish(t, &coreevents.PinData{Cid: c, Mode: m})
+}
+
+func recursiveMode(recursive bool) pin.Mode {
+	if recursive {
+		return pin.Recursive
+	}
+	return pin.Direct
+}
+
+func (p *eventsPinner) Pin(ctx context.Context, node ipld.Node, recursive bool) error {
+	if err := p.Pinner.Pin(ctx, node, recursive); err != nil {
+		return err
+	}
+	p.published(coreevents.PinAdded, node.Cid(), recursiveMode(recursive))
+	return nil
+}
+
+func (p *eventsPinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	// unpinning a direct pin recursively is allowed
+	mode := pin.Direct
+	if recursive {
+		if _, pinned, err := p.Pinner.IsPinnedWithType(ctx, c, pin.Recursive); err == nil && pinned {
+			mode = pin.Recursive
+		}
+	}
+	if err := p.Pinner.Unpin(ctx, c, recursive); err != nil {
+		return err
+	}
+	p.published(coreevents.PinRemoved, c, mode)
+	return nil
+}
+
+func (p *eventsPinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	if err := p.Pinner.Update(ctx, from, to, unpin); err != nil {
+		return err
+	}
+	p.published(coreevents.PinAdded, to, pin.Recursive)
+	if unpin {
+		p.published(coreevents.PinRemoved, from, pin.Recursive)
+	}
+	return nil
+}
+
+func (p *eventsPinner) PinWithMode(c cid.Cid, mode pin.Mode) {
+	p.Pinner.PinWithMode(c, mode)
+	p.published(coreevents.PinAdded, c, mode)
+}
+
+func (p *eventsPinner) RemovePinWithMode(c cid.Cid, mode pin.Mode) {
+	p.Pinner.RemovePinWithMode(c, mode)
+	p.published(coreevents.PinRemoved, c, mode)
+}
+
+// eventsNamesys publishes the IPNS names published
+type eventsNamesys struct {
+	namesys.NameSystem
+	events *coreevents.Bus
+}
+
+func (ns *eventsNamesys) published(name crypto.PrivKey, value path.Path, eol time.Time) {
+	id, err := peer.IDFromPrivateKey(name)
+	if err != nil {
+		return
+	}
+	ns.events.Publish(coreevents.NamePublished, &coreevents.NameData{
+		Name:  peer.ToCid(id).String(),
+		Value: value.String(),
+		EOL:   eol,
+	})
+}
+
+func (ns *eventsNamesys) Publish(ctx context.Context, name crypto.PrivKey, value path.Path) error {
+	if err := ns.NameSystem.Publish(ctx, name, value); err != nil {
+		return err
+	}
+	ns.published(name, value, time.Time{})
+	return nil
+}
+
+func (ns *eventsNamesys) PublishWithEOL(ctx context.Context, name crypto.PrivKey, value path.Path, eol time.Time) error {
+	if err := ns.NameSystem.PublishWithEOL(ctx, name, value, eol); err != nil {
+		return err
+	}
+	ns.published(name, value, eol)
+	return nil
+}
+
+// eventsContentRouting publishes the outcome of the provides
+type eventsContentRouting struct {
+	routing.ContentRouting
+	events    *coreevents.Bus
+	reprovide bool
+}
+
+func (cr *eventsContentRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	err := cr.ContentRouting.Provide(ctx, c, announce)
+	publishProvide(cr.events, c, cr.reprovide, err)
+	return err
+}
+
+// eventsProvideMany publishes the outcome of the batched provides
+type eventsProvideMany struct {
+	provideMany
+	events *coreevents.Bus
+}
+
+func (pm *eventsProvideMany) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	err := pm.provideMany.ProvideMany(ctx, keys)
+	for _, key := range keys {
+		publishProvide(pm.events, cid.NewCidV1(cid.Raw, key), false, err)
+	}
+	return err
+}
+
+func publishProvide(events *coreevents.Bus, c cid.Cid, reprovide bool, err error) {
+	if err != nil {
+		events.Publish(coreevents.ProvideFailed, &coreevents.ProvideData{Cid: c, Reprovide: reprovide, Error: err.Error()})
+		return
+	}
+	events.Publish(coreevents.ProvideSucceeded, &coreevents.ProvideData{Cid: c, Reprovide: reprovide})
+}
@@ -291,6 +291,7 @@ func Online(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		fx.Provide(Namesys(ipnsCacheSize)),
 		fx.Provide(Peering),
 		PeerWith(cfg.Peering.Peers...),
+		fx.Invoke(PeerEvents),
 
 		fx.Invoke(IpnsRepublisher(repubPeriod, recordLifetime)),
 
@@ -314,11 +315,15 @@ func Offline(cfg *config.Config) fx.Option {
 
 // Core groups basic IPFS services
 var Core = fx.Options(
+	fx.Provide(Events),
 	fx.Provide(BlockService),
 	fx.Provide(Dag),
 	fx.Provide(FetcherConfig),
+	fx.Provide(PinStore),
 	fx.Provide(Pinning),
+	fx.Provide(PinFetches),
 	fx.Provide(Files),
+	fx.Provide(Jobs),
 )
 
 func Networked(bcfg *BuildCfg, cfg *config.Config) fx.Option {
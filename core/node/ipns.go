@@ -12,6 +12,7 @@ import (
 	"github.com/libp2p/go-libp2p-record"
 	madns "github.com/multiformats/go-multiaddr-dns"
 
+	"github.com/ipfs/go-ipfs/core/coreevents"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-namesys"
 	"github.com/ipfs/go-namesys/republisher"
@@ -28,8 +29,8 @@ func RecordValidator(ps peerstore.Peerstore) record.Validator {
 }
 
 // Namesys creates new name system
-func Namesys(cacheSize int) func(rt routing.Routing, rslv *madns.Resolver, repo repo.Repo) (namesys.NameSystem, error) {
-	return func(rt routing.Routing, rslv *madns.Resolver, repo repo.Repo) (namesys.NameSystem, error) {
+func Namesys(cacheSize int) func(rt routing.Routing, rslv *madns.Resolver, repo repo.Repo, events *coreevents.Bus) (namesys.NameSystem, error) {
+	return func(rt routing.Routing, rslv *madns.Resolver, repo repo.Repo, events *coreevents.Bus) (namesys.NameSystem, error) {
 		opts := []namesys.Option{
 			namesys.WithDatastore(repo.Datastore()),
 			namesys.WithDNSResolver(rslv),
@@ -39,7 +40,11 @@ func Namesys(cacheSize int) func(rt routing.Routing, rslv *madns.Resolver, repo
 			opts = append(opts, namesys.WithCache(cacheSize))
 		}
 
-		return namesys.NewNameSystem(rt, opts...)
+		ns, err := namesys.NewNameSystem(rt, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &eventsNamesys{ns, events}, nil
 	}
 }
 
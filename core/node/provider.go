@@ -15,6 +15,7 @@ import (
 	"github.com/multiformats/go-multihash"
 	"go.uber.org/fx"
 
+	"github.com/ipfs/go-ipfs/core/coreevents"
 	"github.com/ipfs/go-ipfs/core/node/helpers"
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
 	"github.com/ipfs/go-ipfs/repo"
@@ -30,14 +31,15 @@ func ProviderQueue(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo) (*q
 }
 
 // SimpleProvider creates new record provider
-func SimpleProvider(mctx helpers.MetricsCtx, lc fx.Lifecycle, queue *q.Queue, rt routing.Routing) provider.Provider {
-	return simple.NewProvider(helpers.LifecycleCtx(mctx, lc), queue, rt)
+func SimpleProvider(mctx helpers.MetricsCtx, lc fx.Lifecycle, queue *q.Queue, rt routing.Routing, events *coreevents.Bus) provider.Provider {
+	return simple.NewProvider(helpers.LifecycleCtx(mctx, lc), queue, &eventsContentRouting{ContentRouting: rt, events: events})
 }
 
 // SimpleReprovider creates new reprovider
 func SimpleReprovider(reproviderInterval time.Duration) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, rt routing.Routing, keyProvider simple.KeyChanFunc) (provider.Reprovider, error) {
-		return simple.NewReprovider(helpers.LifecycleCtx(mctx, lc), reproviderInterval, rt, keyProvider), nil
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, rt routing.Routing, keyProvider simple.KeyChanFunc, events *coreevents.Bus) (provider.Reprovider, error) {
+		cr := &eventsContentRouting{ContentRouting: rt, events: events, reprovide: true}
+		return simple.NewReprovider(helpers.LifecycleCtx(mctx, lc), reproviderInterval, cr, keyProvider), nil
 	}
 }
 
@@ -69,11 +71,12 @@ type provideMany interface {
 
 // BatchedProviderSys creates new provider system
 func BatchedProviderSys(isOnline bool, reprovideInterval string) interface{} {
-	return func(lc fx.Lifecycle, cr libp2p.BaseIpfsRouting, q *q.Queue, keyProvider simple.KeyChanFunc, repo repo.Repo) (provider.System, error) {
-		r, ok := (cr).(provideMany)
+	return func(lc fx.Lifecycle, cr libp2p.BaseIpfsRouting, q *q.Queue, keyProvider simple.KeyChanFunc, repo repo.Repo, events *coreevents.Bus) (provider.System, error) {
+		pm, ok := (cr).(provideMany)
 		if !ok {
 			return nil, fmt.Errorf("BatchedProviderSys requires a content router that supports provideMany")
 		}
+		r := &eventsProvideMany{pm, events}
 
 		reprovideIntervalDuration := kReprovideFrequency
 		if reprovideInterval != "" {
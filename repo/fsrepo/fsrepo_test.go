@@ -113,6 +113,8 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	r2, err := Open(path)
 	assert.Nil(err, t, "second repo should open successfully")
 	assert.True(r1 == r2, t, "second open returns same value")
+	p, ok := r1.(interface{ Path() string })
+	assert.True(ok && p.Path() == path, t, "the repo opened has the path")
 
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
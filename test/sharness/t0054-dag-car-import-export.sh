@@ -263,4 +263,26 @@ test_expect_success "version 2 import output as expected" '
   test_cmp_sorted version_2_import_expected version_2_import_actual
 '
 
+test_expect_success "version 2 export" '
+  ipfs dag export --car-version=2 bafy2bzaced4ueelaegfs5fqu4tzsh6ywbbpfk3cxppupmxfdhbpbhzawfw5oy > exported_v2.car
+'
+
+test_expect_success "version 2 export starts with the CARv2 pragma" '
+  printf "\x0a\xa1\x67\x76\x65\x72\x73\x69\x6f\x6e\x02" > carv2_pragma_expected &&
+  head -c 11 exported_v2.car > carv2_pragma_actual &&
+  test_cmp carv2_pragma_expected carv2_pragma_actual
+'
+
+test_expect_success "version 2 export can be imported" '
+  ipfs pin rm bafy2bzaced4ueelaegfs5fqu4tzsh6ywbbpfk3cxppupmxfdhbpbhzawfw5oy &&
+  ipfs repo gc > /dev/null &&
+  ipfs dag import --stats --enc=json exported_v2.car > version_2_reimport_actual &&
+  grep "bafy2bzaced4ueelaegfs5fqu4tzsh6ywbbpfk3cxppupmxfdhbpbhzawfw5oy" version_2_reimport_actual
+'
+
+test_expect_success "unsupported export version fails" '
+  test_expect_code 1 ipfs dag export --car-version=3 bafy2bzaced4ueelaegfs5fqu4tzsh6ywbbpfk3cxppupmxfdhbpbhzawfw5oy 2> export_version_error &&
+  grep "unsupported CAR version 3" export_version_error
+'
+
 test_done
@@ -0,0 +1,84 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs pin export and import"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "pin some content with names" '
+  mkdir dir &&
+  echo "hello" > dir/a &&
+  echo "world" > dir/b &&
+  HASH_DIR=$(ipfs add -Qr --pin=false dir) &&
+  HASH_BLK=$(echo "direct" | ipfs add -Q --pin=false) &&
+  ipfs pin add --name=dir $HASH_DIR &&
+  ipfs pin add --recursive=false --name=blk $HASH_BLK
+'
+
+test_expect_success "save the pins" '
+  ipfs pin ls --type=recursive | sort > recursive_expected &&
+  ipfs pin ls --type=direct > direct_expected &&
+  grep "$HASH_DIR recursive dir" recursive_expected &&
+  echo "$HASH_BLK direct blk" | test_cmp - direct_expected
+'
+
+test_expect_success "export the pins" '
+  ipfs pin export > pins.json &&
+  ipfs pin export --car > pins.car
+'
+
+test_expect_success "remove the pins and their blocks" '
+  ipfs pin rm $HASH_DIR $HASH_BLK &&
+  ipfs repo gc > /dev/null &&
+  test_must_fail ipfs block stat --offline $HASH_DIR
+'
+
+test_expect_success "importing a manifest without its blocks fails" '
+  ipfs pin ls --type=recursive | sort > recursive_before &&
+  test_must_fail ipfs pin import --offline pins.json 2> import_err &&
+  grep "no pin was imported" import_err
+'
+
+test_expect_success "no pin was imported" '
+  ipfs pin ls --type=recursive | sort > recursive_actual &&
+  test_cmp recursive_before recursive_actual &&
+  ipfs pin ls --type=direct > direct_actual &&
+  test_must_be_empty direct_actual
+'
+
+test_expect_success "import the pins from a CAR" '
+  ipfs pin import pins.car > import_out &&
+  grep "imported $HASH_DIR recursive dir" import_out &&
+  grep "imported $HASH_BLK direct blk" import_out
+'
+
+test_expect_success "the pins and their names are restored from the CAR" '
+  ipfs pin ls --type=recursive | sort > recursive_actual &&
+  test_cmp recursive_expected recursive_actual &&
+  ipfs pin ls --type=direct > direct_actual &&
+  test_cmp direct_expected direct_actual
+'
+
+test_expect_success "remove the pins but keep their blocks" '
+  ipfs pin rm $HASH_DIR $HASH_BLK &&
+  ipfs pin ls --type=direct > direct_actual &&
+  test_must_be_empty direct_actual
+'
+
+test_expect_success "import the pins from the manifest alone" '
+  ipfs pin import pins.json > import_out &&
+  grep "imported $HASH_DIR recursive dir" import_out
+'
+
+test_expect_success "the pins and their names are restored from the manifest" '
+  ipfs pin ls --type=recursive | sort > recursive_actual &&
+  test_cmp recursive_expected recursive_actual &&
+  ipfs pin ls --type=direct > direct_actual &&
+  test_cmp direct_expected direct_actual
+'
+
+test_done
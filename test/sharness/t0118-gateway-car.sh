@@ -34,37 +34,51 @@ test_launch_ipfs_daemon_without_network
     '
 
 # GET unixfs file as CAR
-# (by using a single file we ensure deterministic result that can be compared byte-for-byte)
+# (by using a single file CID we ensure deterministic result that can be compared byte-for-byte)
 
     test_expect_success "GET with format=car param returns a CARv1 stream" '
     ipfs dag import test-dag.car &&
-    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt?format=car" -o gateway-param.car &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID?format=car" -o gateway-param.car &&
     test_cmp deterministic.car gateway-param.car
     '
 
     test_expect_success "GET for application/vnd.ipld.car returns a CARv1 stream" '
     ipfs dag import test-dag.car &&
-    curl -sX GET -H "Accept: application/vnd.ipld.car" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" -o gateway-header.car &&
+    curl -sX GET -H "Accept: application/vnd.ipld.car" "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID" -o gateway-header.car &&
     test_cmp deterministic.car gateway-header.car
     '
 
     # explicit version=1
     test_expect_success "GET for application/vnd.ipld.raw version=1 returns a CARv1 stream" '
     ipfs dag import test-dag.car &&
-    curl -sX GET -H "Accept: application/vnd.ipld.car;version=1" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" -o gateway-header-v1.car &&
+    curl -sX GET -H "Accept: application/vnd.ipld.car;version=1" "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID" -o gateway-header-v1.car &&
     test_cmp deterministic.car gateway-header-v1.car
     '
 
     # explicit version=1 with whitepace
     test_expect_success "GET for application/vnd.ipld.raw version=1 returns a CARv1 stream (with whitespace)" '
     ipfs dag import test-dag.car &&
-    curl -sX GET -H "Accept: application/vnd.ipld.car; version=1" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" -o gateway-header-v1.car &&
+    curl -sX GET -H "Accept: application/vnd.ipld.car; version=1" "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID" -o gateway-header-v1.car &&
     test_cmp deterministic.car gateway-header-v1.car
     '
 
     # explicit version=2
-    test_expect_success "GET for application/vnd.ipld.raw version=2 returns HTTP 400 Bad Request error" '
-    curl -svX GET -H "Accept: application/vnd.ipld.car;version=2" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" > curl_output 2>&1 &&
+    test_expect_success "GET for application/vnd.ipld.car version=2 returns an indexed CARv2 stream" '
+    ipfs dag import test-dag.car &&
+    curl -svX GET -H "Accept: application/vnd.ipld.car;version=2" "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID" -o gateway-header-v2.car 2> curl_output &&
+    grep "< Content-Type: application/vnd.ipld.car; version=2" curl_output &&
+    printf "\x0a\xa1\x67\x76\x65\x72\x73\x69\x6f\x6e\x02" > carv2_pragma_expected &&
+    head -c 11 gateway-header-v2.car > carv2_pragma_actual &&
+    test_cmp carv2_pragma_expected carv2_pragma_actual &&
+    purge_blockstore &&
+    ipfs dag import gateway-header-v2.car &&
+    ipfs cat --offline $FILE_CID > v2_output &&
+    test_cmp subdir/ascii.txt v2_output
+    '
+
+    # explicit version=3
+    test_expect_success "GET for application/vnd.ipld.car version=3 returns HTTP 400 Bad Request error" '
+    curl -svX GET -H "Accept: application/vnd.ipld.car;version=3" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" > curl_output 2>&1 &&
     cat curl_output &&
     grep "400 Bad Request" curl_output &&
     grep "unsupported CAR version" curl_output
@@ -72,7 +86,6 @@ test_launch_ipfs_daemon_without_network
 
 # GET unixfs directory as a CAR with DAG and some selector
 
-    # TODO: this is basic test for "full" selector, we will add support for custom ones in https://github.com/ipfs/go-ipfs/issues/8769
     test_expect_success "GET for application/vnd.ipld.car with unixfs dir returns a CARv1 stream with full DAG" '
     ipfs dag import test-dag.car &&
     curl -sX GET -H "Accept: application/vnd.ipld.car" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID" -o gateway-dir.car &&
@@ -81,6 +94,34 @@ test_launch_ipfs_daemon_without_network
     ipfs dag stat --offline $ROOT_DIR_CID
     '
 
+# GET path-scoped CARs
+
+    test_expect_success "GET for application/vnd.ipld.car with subpath returns a CAR rooted at the path root" '
+    ipfs dag import test-dag.car &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt?format=car" -o gateway-path.car &&
+    purge_blockstore &&
+    ipfs dag import gateway-path.car > import_output &&
+    grep "$ROOT_DIR_CID" import_output &&
+    ipfs cat --offline /ipfs/$ROOT_DIR_CID/subdir/ascii.txt > path_output &&
+    test_cmp subdir/ascii.txt path_output
+    '
+
+    test_expect_success "GET with dag-scope=block returns only the blocks along the path" '
+    ipfs dag import test-dag.car &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir?format=car&dag-scope=block" -o gateway-block.car &&
+    purge_blockstore &&
+    ipfs dag import --pin-roots=false gateway-block.car &&
+    ipfs block stat --offline $ROOT_DIR_CID &&
+    ipfs block stat --offline /ipfs/$ROOT_DIR_CID/subdir &&
+    test_expect_code 1 ipfs block stat --offline $FILE_CID
+    '
+
+    test_expect_success "GET with invalid dag-scope returns HTTP 400 Bad Request error" '
+    curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID?format=car&dag-scope=nope" > curl_output 2>&1 &&
+    grep "400 Bad Request" curl_output &&
+    grep "invalid CAR request parameters" curl_output
+    '
+
 # Make sure expected HTTP headers are returned with the CAR bytes
 
     test_expect_success "GET response for application/vnd.ipld.car has expected Content-Type" '
@@ -112,8 +153,13 @@ test_launch_ipfs_daemon_without_network
 
 # Cache control HTTP headers
 
-    test_expect_success "GET response for application/vnd.ipld.car includes a weak Etag" '
-    grep "< Etag: W/\"${FILE_CID}.car\"" curl_output
+    test_expect_success "GET response for application/vnd.ipld.car with subpath includes a weak Etag" '
+    grep "< Etag: W/\"${FILE_CID}.car.[0-9a-f]*\"" curl_output
+    '
+
+    test_expect_success "GET response for application/vnd.ipld.car without subpath includes a weak Etag" '
+    curl -svX GET -H "Accept: application/vnd.ipld.car" "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID" >/dev/null 2>curl_output_cid &&
+    grep "< Etag: W/\"${FILE_CID}.car\"" curl_output_cid
     '
 
     # (basic checks, detailed behavior for some fields is tested in  t0116-gateway-cache.sh)